@@ -0,0 +1,88 @@
+package dht
+
+// announceMaxRetries is how many times Announce re-fetches the token and
+// retries a node which rejects the token.
+const announceMaxRetries = 1
+
+// Announce announces that the node is a peer of infoHash listening on port.
+// It sends get_peers to the K closest nodes to acquire their tokens, waits
+// for the responses, then sends announce_peer with the tokens to the nodes
+// which returned one. If a node rejects the token, e.g. it has expired, a
+// fresh token is acquired and the announce is retried at most
+// announceMaxRetries times. If impliedPort is true, the receivers use the
+// source port of the udp packet instead of port. It blocks until all the
+// announces finish. It returns ErrNoAnnounceToken if no node returns a
+// token, and ErrAnnounceFailed if no node accepts the announce.
+func (dht *DHT) Announce(infoHash string, port int, impliedPort bool) error {
+	if !dht.Ready {
+		return ErrNotReady
+	}
+
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return err
+	}
+	infoHash = ih.Raw()
+
+	implied := 0
+	if impliedPort {
+		implied = 1
+	}
+
+	queries := dht.announceTo(dht.routingTable.GetNeighbors(
+		newBitmapFromString(infoHash), dht.K), infoHash, implied, port)
+
+	if len(queries) == 0 {
+		return ErrNoAnnounceToken
+	}
+
+	announced := 0
+	for retry := 0; retry <= announceMaxRetries; retry++ {
+		rejected := make([]Node, 0, len(queries))
+		for _, q := range queries {
+			<-q.done
+			if q.responded && q.err == nil {
+				announced++
+			} else if isTokenRejection(q.err) {
+				rejected = append(rejected, q.Node)
+			}
+		}
+
+		if len(rejected) == 0 || retry == announceMaxRetries {
+			break
+		}
+		queries = dht.announceTo(rejected, infoHash, implied, port)
+	}
+
+	if announced == 0 {
+		return ErrAnnounceFailed
+	}
+	return nil
+}
+
+// announceTo sends get_peers to nodes, waits for the responses, then sends
+// announce_peer to the nodes which returned a token. It returns the
+// announce_peer queries.
+func (dht *DHT) announceTo(nodes []Node, infoHash string, implied, port int) []*Query {
+	gets := make([]*Query, 0, len(nodes))
+	for _, no := range nodes {
+		if q := dht.transactionManager.getPeers(no, infoHash); q != nil {
+			gets = append(gets, q)
+		}
+	}
+
+	announces := make([]*Query, 0, len(gets))
+	for _, q := range gets {
+		<-q.done
+		if q.token == "" {
+			continue
+		}
+
+		if a := dht.transactionManager.AnnouncePeer(
+			q.Node, infoHash, implied, port, q.token); a != nil {
+
+			announces = append(announces, a)
+		}
+	}
+	return announces
+}
@@ -0,0 +1,120 @@
+package dht
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AnnounceEvent represents an announce_peer request received from a peer.
+type AnnounceEvent struct {
+	InfoHash string
+	IP       net.IP
+	Port     int
+	Time     time.Time
+	// the result of Config.EnrichPeer, nil if it's not set
+	Tags map[string]string
+}
+
+// announceStream buffers the announce events for the chan returned by
+// AnnounceEvents. If enrich is set, the events are enriched by the enrich
+// workers before they're pushed to the chan.
+type announceStream struct {
+	enabled int32
+	dropped uint64
+	once    sync.Once
+	enrich  func(ip net.IP) map[string]string
+	workers int
+	clock   Clock
+	pending chan AnnounceEvent
+	events  chan AnnounceEvent
+}
+
+// newAnnounceStream returns an announceStream pointer buffering at most
+// AnnounceBufferSize events. If EnrichPeer is set, the events are enriched by
+// EnrichWorkers goroutines. It emits nothing until start is called.
+func newAnnounceStream(config *Config) *announceStream {
+	size := config.AnnounceBufferSize
+
+	as := &announceStream{
+		clock:  config.Clock,
+		events: make(chan AnnounceEvent, size),
+	}
+	if config.EnrichPeer != nil && config.EnrichWorkers > 0 {
+		as.enrich, as.workers = config.EnrichPeer, config.EnrichWorkers
+		as.pending = make(chan AnnounceEvent, size)
+	}
+	return as
+}
+
+// start enables the stream and starts the enrich workers, which run until
+// done is closed.
+func (as *announceStream) start(done <-chan struct{}) {
+	as.once.Do(func() {
+		for i := 0; i < as.workers; i++ {
+			go as.runEnrich(done)
+		}
+		atomic.StoreInt32(&as.enabled, 1)
+	})
+}
+
+// runEnrich enriches the pending events and pushes them to the chan.
+func (as *announceStream) runEnrich(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-as.pending:
+			e.Tags = as.enrich(e.IP)
+			as.push(as.events, e)
+		}
+	}
+}
+
+// push pushes the event to ch. When ch is full, the event is dropped.
+func (as *announceStream) push(ch chan AnnounceEvent, e AnnounceEvent) {
+	select {
+	case ch <- e:
+	default:
+		atomic.AddUint64(&as.dropped, 1)
+	}
+}
+
+// emit pushes the event to the chan, or to the enrich workers if enrich is
+// set. It never blocks, the event is dropped if the chan is full.
+func (as *announceStream) emit(infoHash string, ip net.IP, port int) {
+	if atomic.LoadInt32(&as.enabled) == 0 {
+		return
+	}
+
+	e := AnnounceEvent{
+		InfoHash: infoHash,
+		IP:       ip,
+		Port:     port,
+		Time:     as.clock.Now(),
+	}
+
+	if as.enrich != nil {
+		as.push(as.pending, e)
+	} else {
+		as.push(as.events, e)
+	}
+}
+
+// AnnounceEvents returns a chan of every valid announce_peer request
+// received. The first call starts the stream, the events before it aren't
+// buffered. If the consumer is too slow and the chan is full, events are
+// dropped and counted by DroppedAnnounceEvents. If Config.EnrichPeer is set,
+// the events carry its result in Tags. The chan is never closed.
+func (dht *DHT) AnnounceEvents() <-chan AnnounceEvent {
+	dht.announceStream.start(dht.done)
+	return dht.announceStream.events
+}
+
+// DroppedAnnounceEvents returns how many announce events are dropped because
+// the chan returned by AnnounceEvents, or the queue of the enrich workers, is
+// full.
+func (dht *DHT) DroppedAnnounceEvents() uint64 {
+	return atomic.LoadUint64(&dht.announceStream.dropped)
+}
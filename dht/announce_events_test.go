@@ -0,0 +1,55 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAnnounceStream(t *testing.T) {
+	config := NewStandardConfig()
+	config.AnnounceBufferSize = 2
+
+	as := newAnnounceStream(config)
+
+	// nothing is emitted before the stream starts.
+	as.emit("a", net.IPv4(1, 1, 1, 1), 6881)
+	if len(as.events) != 0 {
+		t.Fatal("event emitted before start")
+	}
+
+	as.start(nil)
+	for _, infoHash := range []string{"a", "b", "c"} {
+		as.emit(infoHash, net.IPv4(1, 1, 1, 1), 6881)
+	}
+
+	e := <-as.events
+	if e.InfoHash != "a" || e.Port != 6881 || e.Time.IsZero() ||
+		len(as.events) != 1 || as.dropped != 1 {
+		t.Errorf("unexpected event %+v, dropped %d", e, as.dropped)
+	}
+}
+
+func TestAnnounceStreamEnrich(t *testing.T) {
+	config := NewStandardConfig()
+	config.EnrichWorkers = 2
+	config.EnrichPeer = func(ip net.IP) map[string]string {
+		return map[string]string{"ip": ip.String()}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	as := newAnnounceStream(config)
+	as.start(done)
+	as.emit("a", net.IPv4(1, 1, 1, 1), 6881)
+
+	select {
+	case e := <-as.events:
+		if e.InfoHash != "a" || e.Tags["ip"] != "1.1.1.1" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("event not enriched")
+	}
+}
@@ -0,0 +1,159 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// writeBatchMax is the max number of the packets written in a batch.
+const writeBatchMax = 64
+
+// errAddressFamily is returned when an address can't be sent to by the
+// socket, i.e. an IPv6 address by an IPv4 socket.
+var errAddressFamily = errors.New("address family not supported by socket")
+
+// batchConn writes a batch of messages, which is sendmmsg(2) on linux and
+// one write per message elsewhere. Both ipv4.PacketConn and ipv6.PacketConn
+// implement it.
+type batchConn interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// outPacket represents an outgoing packet.
+type outPacket struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// batchWriter holds the outgoing packets for a window and writes them
+// together by writeBatch. A batch is written when the window since its
+// first packet elapses or when it's full.
+type batchWriter struct {
+	// batches is how many batches are written, it's kept first for
+	// alignment.
+	batches uint64
+	conn    *net.UDPConn
+	batch   batchConn
+	ipv4    bool
+	window  time.Duration
+	packets chan outPacket
+	done    chan struct{}
+	onError func(addr *net.UDPAddr, err error)
+}
+
+// newBatchWriter returns a batchWriter pointer. onError is called with the
+// address of every packet failed to write.
+func newBatchWriter(conn *net.UDPConn, window time.Duration,
+	done chan struct{}, onError func(*net.UDPAddr, error)) *batchWriter {
+
+	bw := &batchWriter{
+		conn:    conn,
+		window:  window,
+		packets: make(chan outPacket, writeBatchMax*4),
+		done:    done,
+		onError: onError,
+	}
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		bw.batch, bw.ipv4 = ipv4.NewPacketConn(conn), true
+	} else {
+		bw.batch = ipv6.NewPacketConn(conn)
+	}
+	return bw
+}
+
+// write queues a packet. It blocks while the queue is full, and drops the
+// packet if the writer is stopped.
+func (bw *batchWriter) write(data []byte, addr *net.UDPAddr) {
+	select {
+	case bw.packets <- outPacket{data: data, addr: addr}:
+	case <-bw.done:
+	}
+}
+
+// run collects the queued packets into batches and writes them until done
+// is closed.
+func (bw *batchWriter) run() {
+	batch := make([]outPacket, 0, writeBatchMax)
+
+	for {
+		select {
+		case <-bw.done:
+			return
+		case p := <-bw.packets:
+			batch = append(batch[:0], p)
+		}
+
+		timer := time.NewTimer(bw.window)
+	Fill:
+		for len(batch) < writeBatchMax {
+			select {
+			case p := <-bw.packets:
+				batch = append(batch, p)
+			case <-timer.C:
+				break Fill
+			case <-bw.done:
+				timer.Stop()
+				return
+			}
+		}
+		timer.Stop()
+
+		bw.flush(batch)
+	}
+}
+
+// flush writes the batch. A packet failed to write is reported and skipped,
+// then the rest are written.
+func (bw *batchWriter) flush(batch []outPacket) {
+	bw.conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+
+	for len(batch) > 0 {
+		atomic.AddUint64(&bw.batches, 1)
+
+		n, err := bw.writeBatch(batch)
+		if err == nil {
+			return
+		}
+
+		bw.onError(batch[n].addr, err)
+		batch = batch[n+1:]
+	}
+}
+
+// writeBatch writes the packets. It returns the index of the first packet
+// failed to write and the error, or len(batch) and nil.
+func (bw *batchWriter) writeBatch(batch []outPacket) (int, error) {
+	msgs := make([]ipv4.Message, 0, len(batch))
+
+	for i, p := range batch {
+		if bw.ipv4 && p.addr.IP.To4() == nil {
+			if sent, err := bw.send(msgs); err != nil {
+				return sent, err
+			}
+			return i, errAddressFamily
+		}
+		msgs = append(msgs, ipv4.Message{Buffers: [][]byte{p.data}, Addr: p.addr})
+	}
+
+	return bw.send(msgs)
+}
+
+// send writes the messages until all are written or one fails. It returns
+// how many are written and the error of the failed one.
+func (bw *batchWriter) send(msgs []ipv4.Message) (int, error) {
+	sent := 0
+	for sent < len(msgs) {
+		n, err := bw.batch.WriteBatch(msgs[sent:], 0)
+		if err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
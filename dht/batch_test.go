@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refreshStorm is how many find_node queries a refresh sends at once in the
+// benchmarks.
+const refreshStorm = 256
+
+func newBatchConns(tb testing.TB, n int) (*net.UDPConn, []*net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	peers := make([]*net.UDPConn, n)
+	for i := range peers {
+		if peers[i], err = net.ListenUDP(
+			"udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	tb.Cleanup(func() {
+		conn.Close()
+		for _, peer := range peers {
+			peer.Close()
+		}
+	})
+	return conn, peers
+}
+
+func TestBatchWriter(t *testing.T) {
+	conn, peers := newBatchConns(t, 4)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	failed := make(chan *net.UDPAddr, 1)
+	bw := newBatchWriter(conn, time.Millisecond, done,
+		func(addr *net.UDPAddr, err error) { failed <- addr })
+	go bw.run()
+
+	// an IPv6 address can't be sent to by the IPv4 socket, the packets
+	// around it are still sent.
+	v6 := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 6881}
+	for i, peer := range peers {
+		if i == 2 {
+			bw.write([]byte("v6"), v6)
+		}
+		bw.write([]byte{byte(i)}, peer.LocalAddr().(*net.UDPAddr))
+	}
+
+	select {
+	case addr := <-failed:
+		if !addr.IP.Equal(v6.IP) {
+			t.Errorf("failed address: %v", addr)
+		}
+	case <-time.After(time.Second):
+		t.Error("no failed address reported")
+	}
+
+	buff := make([]byte, 16)
+	for i, peer := range peers {
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := peer.ReadFromUDP(buff)
+		if err != nil || n != 1 || buff[0] != byte(i) {
+			t.Errorf("peer %d: %v %v", i, buff[:n], err)
+		}
+	}
+}
+
+// BenchmarkRefreshStorm writes the queries of a refresh one by one, each by
+// a syscall.
+func BenchmarkRefreshStorm(b *testing.B) {
+	conn, peers := newBatchConns(b, refreshStorm)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, peer := range peers {
+			conn.WriteToUDP(benchmarkPacket, peer.LocalAddr().(*net.UDPAddr))
+		}
+	}
+	b.ReportMetric(refreshStorm, "syscalls/op")
+}
+
+// BenchmarkRefreshStormBatched writes the queries of a refresh through a
+// batchWriter.
+func BenchmarkRefreshStormBatched(b *testing.B) {
+	conn, peers := newBatchConns(b, refreshStorm)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	bw := newBatchWriter(conn, time.Millisecond/2, done,
+		func(*net.UDPAddr, error) {})
+	go bw.run()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, peer := range peers {
+			bw.write(benchmarkPacket, peer.LocalAddr().(*net.UDPAddr))
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(
+		float64(atomic.LoadUint64(&bw.batches))/float64(b.N), "syscalls/op")
+}
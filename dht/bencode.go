@@ -3,12 +3,52 @@ package dht
 import (
 	"bytes"
 	"errors"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// DefaultMaxInfoSize is the default max size of an info dict.
+const DefaultMaxInfoSize = 4 * 1024 * 1024
+
+// decodeItemOverhead is the size every decoded value is accounted for besides
+// the length of strings.
+const decodeItemOverhead = 16
+
+var (
+	// ErrSizeLimitExceeded is the error when the bencoded data is larger
+	// than the limit.
+	ErrSizeLimitExceeded = errors.New("size limit exceeded")
+	// errInvalidItem is the error when an item of dict or list is invalid.
+	errInvalidItem = errors.New("invalid bencode when decode item")
+	// errInvalidNumber is the error when a string length or an int is
+	// invalid.
+	errInvalidNumber = errors.New("invalid number")
+)
+
+// decodeBudget tracks the size a decoding can still allocate. A nil
+// decodeBudget is unlimited.
+type decodeBudget struct {
+	remaining int
+}
+
+// take consumes n from the budget. It returns ErrSizeLimitExceeded if the
+// budget runs out.
+func (budget *decodeBudget) take(n int) error {
+	if budget == nil {
+		return nil
+	}
+
+	budget.remaining -= n
+	if budget.remaining < 0 {
+		return ErrSizeLimitExceeded
+	}
+	return nil
+}
+
 // find returns the index of first target in data starting from `start`.
 // It returns -1 if target not found.
 func find(data []byte, start int, target rune) (index int) {
@@ -19,11 +59,60 @@ func find(data []byte, start int, target rune) (index int) {
 	return index
 }
 
+// atoi parses a decimal int like strconv.Atoi. Unlike strconv.Atoi, it
+// doesn't convert data to a string, so it doesn't allocate.
+func atoi(data []byte) (int, error) {
+	neg := false
+	if len(data) > 0 && (data[0] == '-' || data[0] == '+') {
+		neg = data[0] == '-'
+		data = data[1:]
+	}
+
+	if len(data) == 0 {
+		return 0, errInvalidNumber
+	}
+
+	n := 0
+	for _, c := range data {
+		if c < '0' || c > '9' || n > (math.MaxInt-int(c-'0'))/10 {
+			return 0, errInvalidNumber
+		}
+		n = n*10 + int(c-'0')
+	}
+
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
 // DecodeString decodes a string in the data. It returns a tuple
 // (decoded result, the end position, error).
 func DecodeString(data []byte, start int) (
 	result interface{}, index int, err error) {
 
+	return decodeString(data, start, nil)
+}
+
+// DecodeBytes decodes a string in the data like DecodeString, but returns
+// it as []byte, e.g. for the binary infohashes and node ids. The result
+// doesn't refer to data.
+func DecodeBytes(data []byte, start int) (
+	result []byte, index int, err error) {
+
+	v, index, err := decodeString(data, start, nil)
+	if err != nil {
+		return
+	}
+
+	result = []byte(v.(string))
+	return
+}
+
+// decodeString decodes a string and takes its length from budget.
+func decodeString(data []byte, start int, budget *decodeBudget) (
+	result interface{}, index int, err error) {
+
 	if start >= len(data) || data[start] < '0' || data[start] > '9' {
 		err = errors.New("invalid string bencode")
 		return
@@ -35,7 +124,7 @@ func DecodeString(data []byte, start int) (
 		return
 	}
 
-	length, err := strconv.Atoi(string(data[start:i]))
+	length, err := atoi(data[start:i])
 	if err != nil {
 		return
 	}
@@ -52,6 +141,10 @@ func DecodeString(data []byte, start int) (
 		return
 	}
 
+	if err = budget.take(length + decodeItemOverhead); err != nil {
+		return
+	}
+
 	result = string(data[i+1 : index])
 	return
 }
@@ -60,6 +153,13 @@ func DecodeString(data []byte, start int) (
 func DecodeInt(data []byte, start int) (
 	result interface{}, index int, err error) {
 
+	return decodeInt(data, start, nil)
+}
+
+// decodeInt decodes an int and takes the overhead from budget.
+func decodeInt(data []byte, start int, budget *decodeBudget) (
+	result interface{}, index int, err error) {
+
 	if start >= len(data) || data[start] != 'i' {
 		err = errors.New("invalid int bencode")
 		return
@@ -72,31 +172,41 @@ func DecodeInt(data []byte, start int) (
 		return
 	}
 
-	result, err = strconv.Atoi(string(data[start+1 : index]))
+	result, err = atoi(data[start+1 : index])
 	if err != nil {
 		return
 	}
 	index++
 
+	err = budget.take(decodeItemOverhead)
+
 	return
 }
 
-// decodeItem decodes an item of dict or list.
-func decodeItem(data []byte, i int) (
+// decodeItem decodes an item of dict or list. The decode function is picked
+// by the first byte, so no error is allocated for the mismatched types.
+func decodeItem(data []byte, i int, budget *decodeBudget) (
 	result interface{}, index int, err error) {
 
-	var decodeFunc = []func([]byte, int) (interface{}, int, error){
-		DecodeString, DecodeInt, DecodeList, DecodeDict,
+	if i >= len(data) {
+		err = errInvalidItem
+		return
 	}
 
-	for _, f := range decodeFunc {
-		result, index, err = f(data, i)
-		if err == nil {
-			return
-		}
+	decodeFunc := decodeString
+	switch data[i] {
+	case 'i':
+		decodeFunc = decodeInt
+	case 'l':
+		decodeFunc = decodeList
+	case 'd':
+		decodeFunc = decodeDict
 	}
 
-	err = errors.New("invalid bencode when decode item")
+	result, index, err = decodeFunc(data, i, budget)
+	if err != nil && err != ErrSizeLimitExceeded {
+		err = errInvalidItem
+	}
 	return
 }
 
@@ -104,11 +214,22 @@ func decodeItem(data []byte, i int) (
 func DecodeList(data []byte, start int) (
 	result interface{}, index int, err error) {
 
+	return decodeList(data, start, nil)
+}
+
+// decodeList decodes a list and takes the size of its items from budget.
+func decodeList(data []byte, start int, budget *decodeBudget) (
+	result interface{}, index int, err error) {
+
 	if start >= len(data) || data[start] != 'l' {
 		err = errors.New("invalid list bencode")
 		return
 	}
 
+	if err = budget.take(decodeItemOverhead); err != nil {
+		return
+	}
+
 	var item interface{}
 	r := make([]interface{}, 0, 8)
 
@@ -119,7 +240,7 @@ func DecodeList(data []byte, start int) (
 			break
 		}
 
-		item, index, err = decodeItem(data, index)
+		item, index, err = decodeItem(data, index, budget)
 		if err != nil {
 			return
 		}
@@ -140,11 +261,23 @@ func DecodeList(data []byte, start int) (
 func DecodeDict(data []byte, start int) (
 	result interface{}, index int, err error) {
 
+	return decodeDict(data, start, nil)
+}
+
+// decodeDict decodes a dict and takes the size of its keys and values from
+// budget.
+func decodeDict(data []byte, start int, budget *decodeBudget) (
+	result interface{}, index int, err error) {
+
 	if start >= len(data) || data[start] != 'd' {
 		err = errors.New("invalid dict bencode")
 		return
 	}
 
+	if err = budget.take(decodeItemOverhead); err != nil {
+		return
+	}
+
 	var item, key interface{}
 	r := make(map[string]interface{})
 
@@ -160,7 +293,7 @@ func DecodeDict(data []byte, start int) (
 			return
 		}
 
-		key, index, err = DecodeString(data, index)
+		key, index, err = decodeString(data, index, budget)
 		if err != nil {
 			return
 		}
@@ -170,7 +303,7 @@ func DecodeDict(data []byte, start int) (
 			return
 		}
 
-		item, index, err = decodeItem(data, index)
+		item, index, err = decodeItem(data, index, budget)
 		if err != nil {
 			return
 		}
@@ -188,9 +321,25 @@ func DecodeDict(data []byte, start int) (
 	return
 }
 
-// Decode decodes a bencoded string to string, int, list or map.
+// Decode decodes a bencoded string to string, int, list or map. A string
+// keeps its bytes as is, it isn't validated or converted as UTF-8, so the
+// binary values such as infohashes are safe to use as []byte(s).
 func Decode(data []byte) (result interface{}, err error) {
-	result, _, err = decodeItem(data, 0)
+	result, _, err = decodeItem(data, 0, nil)
+	return
+}
+
+// DecodeWithLimit decodes like Decode, but it returns ErrSizeLimitExceeded
+// once the decoded strings plus a fixed overhead per value add up to more
+// than limit bytes. So both a huge string and lots of small values are
+// rejected. If limit is no greater than 0, there is no limit.
+func DecodeWithLimit(data []byte, limit int) (result interface{}, err error) {
+	var budget *decodeBudget
+	if limit > 0 {
+		budget = &decodeBudget{remaining: limit}
+	}
+
+	result, _, err = decodeItem(data, 0, budget)
 	return
 }
 
@@ -232,15 +381,20 @@ func EncodeList(data []interface{}) string {
 	return strings.Join([]string{"l", strings.Join(result, ""), "e"}, "")
 }
 
-// EncodeDict encodes a dict value.
+// EncodeDict encodes a dict value. The keys are sorted as raw strings, so
+// the output is canonical and a re-encoded dict keeps its hash.
 func EncodeDict(data map[string]interface{}) string {
-	result, i := make([]string, len(data)), 0
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	for key, val := range data {
+	result := make([]string, len(keys))
+	for i, key := range keys {
 		result[i] = strings.Join(
-			[]string{EncodeString(key), encodeItem(val)},
+			[]string{EncodeString(key), encodeItem(data[key])},
 			"")
-		i++
 	}
 
 	return strings.Join([]string{"d", strings.Join(result, ""), "e"}, "")
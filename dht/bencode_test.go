@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -21,6 +22,28 @@ func TestDecodeString(t *testing.T) {
 	}
 }
 
+func TestDecodeBytes(t *testing.T) {
+	cases := []struct {
+		in    string
+		out   []byte
+		index int
+		ok    bool
+	}{
+		{"0:", []byte{}, 2, true},
+		{"3:\xff\x00\xfeabc", []byte{0xff, 0, 0xfe}, 5, true},
+		{"5:abc", nil, 0, false},
+		{"i1e", nil, 0, false},
+	}
+
+	for _, c := range cases {
+		out, index, err := DecodeBytes([]byte(c.in), 0)
+		if (err == nil) != c.ok || !bytes.Equal(out, c.out) ||
+			(c.ok && index != c.index) {
+			t.Errorf("DecodeBytes(%q) = %v, %d, %v", c.in, out, index, err)
+		}
+	}
+}
+
 func TestDecodeInt(t *testing.T) {
 	cases := []struct {
 		in  string
@@ -157,3 +180,66 @@ func TestDecodeDict(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeWithLimit(t *testing.T) {
+	cases := []struct {
+		in    string
+		limit int
+		err   error
+	}{
+		{"5:hello", 0, nil},
+		{"5:hello", 5 + decodeItemOverhead, nil},
+		{"5:hello", 4 + decodeItemOverhead, ErrSizeLimitExceeded},
+		{"li1ei2ei3ee", 4 * decodeItemOverhead, nil},
+		{"li1ei2ei3ee", 3 * decodeItemOverhead, ErrSizeLimitExceeded},
+		{"d1:ali1e1:bee", 5*decodeItemOverhead + 2, nil},
+		{"d1:ali1e1:bee", 5*decodeItemOverhead + 1, ErrSizeLimitExceeded},
+	}
+
+	for _, c := range cases {
+		if _, err := DecodeWithLimit([]byte(c.in), c.limit); err != c.err {
+			t.Errorf("%s with limit %d: unexpected error %v", c.in, c.limit, err)
+		}
+	}
+}
+
+func TestEncodeDictCanonical(t *testing.T) {
+	data := map[string]interface{}{
+		"zoo":   1,
+		"a":     "x",
+		"B":     []interface{}{"y", map[string]interface{}{"d": 1, "c": 2}},
+		"inner": map[string]interface{}{"length": 3, "files": "f", "a b": 0},
+	}
+
+	out := "d1:Bl1:yd1:ci2e1:di1eee1:a1:x5:innerd3:a bi0e5:files1:f6:lengthi3ee3:zooi1ee"
+
+	for i := 0; i < 10; i++ {
+		if Encode(data) != out {
+			t.Fatal(Encode(data))
+		}
+	}
+}
+
+func TestAtoi(t *testing.T) {
+	cases := []struct {
+		in  string
+		out int
+		ok  bool
+	}{
+		{"0", 0, true},
+		{"123", 123, true},
+		{"-42", -42, true},
+		{"+7", 7, true},
+		{"", 0, false},
+		{"-", 0, false},
+		{"1a", 0, false},
+		{"99999999999999999999999", 0, false},
+	}
+
+	for _, c := range cases {
+		out, err := atoi([]byte(c.in))
+		if (err == nil) != c.ok || out != c.out {
+			t.Errorf("atoi(%q) = %d, %v", c.in, out, err)
+		}
+	}
+}
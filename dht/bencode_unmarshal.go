@@ -0,0 +1,233 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// UnmarshalTypeError is the error that a bencoded value can't be stored in
+// a Go value of the type.
+type UnmarshalTypeError struct {
+	// the path of the value, e.g. files[0].length, empty for the top value
+	Field string
+	// the bencode type of the value, i.e. string, int, list or dict
+	Value string
+	// the type of the Go value
+	Type reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	field := e.Field
+	if field == "" {
+		field = "value"
+	}
+	return fmt.Sprintf("cannot unmarshal bencode %s into %s of type %s",
+		e.Value, field, e.Type)
+}
+
+// Unmarshal decodes the bencoded data and stores the result in the value
+// pointed to by v, like encoding/json. A dict is stored in a struct or a
+// map with string keys, a list in a slice or an array, a string in a
+// string, a []byte or a byte array of the same length, and an int in an
+// integer or a bool, which is true if the int isn't 0. An interface{}
+// takes the value as Decode returns it. Pointers are allocated as needed.
+//
+// The keys of a dict are matched to the struct fields by the `bencode:"key"`
+// tags, or the field names case-insensitively if there is no tag. A field
+// tagged "-" is skipped. The keys without fields are ignored, and the fields
+// without keys are left as is, so optional keys need no handling. A value
+// of the wrong type fails with an UnmarshalTypeError.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("v should be a non-nil pointer")
+	}
+
+	raw, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	return unmarshalValue(raw, rv.Elem(), "")
+}
+
+// bencodeType returns the bencode type name of the decoded value raw.
+func bencodeType(raw interface{}) string {
+	switch raw.(type) {
+	case string:
+		return "string"
+	case int:
+		return "int"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "dict"
+	default:
+		return fmt.Sprintf("%T", raw)
+	}
+}
+
+// unmarshalValue stores the decoded value raw in v. field is the path of
+// raw for the errors.
+func unmarshalValue(raw interface{}, v reflect.Value, field string) error {
+	mismatch := &UnmarshalTypeError{
+		Field: field, Value: bencodeType(raw), Type: v.Type()}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(raw, v.Elem(), field)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch
+		}
+		v.Set(reflect.ValueOf(raw))
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return mismatch
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		n, ok := raw.(int)
+		if !ok {
+			return mismatch
+		}
+		v.SetBool(n != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := raw.(int)
+		if !ok || v.OverflowInt(int64(n)) {
+			return mismatch
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+
+		n, ok := raw.(int)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return mismatch
+		}
+		v.SetUint(uint64(n))
+	case reflect.Slice:
+		if s, ok := raw.(string); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+
+		list, ok := raw.([]interface{})
+		if !ok {
+			return mismatch
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := unmarshalValue(
+				item, slice.Index(i), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		if s, ok := raw.(string); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			if len(s) != v.Len() {
+				return mismatch
+			}
+			reflect.Copy(v, reflect.ValueOf([]byte(s)))
+			return nil
+		}
+
+		list, ok := raw.([]interface{})
+		if !ok || len(list) != v.Len() {
+			return mismatch
+		}
+
+		for i, item := range list {
+			if err := unmarshalValue(
+				item, v.Index(i), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		dict, ok := raw.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(dict)))
+		}
+		for key, item := range dict {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := unmarshalValue(item, elem, joinField(field, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		dict, ok := raw.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		return unmarshalStruct(dict, v, field)
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// unmarshalStruct stores the dict in the struct v. The embedded structs
+// without tags take the same dict.
+func unmarshalStruct(dict map[string]interface{}, v reflect.Value, field string) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("bencode"), ",")[0]
+
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			if err := unmarshalStruct(dict, v.Field(i), field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		var (
+			key  = tag
+			item interface{}
+			ok   bool
+		)
+		if tag != "" {
+			item, ok = dict[tag]
+		} else {
+			for k, val := range dict {
+				if strings.EqualFold(k, f.Name) {
+					key, item, ok = k, val, true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			continue
+		}
+		if err := unmarshalValue(item, v.Field(i), joinField(field, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinField returns the path of key in the dict at field.
+func joinField(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
@@ -0,0 +1,109 @@
+package dht
+
+import (
+	"reflect"
+	"testing"
+)
+
+type unmarshalFile struct {
+	Path   []string `bencode:"path"`
+	Length int64    `bencode:"length"`
+}
+
+type unmarshalInfo struct {
+	Name        string          `bencode:"name"`
+	PieceLength int             `bencode:"piece length"`
+	Private     bool            `bencode:"private"`
+	Files       []unmarshalFile `bencode:"files"`
+	Source      *string         `bencode:"source"`
+	Skipped     string          `bencode:"-"`
+	Comment     string
+}
+
+func TestUnmarshal(t *testing.T) {
+	source := "foo"
+
+	var info unmarshalInfo
+	err := Unmarshal([]byte("d7:Comment3:bar5:filesld6:lengthi10e4:pathl1:a1:beee"+
+		"4:name4:test12:piece lengthi16384e7:privatei1e6:source3:foo"+
+		"7:Skipped1:x5:extrai1ee"), &info)
+
+	out := unmarshalInfo{
+		Name:        "test",
+		PieceLength: 16384,
+		Private:     true,
+		Files:       []unmarshalFile{{Path: []string{"a", "b"}, Length: 10}},
+		Source:      &source,
+		Comment:     "bar",
+	}
+	if err != nil || !reflect.DeepEqual(info, out) {
+		t.Errorf("unexpected %+v, %v", info, err)
+	}
+
+	// the missing keys leave the fields as is.
+	info = unmarshalInfo{Name: "kept"}
+	if err := Unmarshal([]byte("d6:lengthi1ee"), &info); err != nil ||
+		info.Name != "kept" || info.Files != nil {
+		t.Errorf("unexpected %+v, %v", info, err)
+	}
+}
+
+func TestUnmarshalTypes(t *testing.T) {
+	var (
+		id    [4]byte
+		raw   []byte
+		small int8
+		value interface{}
+		dict  map[string]int
+	)
+
+	cases := []struct {
+		in  string
+		v   interface{}
+		out interface{}
+	}{
+		{"4:abcd", &id, [4]byte{'a', 'b', 'c', 'd'}},
+		{"3:\xff\x00a", &raw, []byte{0xff, 0, 'a'}},
+		{"i-8e", &small, int8(-8)},
+		{"li1e1:ae", &value, []interface{}{1, "a"}},
+		{"d1:ai1e1:bi2ee", &dict, map[string]int{"a": 1, "b": 2}},
+	}
+
+	for _, c := range cases {
+		if err := Unmarshal([]byte(c.in), c.v); err != nil ||
+			!reflect.DeepEqual(reflect.ValueOf(c.v).Elem().Interface(), c.out) {
+			t.Errorf("Unmarshal(%q): %v", c.in, err)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var (
+		info  unmarshalInfo
+		id    [4]byte
+		small uint8
+	)
+
+	cases := []struct {
+		in    string
+		v     interface{}
+		field string
+	}{
+		{"d4:namei1ee", &info, "name"},
+		{"d5:filesld6:length1:aeee", &info, "files[0].length"},
+		{"3:abc", &id, ""},
+		{"i256e", &small, ""},
+		{"i-1e", &small, ""},
+	}
+
+	for _, c := range cases {
+		err, ok := Unmarshal([]byte(c.in), c.v).(*UnmarshalTypeError)
+		if !ok || err.Field != c.field {
+			t.Errorf("Unmarshal(%q): %v", c.in, err)
+		}
+	}
+
+	if Unmarshal([]byte("i1e"), info) == nil || Unmarshal([]byte("i1"), &small) == nil {
+		t.Error("invalid input accepted")
+	}
+}
@@ -151,3 +151,32 @@ func (bitmap *bitmap) String() string {
 func (bitmap *bitmap) RawString() string {
 	return string(bitmap.data)
 }
+
+// Distance returns the xor distance of the ids a and b, e.g. two node ids
+// or a node id and an infohash. The distances compare as big-endian numbers
+// with bytes.Compare. It panics if a and b aren't the same length.
+func Distance(a, b []byte) []byte {
+	if len(a) != len(b) {
+		panic("size not the same")
+	}
+
+	distance := make([]byte, len(a))
+	xor(distance, a, b)
+	return distance
+}
+
+// DistanceLess returns whether a is closer to ref than b in xor distance,
+// so it sorts ids by their distances to ref. It panics if a, b and ref
+// aren't the same length.
+func DistanceLess(a, b, ref []byte) bool {
+	if len(a) != len(ref) || len(b) != len(ref) {
+		panic("size not the same")
+	}
+
+	for i := range ref {
+		if da, db := a[i]^ref[i], b[i]^ref[i]; da != db {
+			return da < db
+		}
+	}
+	return false
+}
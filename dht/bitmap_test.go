@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -67,3 +68,31 @@ func TestBitmap(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDistance(t *testing.T) {
+	ref := []byte{0x00, 0xff}
+
+	cases := []struct {
+		a, b     []byte
+		distance []byte
+		less     bool
+	}{
+		{[]byte{0x00, 0xff}, []byte{0x00, 0xfe}, []byte{0x00, 0x01}, true},
+		{[]byte{0x01, 0xff}, []byte{0x00, 0x00}, []byte{0x01, 0xff}, false},
+		{[]byte{0x80, 0x00}, []byte{0x80, 0x00}, []byte{0x00, 0x00}, false},
+	}
+
+	for i, c := range cases {
+		if !bytes.Equal(Distance(c.a, c.b), c.distance) ||
+			DistanceLess(c.a, c.b, ref) != c.less {
+			t.Errorf("case %d: distance %x", i, Distance(c.a, c.b))
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on different lengths")
+		}
+	}()
+	Distance([]byte{0x00}, ref)
+}
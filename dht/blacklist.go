@@ -1,6 +1,8 @@
 package dht
 
 import (
+	"encoding/json"
+	"io"
 	"time"
 )
 
@@ -11,20 +13,29 @@ type blockedItem struct {
 	createTime time.Time
 }
 
+// blockedRecord is the persisted form of blockedItem.
+type blockedRecord struct {
+	IP         string    `json:"ip"`
+	Port       int       `json:"port"`
+	CreateTime time.Time `json:"create_time"`
+}
+
 // blackList manages the blocked nodes including which sends bad information
 // and can't ping out.
 type blackList struct {
 	list         *syncedMap
 	maxSize      int
 	expiredAfter time.Duration
+	clock        Clock
 }
 
 // newBlackList returns a blackList pointer.
-func newBlackList(size int) *blackList {
+func newBlackList(size int, clock Clock) *blackList {
 	return &blackList{
 		list:         newSyncedMap(),
 		maxSize:      size,
 		expiredAfter: time.Hour * 1,
+		clock:        clock,
 	}
 }
 
@@ -47,7 +58,7 @@ func (bl *blackList) insert(ip string, port int) {
 	bl.list.Set(bl.genKey(ip, port), &blockedItem{
 		ip:         ip,
 		port:       port,
-		createTime: time.Now(),
+		createTime: bl.clock.Now(),
 	})
 }
 
@@ -66,7 +77,7 @@ func (bl *blackList) in(ip string, port int) bool {
 
 	v, ok := bl.list.Get(key)
 	if ok {
-		if time.Since(v.(*blockedItem).createTime) < bl.expiredAfter {
+		if bl.clock.Now().Sub(v.(*blockedItem).createTime) < bl.expiredAfter {
 			return true
 		}
 		bl.list.Delete(key)
@@ -74,19 +85,72 @@ func (bl *blackList) in(ip string, port int) bool {
 	return false
 }
 
-// clear cleans the expired items every 10 minutes.
-func (bl *blackList) clear() {
-	for range time.Tick(time.Minute * 10) {
-		keys := make([]interface{}, 0, 100)
+// clear cleans the expired items every 10 minutes until done is closed.
+func (bl *blackList) clear(done <-chan struct{}) {
+	ticker := bl.clock.NewTicker(time.Minute * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+		now := bl.clock.Now()
 
-		for item := range bl.list.Iter() {
-			if time.Since(
-				item.val.(*blockedItem).createTime) > bl.expiredAfter {
+		keys := make([]interface{}, 0, 100)
 
-				keys = append(keys, item.key)
+		for key, val := range bl.list.Snapshot() {
+			if now.Sub(val.(*blockedItem).createTime) > bl.expiredAfter {
+				keys = append(keys, key)
 			}
 		}
 
 		bl.list.DeleteMulti(keys)
 	}
 }
+
+// Save writes the unexpired items with their insertion time to w as JSON.
+func (bl *blackList) Save(w io.Writer) error {
+	records := make([]blockedRecord, 0, bl.list.Len())
+	now := bl.clock.Now()
+
+	for _, val := range bl.list.Snapshot() {
+		bi := val.(*blockedItem)
+		if now.Sub(bi.createTime) > bl.expiredAfter {
+			continue
+		}
+
+		records = append(records, blockedRecord{
+			IP:         bi.ip,
+			Port:       bi.port,
+			CreateTime: bi.createTime,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(records)
+}
+
+// Load reads the items written by Save from r. The items keep their
+// insertion time, so the expired ones are discarded.
+func (bl *blackList) Load(r io.Reader) error {
+	var records []blockedRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return err
+	}
+
+	now := bl.clock.Now()
+	for _, record := range records {
+		if now.Sub(record.CreateTime) > bl.expiredAfter ||
+			bl.list.Len() >= bl.maxSize {
+			continue
+		}
+
+		bl.list.Set(bl.genKey(record.IP, record.Port), &blockedItem{
+			ip:         record.IP,
+			port:       record.Port,
+			createTime: record.CreateTime,
+		})
+	}
+	return nil
+}
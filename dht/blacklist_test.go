@@ -1,11 +1,13 @@
 package dht
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
+	"time"
 )
 
-var blacklist = newBlackList(256)
+var blacklist = newBlackList(256, realClock{})
 
 func TestGenKey(t *testing.T) {
 	cases := []struct {
@@ -55,3 +57,63 @@ func TestBlackList(t *testing.T) {
 		}
 	}
 }
+
+func TestBlackListSaveLoad(t *testing.T) {
+	clock := newFakeClock()
+
+	bl := newBlackList(256, clock)
+	bl.insert("1.1.1.1", 8080)
+	bl.insert("2.2.2.2", -1)
+	bl.list.Set("3.3.3.3:80", &blockedItem{
+		ip:         "3.3.3.3",
+		port:       80,
+		createTime: clock.Now().Add(-bl.expiredAfter * 2),
+	})
+
+	buff := bytes.NewBuffer(nil)
+	if err := bl.Save(buff); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newBlackList(256, clock)
+	if err := loaded.Load(buff); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.list.Len() != 2 || !loaded.in("1.1.1.1", 8080) ||
+		!loaded.in("2.2.2.2", 1) || loaded.in("3.3.3.3", 80) {
+		t.Fail()
+	}
+}
+
+func TestBlackListExpiry(t *testing.T) {
+	clock := newFakeClock()
+	bl := newBlackList(256, clock)
+	bl.insert("1.1.1.1", 8080)
+	bl.insert("2.2.2.2", 8080)
+
+	done := make(chan struct{})
+	defer close(done)
+	go bl.clear(done)
+	waitPending(t, clock, 1)
+
+	clock.Advance(bl.expiredAfter - time.Minute)
+	if !bl.in("1.1.1.1", 8080) {
+		t.Error("expired early")
+	}
+
+	clock.Advance(time.Minute * 2)
+	if bl.in("1.1.1.1", 8080) {
+		t.Error("expired item blocked")
+	}
+
+	// the clear loop ticks every 10 minutes.
+	clock.Advance(time.Minute * 10)
+	deadline := time.Now().Add(time.Second * 5)
+	for bl.list.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if bl.list.Len() != 0 {
+		t.Errorf("%d expired items kept", bl.list.Len())
+	}
+}
@@ -0,0 +1,66 @@
+package dht
+
+import "net"
+
+// bogonRanges are the ranges not globally routable, see
+// https://www.iana.org/assignments/iana-ipv4-special-registry and
+// https://www.iana.org/assignments/iana-ipv6-special-registry.
+var bogonRanges = parseCIDRs(
+	// IPv4
+	"0.0.0.0/8",       // this network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved and broadcast
+	// IPv6
+	"::/128",        // unspecified
+	"::1/128",       // loopback
+	"100::/64",      // discard-only
+	"2001:db8::/32", // documentation
+	"fc00::/7",      // unique local
+	"fe80::/10",     // link-local
+	"ff00::/8",      // multicast
+)
+
+// parseCIDRs parses the CIDRs, it panics if any is invalid.
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipNet
+	}
+	return nets
+}
+
+// isBogon returns whether ip is in a range not globally routable. An
+// IPv4-mapped IPv6 address is checked as IPv4.
+func isBogon(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, ipNet := range bogonRanges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejects returns whether the packets from ip are dropped and the nodes at
+// ip aren't inserted, see Config.RejectBogons.
+func (dht *DHT) rejects(ip net.IP) bool {
+	return dht.RejectBogons && isBogon(ip)
+}
@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsBogon(t *testing.T) {
+	cases := []struct {
+		ip    string
+		bogon bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"0.0.0.0", true},
+		{"1.1.1.1", false},
+		{"8.8.8.8", false},
+		{"::ffff:192.168.1.1", true},
+		{"::ffff:8.8.8.8", false},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"ff02::1", true},
+		{"2001:db8::1", true},
+		{"2001:4860:4860::8888", false},
+	}
+
+	for _, c := range cases {
+		if isBogon(net.ParseIP(c.ip)) != c.bogon {
+			t.Errorf("isBogon(%s) should be %v", c.ip, c.bogon)
+		}
+	}
+}
+
+func TestRejectBogons(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		config := NewStandardConfig()
+		config.RejectBogons = reject
+
+		dht := newHandlerDHT(config)
+
+		dht.routingTable.Insert(NewNode(rawInfoHash(randomString(20)),
+			&net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 6881}))
+		dht.routingTable.Insert(NewNode(rawInfoHash(randomString(20)),
+			&net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}))
+
+		if n := dht.routingTable.Len(); (reject && n != 1) || (!reject && n != 2) {
+			t.Errorf("RejectBogons %v: %d nodes", reject, n)
+		}
+	}
+}
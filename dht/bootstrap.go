@@ -0,0 +1,139 @@
+package dht
+
+import "encoding/hex"
+
+// bootstrapMaxIterations is the max iterations of a lookup in Bootstrap.
+const bootstrapMaxIterations = 8
+
+// BootstrapRound represents the result of a Bootstrap round.
+type BootstrapRound struct {
+	// the hex encoded random target
+	Target string
+	// how many nodes are queried
+	Queried int
+	// the change of the routing table size during the round
+	Discovered int
+}
+
+// Bootstrap populates the routing table by iterative find_node lookups. In
+// every round it picks a random target, queries the K closest known nodes,
+// waits for their responses and then queries the closest nodes it doesn't
+// query yet, until the K closest nodes are all queried or it reaches
+// bootstrapMaxIterations. If the routing table is empty, the prime nodes are
+// queried first. It blocks until all rounds finish. It returns
+// ErrInvalidRounds if rounds is less than 1.
+func (dht *DHT) Bootstrap(rounds int) ([]BootstrapRound, error) {
+	if rounds < 1 {
+		return nil, ErrInvalidRounds
+	}
+
+	if !dht.Ready {
+		return nil, ErrNotReady
+	}
+
+	stats := make([]BootstrapRound, rounds)
+	for i := range stats {
+		stats[i] = dht.bootstrapRound(randomString(20))
+	}
+	return stats, nil
+}
+
+// bootstrapRound does the iterative lookup of target.
+func (dht *DHT) bootstrapRound(target string) BootstrapRound {
+	dht.lookups.begin()
+	defer dht.lookups.end()
+
+	round := BootstrapRound{Target: hex.EncodeToString([]byte(target))}
+	before := dht.routingTable.Len()
+
+	targetID := newBitmapFromString(target)
+	visited := make(map[string]bool)
+
+	for i := 0; i < bootstrapMaxIterations; i++ {
+		nodes := dht.routingTable.GetNeighbors(targetID, dht.K)
+		if len(nodes) == 0 && i == 0 {
+			nodes = dht.primeNodes()
+		}
+
+		dones := make([]<-chan struct{}, 0, len(nodes))
+		for _, no := range nodes {
+			if visited[no.Address().String()] {
+				continue
+			}
+			visited[no.Address().String()] = true
+
+			if done := dht.transactionManager.findNode(no, target); done != nil {
+				dones = append(dones, done)
+			}
+		}
+
+		if len(dones) == 0 {
+			break
+		}
+
+		for _, done := range dones {
+			<-done
+		}
+		round.Queried += len(dones)
+	}
+
+	round.Discovered = dht.routingTable.Len() - before
+	return round
+}
+
+// SelfLookup does the iterative find_node lookup of our own node id like a
+// Bootstrap round, and returns how many responding nodes list us in their
+// nodes, i.e. how many nodes already have us in their routing tables. It
+// blocks until the lookup finishes, and returns ErrNoResponse if no node
+// responds at all, so it works as a readiness probe.
+func (dht *DHT) SelfLookup() (int, error) {
+	if !dht.Ready {
+		return 0, ErrNotReady
+	}
+
+	dht.lookups.begin()
+	defer dht.lookups.end()
+
+	self := dht.node.IDRawString()
+	selfID := newBitmapFromString(self)
+	visited := make(map[string]bool)
+	responded, listed := 0, 0
+
+	for i := 0; i < bootstrapMaxIterations; i++ {
+		nodes := dht.routingTable.GetNeighbors(selfID, dht.K)
+		if len(nodes) == 0 && i == 0 {
+			nodes = dht.primeNodes()
+		}
+
+		queries := make([]*Query, 0, len(nodes))
+		for _, no := range nodes {
+			if visited[no.Address().String()] {
+				continue
+			}
+			visited[no.Address().String()] = true
+
+			if q := dht.transactionManager.findNodeQuery(no, self); q != nil {
+				queries = append(queries, q)
+			}
+		}
+
+		if len(queries) == 0 {
+			break
+		}
+
+		for _, q := range queries {
+			<-q.done
+			if q.responded {
+				responded++
+				if q.found {
+					listed++
+				}
+			}
+		}
+	}
+
+	if responded == 0 {
+		return 0, ErrNoResponse
+	}
+	return listed, nil
+}
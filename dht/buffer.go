@@ -0,0 +1,33 @@
+package dht
+
+import "sync"
+
+// bufferPool reuses fixed-size byte buffers. The buffers are passed as
+// pointers, so putting them back doesn't allocate.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool returns a bufferPool pointer whose buffers are size-length.
+func newBufferPool(size int) *bufferPool {
+	bp := &bufferPool{size: size}
+	bp.pool.New = func() interface{} {
+		buff := make([]byte, bp.size)
+		return &buff
+	}
+	return bp
+}
+
+// get returns a buffer from the pool.
+func (bp *bufferPool) get() *[]byte {
+	return bp.pool.Get().(*[]byte)
+}
+
+// put returns the buffer to the pool. The buffer mustn't be used after it's
+// put back. It's safe to put a nil buffer.
+func (bp *bufferPool) put(buff *[]byte) {
+	if buff != nil && len(*buff) == bp.size {
+		bp.pool.Put(buff)
+	}
+}
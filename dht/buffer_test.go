@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"testing"
+)
+
+// benchmarkPacket is a typical get_peers query.
+var benchmarkPacket = []byte(
+	"d1:ad2:id20:abcdefghij01234567899:info_hash20:mnopqrstuvwxyz123456e" +
+		"1:q9:get_peers1:t2:aa1:y1:qe")
+
+func TestBufferPool(t *testing.T) {
+	bp := newBufferPool(16)
+
+	buff := bp.get()
+	if len(*buff) != 16 {
+		t.Fail()
+	}
+
+	// a buffer of another size is dropped instead of pooled.
+	other := make([]byte, 8)
+	bp.put(&other)
+	bp.put(nil)
+	bp.put(buff)
+
+	if len(*bp.get()) != 16 {
+		t.Fail()
+	}
+}
+
+// BenchmarkPacketCopy simulates a packet stream where every packet is
+// copied to a new buffer.
+func BenchmarkPacketCopy(b *testing.B) {
+	b.ReportAllocs()
+
+	read := make([]byte, 8192)
+	for i := 0; i < b.N; i++ {
+		n := copy(read, benchmarkPacket)
+
+		data := make([]byte, n)
+		copy(data, read[:n])
+
+		if _, err := Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPacketPool simulates a packet stream where every packet is read
+// into a pooled buffer.
+func BenchmarkPacketPool(b *testing.B) {
+	b.ReportAllocs()
+
+	bp := newBufferPool(8192)
+	for i := 0; i < b.N; i++ {
+		buff := bp.get()
+		n := copy(*buff, benchmarkPacket)
+
+		if _, err := Decode((*buff)[:n]); err != nil {
+			b.Fatal(err)
+		}
+		bp.put(buff)
+	}
+}
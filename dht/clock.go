@@ -0,0 +1,49 @@
+package dht
+
+import "time"
+
+// Clock is the source of time of the DHT, e.g. the token expiry, the node
+// and peer activity, the transaction timeouts and the periodic clean-ups.
+// Tests may replace it to advance time without sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Tick(d time.Duration) <-chan time.Time
+	// NewTicker returns a Ticker ticking every d, which should be stopped
+	// once it's not used.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the ticker of a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Tick(d time.Duration) <-chan time.Time {
+	return time.Tick(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker is the Ticker of the time package.
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
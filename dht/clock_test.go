@@ -0,0 +1,205 @@
+package dht
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves on Advance.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1500000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0)
+}
+
+func (c *fakeClock) Tick(d time.Duration) <-chan time.Time {
+	return c.add(d, d)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.Lock()
+	defer c.Unlock()
+
+	return fakeTicker{c, c.addLocked(d, d)}
+}
+
+func (c *fakeClock) add(d, period time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.addLocked(d, period).c
+}
+
+func (c *fakeClock) addLocked(d, period time.Duration) *fakeTimer {
+	timer := &fakeTimer{at: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// fakeTicker is the Ticker of a fakeClock.
+type fakeTicker struct {
+	clock *fakeClock
+	timer *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+// Stop removes the timer, so it's no longer pending.
+func (t fakeTicker) Stop() {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t.timer {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// pending returns how many timers haven't fired.
+func (c *fakeClock) pending() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.timers)
+}
+
+// waitPending waits for clock to have n pending timers, e.g. the ticker of
+// a goroutine just started.
+func waitPending(t *testing.T, clock *fakeClock, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for clock.pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d pending timers, want %d", clock.pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Advance moves the time forward by d and fires the due timers. Like
+// time.Ticker, a tick is dropped if the last one isn't received yet.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+
+	timers := c.timers[:0]
+	for _, timer := range c.timers {
+		for !timer.at.After(c.now) {
+			select {
+			case timer.c <- timer.at:
+			default:
+			}
+
+			if timer.period == 0 {
+				break
+			}
+			timer.at = timer.at.Add(timer.period)
+		}
+
+		if timer.at.After(c.now) {
+			timers = append(timers, timer)
+		}
+	}
+	c.timers = timers
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	after, tick := clock.After(time.Second), clock.Tick(time.Second)
+
+	clock.Advance(time.Millisecond * 500)
+	select {
+	case <-after:
+		t.Error("fired early")
+	default:
+	}
+
+	clock.Advance(time.Millisecond * 500)
+	if at := <-after; !at.Equal(start.Add(time.Second)) {
+		t.Errorf("after fired at %v", at.Sub(start))
+	}
+
+	<-tick
+	clock.Advance(time.Second)
+	<-tick
+
+	ticker := clock.NewTicker(time.Second)
+	clock.Advance(time.Second)
+	<-ticker.C()
+
+	pending := clock.pending()
+	ticker.Stop()
+	if clock.pending() != pending-1 {
+		t.Error("stopped ticker pending")
+	}
+}
+
+func TestTokenExpiryWithClock(t *testing.T) {
+	clock := newFakeClock()
+
+	config := NewStandardConfig()
+	config.Clock = clock
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+
+	tk := dht.tokenManager.token(addr)
+
+	clock.Advance(config.TokenExpiredAfter)
+	if !dht.tokenManager.check(addr, tk) {
+		t.Error("token expired early")
+	}
+
+	clock.Advance(time.Second)
+	if dht.tokenManager.check(addr, tk) {
+		t.Error("expired token accepted")
+	}
+
+	if dht.tokenManager.token(addr) == tk {
+		t.Error("expired token reused")
+	}
+}
+
+func TestKBucketLastChangedWithClock(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newKBucket(newBitmap(0), clock)
+
+	created := bucket.LastChanged()
+
+	clock.Advance(time.Minute)
+	bucket.Insert(NewNode(rawInfoHash(randomString(20)), &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}))
+
+	if bucket.LastChanged().Sub(created) != time.Minute {
+		t.Fail()
+	}
+}
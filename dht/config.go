@@ -1,7 +1,10 @@
 package dht
 
 import (
+	"crypto/sha1"
+	"errors"
 	"math"
+	"net"
 	"time"
 )
 
@@ -14,17 +17,35 @@ const (
 
 // Config represents the configure of dht.
 type Config struct {
-	// in mainline dht, k = 8
+	// the size of the neighbor sets, i.e. how many nodes are returned in
+	// find_node and get_peers responses and queried in lookups. In mainline
+	// dht, k = 8
 	K int
-	// for crawling mode, we put all nodes in one bucket, so KBucketSize may
-	// not be K
+	// the max nodes a bucket holds before it splits or keeps the new nodes
+	// as candidates. It should be no less than K, so a bucket can fill a
+	// neighbor set. For crawling mode, we put all nodes in one bucket, so
+	// KBucketSize may not be K
 	KBucketSize int
+	// drops the nodes a full bucket can't take instead of keeping them as
+	// candidates and pinging the bucket's expired nodes. The candidates
+	// replace the evicted nodes of a bucket, which is useless when the
+	// buckets are unbounded, so crawling mode disables them
+	DisableCandidates bool
 	// candidates are udp, udp4, udp6
 	Network string
 	// format is `ip:port`
 	Address string
+	// the transport the packets are read from and written to instead of a
+	// udp socket listening on Network and Address, see Transport. Address
+	// should be its local address. It's closed by DHT.Close
+	Transport Transport
 	// the prime nodes through which we can join in dht network
 	PrimeNodes []string
+	// the known nodes put to the routing table on start besides joining via
+	// the prime nodes, in the form of `id@ip:port` with a hex encoded id, or
+	// `ip:port`. The nodes without id are pinged first to learn their ids.
+	// See LoadSeedNodes for reading them from a file
+	SeedNodes []string
 	// the kbucket expired duration
 	KBucketExpiredAfter time.Duration
 	// the node expired duration
@@ -33,30 +54,325 @@ type Config struct {
 	CheckKBucketPeriod time.Duration
 	// peer token expired duration
 	TokenExpiredAfter time.Duration
-	// the max transaction id
+	// the max transaction id.
+	//
+	// Deprecated: the transaction ids are random by default. For the old
+	// sequential ids, set TransactionIDGenerator to
+	// NewCursorTransactionIDGenerator(MaxTransactionCursor)
 	MaxTransactionCursor uint64
+	// the generator of the transaction ids. If it's nil, the ids are
+	// TransactionIDLength random bytes
+	TransactionIDGenerator TransactionIDGenerator
+	// the length of the default random transaction ids
+	TransactionIDLength int
+	// the byte prepended to the transaction ids, so the instances sharing an
+	// ip can tell their transactions apart. The responses whose transaction
+	// id lacks it are dropped. It's at most 1 byte, no prefix if it's empty
+	TransactionIDPrefix string
 	// how many nodes routing table can hold
 	MaxNodes int
-	// callback when got get_peers request
+	// RejectWhenFull or EvictStale, what's done with a new node when the
+	// routing table holds MaxNodes nodes. EvictStale keeps the table fresh
+	// in a long crawl instead of freezing it with the first nodes
+	FullTablePolicy int
+	// the rough memory budget in MB of the routing table, the peers, the
+	// blacklist and the tokens, no budget if it's 0. They get fixed shares
+	// of it, which lower MaxNodes, MaxPeersTotal and BlackListMaxSize if
+	// they're larger, and the tokens beyond their share are evicted. See
+	// DHT.EstimatedMemory
+	MemoryBudgetMB int
+	// the nodes the routing table needs before the queries other than ping
+	// are answered. Until then, the dht keeps bootstrapping, see
+	// DHT.Bootstrapping. It's served at once if it's 0
+	MinTableSizeForService int
+	// callback when got get_peers request.
+	//
+	// Deprecated: use OnGetPeersIP, which will replace it in the next
+	// release.
 	OnGetPeers func(string, string, int)
+	// the same as OnGetPeers, but passes the InfoHash and the parsed ip
+	OnGetPeersIP func(infoHash InfoHash, ip net.IP, port int)
+	// the same as OnGetPeersIP, but also passes how many peers are stored
+	// for the infohash at the time, a hint of its popularity. The count
+	// may include the expired peers not removed yet
+	OnGetPeersCount func(infoHash InfoHash, ip net.IP, port int, peers int)
 	// callback when receive get_peers response
-	OnGetPeersResponse func(string, Peer)
-	// callback when got announce_peer request
+	OnGetPeersResponse func(InfoHash, Peer)
+	// callback when an infohash is sampled from the sample_infohashes
+	// response of the node at ip:port, if SampleInfohashes is set. The node
+	// only stores the infohash, it isn't a peer of it
+	OnSample func(infoHash InfoHash, ip net.IP, port int)
+	// callback when a get_peers response carries the BEP 33 scrape filters,
+	// with the swarm size estimated from them. The get_peers queries ask
+	// for the filters only if it's set
+	OnScrape func(infoHash InfoHash, seeds, peers int)
+	// callback when got announce_peer request.
+	//
+	// Deprecated: use OnAnnouncePeerIP, which will replace it in the next
+	// release.
 	OnAnnouncePeer func(string, string, int)
+	// the same as OnAnnouncePeer, but passes the InfoHash and the parsed
+	// ip
+	OnAnnouncePeerIP func(infoHash InfoHash, ip net.IP, port int)
+	// the infohashes whose peers are neither stored nor reported through the
+	// callbacks, AnnounceEvents or Torrents. Nothing is blocked if it's nil.
+	// It can be reloaded while the dht is running
+	InfohashBlocklist *InfohashBlocklist
+	// callback when got a malformed request
+	OnProtocolError func(*net.UDPAddr, *KRPCError)
+	// callback when a bucket whose prefix has prefixLen bits splits. It's
+	// called with the routing table locked, so it mustn't call the dht
+	OnBucketSplit func(prefixLen int)
+	// callback when a bucket becomes full, prefix is the bits of the bucket
+	// prefix like "0110". It's called with the routing table locked, so it
+	// mustn't call the dht
+	OnBucketFull func(prefix string)
+	// callback when a packet is dropped because all the packet workers are
+	// busy. It's called on the hot path, so it must be cheap
+	OnPacketDropped func(*net.UDPAddr)
 	// blcoked ips
 	BlockedIPs []string
+	// whether to drop the packets from, and refuse to insert the nodes at,
+	// the ips not globally routable, e.g. private, loopback, link-local and
+	// documentation ranges. They're almost always spoofed or misconfigured.
+	// It's recommended for a crawler on the public internet, but it must be
+	// off on a LAN
+	RejectBogons bool
 	// blacklist size
 	BlackListMaxSize int
+	// the file the blacklist is loaded from on start and saved to on close,
+	// no persistence if it's empty
+	BlackListPath string
 	// StandardMode or CrawlMode
 	Mode int
+	// StaticID, PrefixMirror(n) or FullMirror, how much of the target of a
+	// message the sent node id mirrors. Mirroring more routes more traffic
+	// to us, but it's riskier to be detected and penalized, see IDStrategy.
+	// If it's unset, it depends on Mode. Standard mode only allows StaticID
+	IDStrategy IDStrategy
+	// the 20-byte node id, which pins the node to the same keyspace position
+	// across restarts. If it's empty, the id is derived from NodeIDSeed
+	NodeID []byte
+	// the seed the node id is derived from when NodeID is empty, the same
+	// seed always gives the same id. A random id is used if both are empty
+	NodeIDSeed string
+	// whether to answer get_peers with the K closest nodes in crawl mode, as
+	// a standard node does. Some peers route fewer queries to a node
+	// answering with empty nodes. Otherwise the nodes are left empty
+	CrawlRespondNodes bool
+	// whether to only listen and learn from the incoming traffic. No query
+	// or response is sent, but the nodes are still put to the routing table
+	// and the callbacks are still called. The metadata fetching of
+	// DHT.Torrents isn't affected, it connects to the announced peers unless
+	// Torrents isn't called
+	PassiveOnly bool
 	// the times it tries when send fails
 	Try int
 	// the size of packet need to be dealt with
 	PacketJobLimit int
+	// the max size of a received udp packet, a larger packet is truncated
+	// and fails to decode
+	ReadBufferSize int
+	// the os-level receive buffer size of the udp socket. Packets arriving
+	// while the buffer is full are dropped by the kernel before they're read,
+	// so they're not counted by DHT.DroppedPackets. Raise it if the traffic
+	// is bursty. The kernel may cap it(e.g. net.core.rmem_max on linux)
+	SocketReadBuffer int
+	// how long the outgoing packets are held to be written together. On
+	// linux a batch is written by one sendmmsg syscall(by x/net WriteBatch),
+	// which saves the syscalls when many queries are sent at once(e.g. a
+	// refresh). Elsewhere the packets are written one by one. 0 writes every
+	// packet right away. It's ignored if Transport is set
+	WriteBatchWindow time.Duration
+	// callback when reading from the udp socket fails
+	OnReadError func(error)
 	// the size of packet handler
 	PacketWorkerLimit int
+	// the min limit of the packet handlers. The limit grows up to
+	// PacketWorkerLimit when packets burst and shrinks back when the load
+	// drops. The limit is static PacketWorkerLimit if it's 0 or no less than
+	// PacketWorkerLimit
+	PacketWorkerMin int
 	// the nodes num to be fresh in a kbucket
 	RefreshNodeNum int
+	// RandomRefresh or GapTargetedRefresh
+	RefreshStrategy int
+	// the buffer size of the chan returned by DHT.Torrents
+	TorrentsBufferSize int
+	// the buffer size of the chan returned by DHT.AnnounceEvents
+	AnnounceBufferSize int
+	// the function tagging the peers of DHT.AnnounceEvents, e.g. with the
+	// geolocation or the ASN of ip. It's called by EnrichWorkers goroutines
+	// off the packet handling path, but once per event, so a slow one(e.g.
+	// a remote lookup) must be bounded by a timeout or a cache, otherwise
+	// the events pile up and are dropped. No tags if it's nil
+	EnrichPeer func(ip net.IP) map[string]string
+	// the goroutines calling EnrichPeer
+	EnrichWorkers int
+	// the max metadata requests the wire buffers
+	WireRequestQueueSize int
+	// the max goroutines fetching metadata
+	WireWorkerLimit int
+	// how many infohashes whose metadata fetches failed are held, so the
+	// peers announcing them later retry the fetches with backoff. No retry
+	// if it's 0, see WireConfig.RetryQueueSize
+	WireRetryQueueSize int
+	// how many infohashes are remembered to deduplicate torrents, and how
+	// many first-seen times are kept, see DHT.FirstSeen
+	SeenMaxSize int
+	// the goroutines parsing the fetched metadata for DHT.Torrents
+	DecodeWorkers int
+	// the filter deciding whether to fetch the metadata of an infohash, all
+	// infohashes are fetched if it's nil. It's called on the packet handling
+	// goroutines for every announce not filtered by the seen filter, so it
+	// must be cheap and safe for concurrent use
+	InfohashFilter func(infoHash InfoHash) bool
+	// the max queries sent per second, no limit if it's 0
+	MaxQueriesPerSecond float64
+	// the max queries sent in a burst when MaxQueriesPerSecond is set
+	QueryBurst int
+	// the max iterative lookups running at once, no limit if it's 0. At the
+	// limit, GetPeers returns ErrTooManyLookups and the lookups the
+	// responses would start are dropped, while Bootstrap and SelfLookup
+	// wait for a free slot. See DHT.ActiveLookups
+	MaxConcurrentLookups int
+	// the max size of an info dict the torrents pipeline fetches and decodes
+	MaxInfoSize int
+	// the consecutive query failures before a node is blacklisted
+	MaxNodeFailures int
+	// how long a node isn't queried after its first failure, it doubles
+	// after every consecutive failure
+	NodeFailureBackoff time.Duration
+	// the max peers in the values of a get_peers response in standard mode.
+	// Every value takes 8 bytes, so a larger limit helps peer exchange in big
+	// swarms, but amplifies a small(possibly spoofed) query into a response
+	// up to 8 times limit bytes. Keep the response under the MTU, i.e. no
+	// greater than about 150
+	GetPeersValueLimit int
+	// whether to answer the get_peers queries asking for scrape with the BEP
+	// 33 bloom filters of the stored seeds and peers in standard mode. They
+	// add 512 bytes to every such response
+	ScrapeFilters bool
+	// the max peers stored for all the infohashes in standard mode, the
+	// least recently announced infohashes are evicted beyond it. No limit if
+	// it's 0
+	MaxPeersTotal int
+	// how long an announced peer is served in get_peers responses. An
+	// announce of the peer refreshes it. Peers never expire if it's 0
+	PeerExpiredAfter time.Duration
+	// how long the peers of the get_peers responses and the announce_peer
+	// queries are recorded by the swarm tracker, see DHT.SwarmTracker. The
+	// tracker is disabled if it's 0
+	SwarmWindow time.Duration
+	// the max infohashes recorded by the swarm tracker, the least recently
+	// observed ones are evicted beyond it
+	SwarmMaxInfoHashes int
+	// the max peers recorded for an infohash by the swarm tracker, the
+	// least recently observed ones are evicted beyond it
+	SwarmMaxPeers int
+	// whether to actively harvest infohashes by sending sample_infohashes
+	// queries(BEP 51) to the nodes in the routing table
+	SampleInfohashes bool
+	// how often sample_infohashes queries are sent
+	SampleInterval time.Duration
+	// the max nodes sampled every SampleInterval
+	SampleNodeNum int
+	// the source of time of the tokens, the routing table, the peers, the
+	// transactions and the clean-ups. It's the system clock by default, also
+	// when nil
+	Clock Clock
+}
+
+// Validate returns an error if the config is inconsistent.
+func (config *Config) Validate() error {
+	if config.K <= 0 {
+		return errors.New("K should be positive")
+	}
+
+	if config.KBucketSize < config.K {
+		return errors.New("KBucketSize should be no less than K")
+	}
+
+	if len(config.NodeID) != 0 && len(config.NodeID) != 20 {
+		return errors.New("NodeID should be 20 bytes")
+	}
+
+	if len(config.TransactionIDPrefix) > 1 {
+		return errors.New("TransactionIDPrefix should be at most 1 byte")
+	}
+
+	if config.TransactionIDGenerator == nil && config.TransactionIDLength <= 0 {
+		return errors.New("TransactionIDLength should be greater than 0")
+	}
+
+	if config.ReadBufferSize < 1 {
+		return errors.New("ReadBufferSize should be positive")
+	}
+
+	if config.MemoryBudgetMB < 0 {
+		return errors.New("MemoryBudgetMB should be no less than 0")
+	}
+
+	if config.MaxConcurrentLookups < 0 {
+		return errors.New("MaxConcurrentLookups should be no less than 0")
+	}
+
+	if config.IDStrategy < StaticID || config.IDStrategy > FullMirror {
+		return errors.New("IDStrategy should be in [StaticID, FullMirror]")
+	}
+
+	if config.Mode == StandardMode && config.idStrategy() != StaticID {
+		return errors.New("IDStrategy should be StaticID in standard mode")
+	}
+
+	if config.WriteBatchWindow < 0 {
+		return errors.New("WriteBatchWindow should be no less than 0")
+	}
+
+	if config.SwarmWindow < 0 {
+		return errors.New("SwarmWindow should be no less than 0")
+	}
+
+	if config.SwarmWindow > 0 &&
+		(config.SwarmMaxInfoHashes <= 0 || config.SwarmMaxPeers <= 0) {
+
+		return errors.New(
+			"SwarmMaxInfoHashes and SwarmMaxPeers should be positive")
+	}
+	return nil
+}
+
+// setDefaults fills the zero fields which Validate would reject with the
+// values of NewStandardConfig, so a hand-built Config New used to accept
+// still works.
+func (config *Config) setDefaults() {
+	if config.K <= 0 {
+		config.K = 8
+	}
+
+	if config.KBucketSize < config.K {
+		config.KBucketSize = config.K
+	}
+
+	if config.TransactionIDGenerator == nil && config.TransactionIDLength <= 0 {
+		config.TransactionIDLength = 4
+	}
+
+	if config.ReadBufferSize == 0 {
+		config.ReadBufferSize = 8192
+	}
+
+	if config.SwarmWindow > 0 && config.SwarmMaxInfoHashes == 0 {
+		config.SwarmMaxInfoHashes = 65536
+	}
+
+	if config.SwarmWindow > 0 && config.SwarmMaxPeers == 0 {
+		config.SwarmMaxPeers = 1024
+	}
+
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
 }
 
 // NewStandardConfig returns a Config pointer with default values.
@@ -79,14 +395,46 @@ func NewStandardConfig() *Config {
 		CheckKBucketPeriod:   time.Duration(time.Second * 30),
 		TokenExpiredAfter:    time.Duration(time.Minute * 10),
 		MaxTransactionCursor: math.MaxUint32,
+		TransactionIDLength:  4,
 		MaxNodes:             5000,
+		FullTablePolicy:      RejectWhenFull,
+		DisableCandidates:    false,
 		BlockedIPs:           make([]string, 0),
 		BlackListMaxSize:     65536,
 		Try:                  2,
 		Mode:                 StandardMode,
+		IDStrategy:           DefaultIDStrategy,
 		PacketJobLimit:       1024,
+		ReadBufferSize:       8192,
+		SocketReadBuffer:     1 << 22,
+		WriteBatchWindow:     0,
 		PacketWorkerLimit:    256,
 		RefreshNodeNum:       8,
+		RefreshStrategy:      RandomRefresh,
+		TorrentsBufferSize:   1024,
+		AnnounceBufferSize:   1024,
+		EnrichWorkers:        4,
+		WireRequestQueueSize: 1024,
+		WireWorkerLimit:      256,
+		WireRetryQueueSize:   0,
+		SeenMaxSize:          65536,
+		DecodeWorkers:        4,
+		MaxQueriesPerSecond:  0,
+		QueryBurst:           64,
+		MaxConcurrentLookups: 0,
+		MaxInfoSize:          DefaultMaxInfoSize,
+		MaxNodeFailures:      3,
+		NodeFailureBackoff:   time.Second * 30,
+		GetPeersValueLimit:   8,
+		MaxPeersTotal:        65536,
+		PeerExpiredAfter:     time.Minute * 30,
+		SwarmWindow:          0,
+		SwarmMaxInfoHashes:   65536,
+		SwarmMaxPeers:        1024,
+		SampleInfohashes:     false,
+		SampleInterval:       time.Second * 10,
+		SampleNodeNum:        8,
+		Clock:                realClock{},
 	}
 }
 
@@ -97,8 +445,41 @@ func NewCrawlConfig() *Config {
 	config.KBucketExpiredAfter = 0
 	config.CheckKBucketPeriod = time.Second * 5
 	config.KBucketSize = math.MaxInt32
+	config.DisableCandidates = true
 	config.Mode = CrawlMode
 	config.RefreshNodeNum = 256
 
 	return config
 }
+
+// idStrategy returns IDStrategy, or the default of Mode if it's unset.
+func (config *Config) idStrategy() IDStrategy {
+	if config.IDStrategy != DefaultIDStrategy {
+		return config.IDStrategy
+	} else if config.Mode == CrawlMode {
+		return PrefixMirror(15)
+	}
+	return StaticID
+}
+
+// transactionIDs returns the transaction id generator of the config, see
+// TransactionIDGenerator.
+func (config *Config) transactionIDs() TransactionIDGenerator {
+	if config.TransactionIDGenerator != nil {
+		return config.TransactionIDGenerator
+	}
+	return NewRandomTransactionIDGenerator(config.TransactionIDLength)
+}
+
+// nodeID returns the node id of the config, see NodeID and NodeIDSeed.
+func (config *Config) nodeID() string {
+	switch {
+	case len(config.NodeID) == 20:
+		return string(config.NodeID)
+	case config.NodeIDSeed != "":
+		id := sha1.Sum([]byte(config.NodeIDSeed))
+		return string(id[:])
+	default:
+		return randomString(20)
+	}
+}
@@ -0,0 +1,119 @@
+package dht
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		k, bucketSize int
+		ok            bool
+	}{
+		{8, 8, true},
+		{8, 16, true},
+		{8, 4, false},
+		{0, 8, false},
+	}
+
+	for _, c := range cases {
+		config := NewStandardConfig()
+		config.K, config.KBucketSize = c.k, c.bucketSize
+
+		if err := config.Validate(); (err == nil) != c.ok {
+			t.Errorf("K %d, KBucketSize %d: unexpected error %v",
+				c.k, c.bucketSize, err)
+		}
+	}
+
+	if NewCrawlConfig().Validate() != nil {
+		t.Fail()
+	}
+
+	config := NewStandardConfig()
+	config.Clock = nil
+	if config.Validate() != nil {
+		t.Error("nil Clock rejected")
+	}
+	if _, ok := New(nil, config).Clock.(realClock); !ok {
+		t.Error("nil Clock isn't the system clock")
+	}
+
+	config = NewStandardConfig()
+	config.ReadBufferSize = -1
+	if config.Validate() == nil {
+		t.Error("negative ReadBufferSize accepted")
+	}
+
+	config = NewStandardConfig()
+	config.IDStrategy = FullMirror + 1
+	if config.Validate() == nil {
+		t.Error("IDStrategy out of range accepted")
+	}
+
+	config = NewStandardConfig()
+	config.IDStrategy = PrefixMirror(15)
+	if config.Validate() == nil {
+		t.Error("mirroring IDStrategy accepted in standard mode")
+	}
+}
+
+func TestConfigIDStrategy(t *testing.T) {
+	cases := []struct {
+		config *Config
+		out    IDStrategy
+	}{
+		{NewStandardConfig(), StaticID},
+		{NewCrawlConfig(), PrefixMirror(15)},
+		{&Config{Mode: CrawlMode}, PrefixMirror(15)},
+		{&Config{Mode: CrawlMode, IDStrategy: StaticID}, StaticID},
+		{&Config{Mode: CrawlMode, IDStrategy: FullMirror}, FullMirror},
+	}
+
+	for _, c := range cases {
+		if out := c.config.idStrategy(); out != c.out {
+			t.Errorf("mode %d, IDStrategy %d: %d, want %d",
+				c.config.Mode, c.config.IDStrategy, out, c.out)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	config := &Config{Network: "udp4", Address: ":0", K: 16, KBucketSize: 4}
+	d := New(nil, config)
+
+	if d.K != 16 || d.KBucketSize != 16 || d.TransactionIDLength != 4 ||
+		d.Clock == nil {
+
+		t.Errorf("unexpected defaults %+v", config)
+	}
+
+	if New(nil, &Config{Network: "udp4", Address: ":0"}).K != 8 {
+		t.Error("zero K isn't defaulted")
+	}
+}
+
+func TestConfigNodeID(t *testing.T) {
+	config := NewStandardConfig()
+	if config.nodeID() == config.nodeID() {
+		t.Error("random ids collide")
+	}
+
+	config.NodeIDSeed = "crawler-1"
+	id := config.nodeID()
+	if len(id) != 20 || id != config.nodeID() {
+		t.Error("seeded id isn't stable")
+	}
+
+	config.NodeIDSeed = "crawler-2"
+	if config.nodeID() == id {
+		t.Error("different seeds give the same id")
+	}
+
+	config.NodeID = []byte("aaaaaaaaaaaaaaaaaaaa")
+	if config.nodeID() != "aaaaaaaaaaaaaaaaaaaa" || config.Validate() != nil {
+		t.Error("NodeID isn't used verbatim")
+	}
+
+	config.NodeID = []byte("short")
+	if config.Validate() == nil {
+		t.Error("short NodeID accepted")
+	}
+}
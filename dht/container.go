@@ -65,6 +65,19 @@ func (smap *syncedMap) DeleteMulti(keys []interface{}) {
 	}
 }
 
+// DeleteAny deletes an arbitrary key in the map. It returns false if the map
+// is empty.
+func (smap *syncedMap) DeleteAny() bool {
+	smap.Lock()
+	defer smap.Unlock()
+
+	for key := range smap.data {
+		delete(smap.data, key)
+		return true
+	}
+	return false
+}
+
 // Clear resets the data.
 func (smap *syncedMap) Clear() {
 	smap.Lock()
@@ -73,7 +86,23 @@ func (smap *syncedMap) Clear() {
 	smap.data = make(map[interface{}]interface{})
 }
 
-// Iter returns a chan which output all items.
+// Snapshot returns a copy of the data taken under the read lock, so it's a
+// consistent point-in-time view which can be ranged over without blocking
+// or being affected by the writers.
+func (smap *syncedMap) Snapshot() map[interface{}]interface{} {
+	smap.RLock()
+	defer smap.RUnlock()
+
+	data := make(map[interface{}]interface{}, len(smap.data))
+	for key, val := range smap.data {
+		data[key] = val
+	}
+	return data
+}
+
+// Iter returns a chan which output all items. It holds the read lock until
+// the chan is drained, so the caller must drain it and mustn't write the
+// map in the loop. Prefer Snapshot unless the map is too large to copy.
 func (smap *syncedMap) Iter() <-chan mapItem {
 	ch := make(chan mapItem)
 	go func() {
@@ -227,11 +256,41 @@ func (deque *keyedDeque) Push(key interface{}, val interface{}) {
 
 	if e, ok := deque.index[key]; ok {
 		deque.syncedList.Remove(e)
+		delete(deque.invertedIndex, e)
 	}
 	deque.index[key] = deque.syncedList.PushBack(val)
 	deque.invertedIndex[deque.index[key]] = key
 }
 
+// InsertSorted inserts a keyed-value after the last value which isn't
+// greater than it, so a deque sorted by less stays sorted. If key already
+// exists, the old value is removed first.
+func (deque *keyedDeque) InsertSorted(
+	key interface{}, val interface{}, less func(a, b interface{}) bool) {
+
+	deque.Lock()
+	defer deque.Unlock()
+
+	if e, ok := deque.index[key]; ok {
+		deque.syncedList.Remove(e)
+		delete(deque.invertedIndex, e)
+	}
+
+	deque.syncedList.RLock()
+	mark := deque.syncedList.queue.Back()
+	for mark != nil && less(val, mark.Value) {
+		mark = mark.Prev()
+	}
+	deque.syncedList.RUnlock()
+
+	if mark == nil {
+		deque.index[key] = deque.syncedList.PushFront(val)
+	} else {
+		deque.index[key] = deque.syncedList.InsertAfter(val, mark)
+	}
+	deque.invertedIndex[deque.index[key]] = key
+}
+
 // Get returns the keyed value.
 func (deque *keyedDeque) Get(key interface{}) (*list.Element, bool) {
 	deque.RLock()
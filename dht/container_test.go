@@ -194,3 +194,40 @@ func TestKeyedDeque(t *testing.T) {
 	deque.Clear()
 	isEmpty()
 }
+
+func TestSyncedMapSnapshot(t *testing.T) {
+	sm := newSyncedMap()
+	for i := 0; i < 100; i++ {
+		sm.Set(i, i)
+	}
+
+	// Run with -race to check the snapshot is safe under concurrent writes.
+	group := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+
+			for j := 0; j < 1000; j++ {
+				sm.Set(j%200, j)
+				sm.Delete((j + i) % 200)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 100; i++ {
+		for key, val := range sm.Snapshot() {
+			// writing the map while ranging over a snapshot doesn't
+			// deadlock.
+			sm.Set(key, val)
+		}
+	}
+	group.Wait()
+
+	sm.Set("x", 0)
+	snapshot := sm.Snapshot()
+	sm.Clear()
+	if len(snapshot) == 0 || sm.Len() != 0 {
+		t.Fail()
+	}
+}
@@ -3,9 +3,12 @@
 package dht
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,14 +20,53 @@ var (
 	// ErrOnGetPeersResponseNotSet is the error that config
 	// OnGetPeersResponseNotSet is not set when call dht.GetPeers.
 	ErrOnGetPeersResponseNotSet = errors.New("OnGetPeersResponse is not set")
+	// ErrInvalidInfoHash is the error that the infohash is neither 20 bytes
+	// nor 40 hex characters.
+	ErrInvalidInfoHash = errors.New("invalid infohash")
+	// ErrNoAnnounceToken is the error that no node returns a token when
+	// announcing.
+	ErrNoAnnounceToken = errors.New("no token is acquired")
+	// ErrAnnounceFailed is the error that no node accepts the announce.
+	ErrAnnounceFailed = errors.New("no announce succeeds")
+	// ErrQueryNotSent is the error that the node is blacklisted, backing off
+	// or already being queried.
+	ErrQueryNotSent = errors.New("query is not sent")
+	// ErrPingTimeout is the error that the node doesn't respond the ping.
+	ErrPingTimeout = errors.New("ping timeout")
+	// ErrWireQueueFull is the error that the request queue of the wire is
+	// full, i.e. all the fetching workers are busy.
+	ErrWireQueueFull = errors.New("wire request queue is full")
+	// ErrWireOverloaded is the error that the circuit breaker of the wire is
+	// open because most fetches fail.
+	ErrWireOverloaded = errors.New("wire is overloaded")
+	// ErrNoResponse is the error that none of the queried nodes responds.
+	ErrNoResponse = errors.New("no node responds")
+	// ErrClosed is the error that the dht is closed.
+	ErrClosed = errors.New("dht is closed")
+	// ErrTooManyLookups is the error that MaxConcurrentLookups lookups are
+	// running.
+	ErrTooManyLookups = errors.New("too many lookups")
+	// ErrPacketTooLarge is the error that an injected packet is longer than
+	// ReadBufferSize.
+	ErrPacketTooLarge = errors.New("packet is too large")
+	// ErrInvalidRounds is the error that Bootstrap is asked for less than
+	// one round.
+	ErrInvalidRounds = errors.New("rounds should be at least 1")
 )
 
+// readErrorLogInterval is how many consecutive udp read errors are logged
+// once.
+const readErrorLogInterval = 100
+
 // DHT represents a DHT node.
 type DHT struct {
+	// the 64-bit atomic fields are kept first for alignment.
+	droppedPackets  uint64
+	receivedPackets uint64
 	*Config
 	logger             *zap.Logger
 	node               Node
-	conn               *net.UDPConn
+	transport          Transport
 	routingTable       *routingTable
 	transactionManager *transactionManager
 	peersManager       *peersManager
@@ -32,34 +74,71 @@ type DHT struct {
 	blackList          *blackList
 	Ready              bool
 	packets            chan packet
-	workerTokens       chan struct{}
+	packetWorkers      *packetWorkers
+	buffers            *bufferPool
+	torrentPipeline    *torrentPipeline
+	firstSeen          *seenFilter
+	announceStream     *announceStream
+	sampler            *sampler
+	primeResolver      *primeResolver
+	lookups            *lookupTracker
+	swarmTracker       *SwarmTracker
+	steady             int32
+	paused             int32
+	done               chan struct{}
+	joined             chan struct{}
+	closeOnce          sync.Once
 }
 
 // New returns a DHT pointer. If config is nil, then config will be set to
-// the default config.
+// the default config. Zero fields such as K and Clock get their defaults,
+// and it panics if the config is still invalid, see Config.Validate.
 func New(logger *zap.Logger, config *Config) *DHT {
 	if config == nil {
 		config = NewStandardConfig()
 	}
 
-	node, err := NewNodeNetworkAddress(randomString(20), config.Network, config.Address)
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+
+	node, err := NewNodeNetworkAddress(
+		rawInfoHash(config.nodeID()), config.Network, config.Address)
 	if err != nil {
 		panic(err)
 	}
 
 	d := &DHT{
-		logger:       logger,
-		Config:       config,
-		node:         node,
-		blackList:    newBlackList(config.BlackListMaxSize),
-		packets:      make(chan packet, config.PacketJobLimit),
-		workerTokens: make(chan struct{}, config.PacketWorkerLimit),
+		logger:          logger,
+		Config:          config,
+		node:            node,
+		blackList:       newBlackList(config.blackListMaxSize(), config.Clock),
+		packets:         make(chan packet, config.PacketJobLimit),
+		packetWorkers:   newPacketWorkers(config.PacketWorkerMin, config.PacketWorkerLimit),
+		buffers:         newBufferPool(config.ReadBufferSize),
+		torrentPipeline: newTorrentPipeline(logger, config),
+		firstSeen:       newSeenFilter(config.SeenMaxSize),
+		announceStream:  newAnnounceStream(config),
+		primeResolver:   newPrimeResolver(logger, config.Network, config.PrimeNodes),
+		done:            make(chan struct{}),
+		joined:          make(chan struct{}),
 	}
 
 	for _, ip := range config.BlockedIPs {
 		d.blackList.insert(ip, -1)
 	}
 
+	if config.BlackListPath != "" {
+		if err := d.loadBlackList(); err != nil && !os.IsNotExist(err) {
+			logger.Warn("load blacklist failed", zap.Error(err))
+		}
+	}
+
 	go func() {
 		for _, ip := range getLocalIPs() {
 			d.blackList.insert(ip, -1)
@@ -86,64 +165,174 @@ func (dht *DHT) IsCrawlMode() bool {
 
 // init initializes global varables.
 func (dht *DHT) init() {
-	listener, err := net.ListenPacket(dht.Network, dht.Address)
-	if err != nil {
-		panic(err)
+	dht.transport = dht.Transport
+	if dht.transport == nil {
+		dht.transport = dht.listenUDP()
 	}
 
-	dht.conn = listener.(*net.UDPConn)
 	dht.routingTable = newRoutingTable(dht.KBucketSize, dht)
 	dht.peersManager = newPeersManager(dht)
 	dht.tokenManager = newTokenManager(dht.TokenExpiredAfter, dht)
 	dht.transactionManager = newTransactionManager(
-		dht.MaxTransactionCursor, dht)
+		dht.transactionIDs(), dht)
+	dht.sampler = newSampler(dht)
+	dht.lookups = newLookupTracker(dht.MaxConcurrentLookups, dht.Clock)
 
 	go dht.transactionManager.run()
 	go dht.tokenManager.clear()
-	go dht.blackList.clear()
+	if dht.PeerExpiredAfter > 0 {
+		go dht.peersManager.clear()
+	}
+	go dht.blackList.clear(dht.done)
+	go dht.lookups.clear(dht.done)
+
+	if dht.packetWorkers.adaptive() {
+		go dht.packetWorkers.run(dht.Clock, dht.done)
+	}
+
+	if dht.SampleInfohashes {
+		go dht.sampler.run()
+	}
+
+	if dht.SwarmWindow > 0 {
+		dht.swarmTracker = NewSwarmTracker(dht.SwarmWindow,
+			dht.SwarmMaxInfoHashes, dht.SwarmMaxPeers, dht.Clock)
+		go dht.swarmTracker.run(dht.done)
+	}
+
+	dht.primeResolver.resolve(true)
+	go dht.primeResolver.run(dht.done)
 }
 
-// join makes current node join the dht network.
-func (dht *DHT) join() {
-	for _, addr := range dht.PrimeNodes {
-		raddr, err := net.ResolveUDPAddr(dht.Network, addr)
-		if err != nil {
-			continue
+// listenUDP listens on Network and Address, and returns the Transport of the
+// socket. It panics if the listening fails.
+func (dht *DHT) listenUDP() Transport {
+	listener, err := net.ListenPacket(dht.Network, dht.Address)
+	if err != nil {
+		panic(err)
+	}
+
+	conn := listener.(*net.UDPConn)
+	if dht.SocketReadBuffer > 0 {
+		if err := conn.SetReadBuffer(dht.SocketReadBuffer); err != nil {
+			dht.logger.Warn("set socket read buffer failed", zap.Error(err))
 		}
+	}
 
+	t := &udpTransport{conn: conn}
+	if dht.WriteBatchWindow > 0 {
+		t.batchWriter = newBatchWriter(
+			conn, dht.WriteBatchWindow, dht.done,
+			func(addr *net.UDPAddr, err error) {
+				dht.blackList.insert(addr.IP.String(), -1)
+			})
+		go t.batchWriter.run()
+	}
+	return t
+}
+
+// primeNodes returns the temporary nodes of all the cached addresses of the
+// prime nodes.
+func (dht *DHT) primeNodes() []Node {
+	addrs := dht.primeResolver.addrs()
+	nodes := make([]Node, 0, len(addrs))
+
+	for _, raddr := range addrs {
 		// NOTE: Temporary node has NOT node id.
-		dht.transactionManager.findNode(
-			NewTempNode(raddr),
-			dht.node.IDRawString(),
-		)
+		nodes = append(nodes, newTempNodeAt(raddr, dht.Clock.Now()))
 	}
+	return nodes
 }
 
-// listen receives message from udp.
+// join makes current node join the dht network.
+func (dht *DHT) join() {
+	for _, no := range dht.primeNodes() {
+		dht.transactionManager.findNode(no, dht.node.IDRawString())
+	}
+}
+
+// listen receives message from udp. Read errors are reported to OnReadError,
+// and logged on the first one and every readErrorLogInterval consecutive
+// ones.
 func (dht *DHT) listen() {
 	go func() {
-		buff := make([]byte, 8192)
+		failures := 0
+
 		for {
-			n, raddr, err := dht.conn.ReadFromUDP(buff)
+			buff := dht.buffers.get()
+
+			n, raddr, err := dht.transport.ReadFrom(*buff)
 			if err != nil {
+				dht.buffers.put(buff)
+				if dht.isClosed() {
+					return
+				}
+
+				if dht.OnReadError != nil {
+					dht.OnReadError(err)
+				}
+				if failures%readErrorLogInterval == 0 {
+					dht.logger.Warn("read udp failed",
+						zap.Int("consecutive", failures+1), zap.Error(err))
+				}
+				failures++
 				continue
 			}
+			failures = 0
+			atomic.AddUint64(&dht.receivedPackets, 1)
 
-			dht.packets <- packet{buff[:n], raddr}
+			if n == len(*buff) {
+				dht.logger.Debug("packet may be truncated",
+					zap.Stringer("from", raddr), zap.Int("size", n))
+			}
+
+			select {
+			case dht.packets <- packet{(*buff)[:n], raddr, buff}:
+			case <-dht.done:
+				dht.buffers.put(buff)
+				return
+			}
 		}
 	}()
 }
 
-// id returns a id near to target if target is not null, otherwise it returns
-// the dht's node id.
-func (dht *DHT) id(target string) string {
-	if dht.IsStandardMode() || target == "" {
-		return dht.node.IDRawString()
+// InjectPacket feeds data as if it was a datagram received from the address
+// from, e.g. to replay captured traffic. The packet takes the same path as a
+// live one, so it's subject to the packet worker limit, the blacklist and
+// the bogon filter. A packet longer than ReadBufferSize is refused with
+// ErrPacketTooLarge. It blocks while the packet queue is full, and it isn't
+// counted in ReceivedPackets.
+func (dht *DHT) InjectPacket(data []byte, from *net.UDPAddr) error {
+	if !dht.Ready {
+		return ErrNotReady
+	}
+
+	if len(data) > dht.ReadBufferSize {
+		return ErrPacketTooLarge
+	}
+
+	buff := dht.buffers.get()
+	n := copy(*buff, data)
+
+	select {
+	case dht.packets <- packet{(*buff)[:n], from, buff}:
+		return nil
+	case <-dht.done:
+		dht.buffers.put(buff)
+		return ErrClosed
 	}
-	return target[:15] + dht.node.IDRawString()[15:]
 }
 
-// GetPeers returns peers who have announced having infoHash.
+// id returns the node id sent in a message about target, which is spoofed
+// per message by IDStrategy. If target is empty, it returns the node id.
+func (dht *DHT) id(target string) string {
+	return dht.idStrategy().mirror(target, dht.node.IDRawString())
+}
+
+// GetPeers returns peers who have announced having infoHash, which is 20
+// bytes or 40 hex characters. It returns ErrInvalidInfoHash for a malformed
+// infoHash, or ErrTooManyLookups if the lookup can't start as
+// MaxConcurrentLookups lookups are running.
 func (dht *DHT) GetPeers(infoHash string) error {
 	if !dht.Ready {
 		return ErrNotReady
@@ -153,12 +342,14 @@ func (dht *DHT) GetPeers(infoHash string) error {
 		return ErrOnGetPeersResponseNotSet
 	}
 
-	if len(infoHash) == 40 {
-		data, err := hex.DecodeString(infoHash)
-		if err != nil {
-			return err
-		}
-		infoHash = string(data)
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return err
+	}
+	infoHash = ih.Raw()
+
+	if !dht.lookups.start(DHTQueryTypeGetPeers, infoHash) {
+		return ErrTooManyLookups
 	}
 
 	neighbors := dht.routingTable.GetNeighbors(
@@ -171,23 +362,355 @@ func (dht *DHT) GetPeers(infoHash string) error {
 	return nil
 }
 
-// Run starts the dht.
+// SwarmTracker returns the tracker of the peers observed in the last
+// Config.SwarmWindow, or nil if the window is 0.
+func (dht *DHT) SwarmTracker() *SwarmTracker {
+	return dht.swarmTracker
+}
+
+// ClosestNodes returns at most n nodes in the routing table closest to
+// target, which is 20 bytes or 40 hex characters, the closest first. No
+// query is sent. It returns ErrInvalidInfoHash for a malformed target.
+func (dht *DHT) ClosestNodes(target string, n int) ([]Node, error) {
+	if !dht.Ready {
+		return nil, ErrNotReady
+	}
+
+	ih, err := ParseInfoHash(target)
+	if err != nil {
+		return nil, err
+	}
+	target = ih.Raw()
+
+	if n <= 0 {
+		return nil, nil
+	}
+	return dht.routingTable.GetNeighbors(newBitmapFromString(target), n), nil
+}
+
+// Ping pings the node at address, which is in `ip:port` format, and returns
+// the time from the first send to the response, so it includes the timed
+// out attempts. It blocks until the response arrives, or returns
+// ErrPingTimeout after all the Try attempts time out.
+func (dht *DHT) Ping(address string) (time.Duration, error) {
+	if !dht.Ready {
+		return 0, ErrNotReady
+	}
+
+	raddr, err := net.ResolveUDPAddr(dht.Network, address)
+	if err != nil {
+		return 0, err
+	}
+
+	q := dht.transactionManager.ping(newTempNodeAt(raddr, dht.Clock.Now()))
+	if q == nil {
+		return 0, ErrQueryNotSent
+	}
+
+	<-q.done
+	if !q.responded {
+		return 0, ErrPingTimeout
+	}
+	return q.rtt, nil
+}
+
+// HealthCheck pings the prime nodes, or K nodes of the routing table if
+// there is no prime node, e.g. for a readiness probe. It returns nil once a
+// node responds, ErrNoResponse if none responds, ErrQueryNotSent if no ping
+// can be sent, or the error of ctx if it's done first. The pings take the
+// normal transaction path, so they're rate limited and skip the nodes being
+// queried.
+func (dht *DHT) HealthCheck(ctx context.Context) error {
+	if !dht.Ready {
+		return ErrNotReady
+	}
+
+	nodes := dht.primeNodes()
+	if len(nodes) == 0 {
+		nodes = dht.routingTable.GetNeighbors(
+			newBitmapFromString(randomString(20)), dht.K)
+	}
+
+	responses := make(chan bool, len(nodes))
+	sent := 0
+	for _, no := range nodes {
+		if q := dht.transactionManager.ping(no); q != nil {
+			sent++
+			go func() {
+				<-q.done
+				responses <- q.responded
+			}()
+		}
+	}
+
+	if sent == 0 {
+		return ErrQueryNotSent
+	}
+
+	for i := 0; i < sent; i++ {
+		select {
+		case responded := <-responses:
+			if responded {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ErrNoResponse
+}
+
+// CoverageStats returns how the nodes in the routing table distribute in the
+// keyspace. It returns the zero value if the dht isn't running.
+func (dht *DHT) CoverageStats() CoverageStats {
+	if !dht.Ready {
+		return CoverageStats{}
+	}
+	return dht.routingTable.CoverageStats()
+}
+
+// RoutingTableDOT returns the Graphviz DOT graph of the routing table tree,
+// which shows how the buckets split. It returns an empty string if the dht
+// isn't running.
+func (dht *DHT) RoutingTableDOT() string {
+	if !dht.Ready {
+		return ""
+	}
+	return dht.routingTable.ToDOT()
+}
+
+// DroppedPackets returns how many packets are dropped because all the packet
+// workers are busy. A growing count suggests raising PacketWorkerLimit.
+func (dht *DHT) DroppedPackets() uint64 {
+	return atomic.LoadUint64(&dht.droppedPackets)
+}
+
+// StoredPeers returns how many peers are stored for all the infohashes. It
+// returns 0 if the dht isn't running.
+func (dht *DHT) StoredPeers() int {
+	if !dht.Ready {
+		return 0
+	}
+	return dht.peersManager.Total()
+}
+
+// ActiveLookups returns how many iterative lookups are running. It returns 0
+// if the dht isn't running.
+func (dht *DHT) ActiveLookups() int {
+	if !dht.Ready {
+		return 0
+	}
+	return dht.lookups.activeLen()
+}
+
+// LatencyPercentiles returns the 50th, 90th and 99th percentiles of the
+// query round-trip times since the start or the last ResetLatency. It
+// returns zeros if the dht isn't running.
+func (dht *DHT) LatencyPercentiles() (p50, p90, p99 time.Duration) {
+	if !dht.Ready {
+		return
+	}
+	return dht.transactionManager.LatencyPercentiles()
+}
+
+// ResetLatency clears the recorded query round-trip times.
+func (dht *DHT) ResetLatency() {
+	if dht.Ready {
+		dht.transactionManager.ResetLatency()
+	}
+}
+
+// ReceivedPackets returns how many packets are received from udp, including
+// the dropped ones.
+func (dht *DHT) ReceivedPackets() uint64 {
+	return atomic.LoadUint64(&dht.receivedPackets)
+}
+
+// loadBlackList loads the blacklist from BlackListPath.
+func (dht *DHT) loadBlackList() error {
+	f, err := os.Open(dht.BlackListPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return dht.blackList.Load(f)
+}
+
+// saveBlackList saves the blacklist to BlackListPath. It writes a temporary
+// file first, so a crash never leaves a truncated file.
+func (dht *DHT) saveBlackList() error {
+	tmp := dht.BlackListPath + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = dht.blackList.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dht.BlackListPath)
+}
+
+// isClosed returns whether Close has been called.
+func (dht *DHT) isClosed() bool {
+	select {
+	case <-dht.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Bootstrapping returns whether the dht is still bootstrapping, i.e. it's
+// not running yet or its routing table has fewer than MinTableSizeForService
+// nodes. Meanwhile only the pings are answered, and it keeps joining via the
+// prime nodes. Once the table is large enough, the dht stays in the steady
+// state even if the table shrinks later, until Reset.
+func (dht *DHT) Bootstrapping() bool {
+	return !dht.Ready || dht.bootstrapping()
+}
+
+// bootstrapping returns whether the routing table is yet to reach
+// MinTableSizeForService nodes for the first time.
+func (dht *DHT) bootstrapping() bool {
+	if dht.MinTableSizeForService <= 0 || atomic.LoadInt32(&dht.steady) == 1 {
+		return false
+	}
+
+	if n := dht.routingTable.Len(); n < dht.MinTableSizeForService {
+		return true
+	}
+
+	if atomic.CompareAndSwapInt32(&dht.steady, 0, 1) {
+		dht.logger.Info("bootstrapped",
+			zap.Int("nodes", dht.routingTable.Len()))
+	}
+	return false
+}
+
+// Pause halts the dht without closing the socket, e.g. for maintenance. No
+// query is sent and the received packets are read but dropped, while the
+// routing table, the peers, the tokens and the blacklist are kept as they
+// are. The in-flight transactions are canceled like on Reset, so they're
+// neither resent nor counted as failures of the nodes, and the callers
+// waiting for them, e.g. Ping, return at once. The metadata fetching of
+// Torrents isn't paused. It's safe to call Pause more than once, and before
+// Run, which then starts paused.
+func (dht *DHT) Pause() {
+	if atomic.CompareAndSwapInt32(&dht.paused, 0, 1) {
+		if dht.transactionManager != nil {
+			dht.transactionManager.drain()
+		}
+		dht.logger.Info("paused")
+	}
+}
+
+// Resume resumes the dht paused by Pause.
+func (dht *DHT) Resume() {
+	if atomic.CompareAndSwapInt32(&dht.paused, 1, 0) {
+		dht.logger.Info("resumed")
+	}
+}
+
+// Paused returns whether the dht is paused.
+func (dht *DHT) Paused() bool {
+	return atomic.LoadInt32(&dht.paused) == 1
+}
+
+// Reset flushes the learned state and bootstraps again from the prime
+// nodes, e.g. when the routing table has drifted into a bad region of the
+// keyspace. The in-flight transactions are canceled first, so their
+// responses and timeouts no longer touch the state. Then the routing table,
+// the announced peers and the tokens given out are cleared, and the
+// blacklist too if clearBlackList is set. The node id, the config, the
+// statistics and the fetched torrents are kept.
+//
+// It's safe to call Reset while the dht is running, the packets handled
+// meanwhile see either the old or the new state. The dht bootstraps again if
+// MinTableSizeForService is set. It returns ErrNotReady
+// before Run and ErrClosed after Close.
+func (dht *DHT) Reset(clearBlackList bool) error {
+	if !dht.Ready {
+		return ErrNotReady
+	}
+	if dht.isClosed() {
+		return ErrClosed
+	}
+
+	dht.transactionManager.drain()
+	atomic.StoreInt32(&dht.steady, 0)
+	dht.routingTable.Clear()
+	dht.peersManager.Clear()
+	dht.tokenManager.Clear()
+	if clearBlackList {
+		dht.blackList.list.Clear()
+	}
+
+	dht.join()
+	return nil
+}
+
+// Close stops the dht. The in-flight transactions are canceled, the
+// blacklist is saved if BlackListPath is set, Run returns and the chan
+// returned by Torrents is closed. It's safe to call Close more than once.
+func (dht *DHT) Close() (err error) {
+	dht.closeOnce.Do(func() {
+		close(dht.done)
+		if dht.transactionManager != nil {
+			dht.transactionManager.drain()
+		}
+		if dht.BlackListPath != "" {
+			if e := dht.saveBlackList(); e != nil {
+				dht.logger.Warn("save blacklist failed", zap.Error(e))
+			}
+		}
+		if dht.transport != nil {
+			err = dht.transport.Close()
+		}
+	})
+	return
+}
+
+// Joined returns a chan which is closed once Run has joined the network and
+// Ready is set.
+func (dht *DHT) Joined() <-chan struct{} {
+	return dht.joined
+}
+
+// Run starts the dht. It blocks until Close is called.
 func (dht *DHT) Run() {
 	dht.init()
 	dht.listen()
+	dht.seed()
 	dht.join()
 
 	dht.Ready = true
+	close(dht.joined)
 
 	var pkt packet
 	ticker := time.NewTicker(dht.CheckKBucketPeriod)
 
+	defer ticker.Stop()
+
 	for {
 		select {
+		case <-dht.done:
+			return
 		case pkt = <-dht.packets:
 			handle(dht, pkt)
 		case <-ticker.C:
-			if dht.routingTable.Len() == 0 {
+			if dht.Paused() {
+				continue
+			}
+
+			if dht.routingTable.Len() == 0 || dht.bootstrapping() {
 				dht.join()
 			} else if dht.transactionManager.len() == 0 {
 				go dht.routingTable.Fresh()
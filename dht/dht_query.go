@@ -11,6 +11,8 @@ const (
 	DHTQueryTypeFindNode     DHTQueryType = "find_node"
 	DHTQueryTypeGetPeers     DHTQueryType = "get_peers"
 	DHTQueryTypeAnnouncePeer DHTQueryType = "announce_peer"
+
+	DHTQueryTypeSampleInfohashes DHTQueryType = "sample_infohashes"
 )
 
 func (q DHTQueryType) String() string {
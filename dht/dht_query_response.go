@@ -31,7 +31,7 @@ func (r *DHTQueryResponse) ToPayload() map[string]interface{} {
 	return map[string]interface{}{
 		"t": r.TransactionID,
 		"y": "r",
-		"e": r.Arguments,
+		"r": r.Arguments,
 	}
 }
 
@@ -0,0 +1,56 @@
+package dhttest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MildC/dht-crawler/dht"
+)
+
+// joinTimeout is how long NewDHT waits for the DHT to join the network.
+const joinTimeout = time.Second * 5
+
+// ErrJoinTimeout is the error that a DHT doesn't join the network in time.
+var ErrJoinTimeout = errors.New("dht doesn't join in time")
+
+// NewDHT runs a DHT on a new address of the network and waits until it's
+// ready. If config is nil, the standard config without prime nodes is used.
+// Network, Address and Transport of config are overwritten. The prime nodes
+// should be the addresses of the other DHTs on the network, e.g. by
+// Addresses. Close the DHT to stop it.
+func (nw *Network) NewDHT(config *dht.Config) (*dht.DHT, error) {
+	if config == nil {
+		config = dht.NewStandardConfig()
+		config.PrimeNodes = nil
+	}
+
+	conn, err := nw.Listen(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Network = "udp4"
+	config.Address = conn.LocalAddr().String()
+	config.Transport = conn
+
+	d := dht.New(nil, config)
+	go d.Run()
+
+	select {
+	case <-d.Joined():
+		return d, nil
+	case <-time.After(joinTimeout):
+		d.Close()
+		return nil, ErrJoinTimeout
+	}
+}
+
+// Addresses returns the addresses of the DHTs, e.g. to be the prime nodes
+// of another DHT.
+func Addresses(dhts ...*dht.DHT) []string {
+	addrs := make([]string, len(dhts))
+	for i, d := range dhts {
+		addrs[i] = d.Address
+	}
+	return addrs
+}
@@ -0,0 +1,265 @@
+// Package dhttest provides an in-memory packet network to wire DHT
+// instances together in tests without real UDP.
+//
+// The packets are delivered in the order they're written unless a Rule drops
+// or delays them, so a test without rules is deterministic up to the
+// scheduling of the goroutines of the DHTs.
+package dhttest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inboxSize is how many packets a Conn holds before it's read. The packets
+// beyond it are dropped like the ones beyond the receive buffer of a
+// socket.
+const inboxSize = 1024
+
+// ErrAddressInUse is the error that the address to listen on is taken by
+// another Conn.
+var ErrAddressInUse = errors.New("address already in use")
+
+// Packet is a datagram on a Network.
+type Packet struct {
+	From, To *net.UDPAddr
+	Data     []byte
+}
+
+// Rule decides what happens to a packet. The packet is dropped if drop is
+// true, otherwise it's delivered after delay.
+type Rule func(p Packet) (drop bool, delay time.Duration)
+
+// DropRate returns a Rule which drops the packets at rate. The drops are
+// the same for the same seed and the same sequence of packets.
+func DropRate(rate float64, seed int64) Rule {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+
+	return func(Packet) (bool, time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return r.Float64() < rate, 0
+	}
+}
+
+// Delay returns a Rule which delays every packet by d.
+func Delay(d time.Duration) Rule {
+	return func(Packet) (bool, time.Duration) {
+		return false, d
+	}
+}
+
+// Network is an in-memory packet network. The Conns listening on it
+// exchange the packets by their addresses, a packet to an address nobody
+// listens on is lost.
+type Network struct {
+	// the 64-bit atomic fields are kept first for alignment.
+	delivered uint64
+	dropped   uint64
+	mu        sync.Mutex
+	conns     map[string]*Conn
+	rule      Rule
+	next      uint32
+}
+
+// NewNetwork returns a Network pointer.
+func NewNetwork() *Network {
+	return &Network{conns: make(map[string]*Conn)}
+}
+
+// SetRule sets the rule applied to every packet written afterwards. A nil
+// rule delivers all the packets at once.
+func (nw *Network) SetRule(rule Rule) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.rule = rule
+}
+
+// Listen returns a Conn on addr. If addr is nil, an unused address in
+// 198.18.0.0/15 is allocated.
+func (nw *Network) Listen(addr *net.UDPAddr) (*Conn, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	if addr == nil {
+		for {
+			nw.next++
+			addr = &net.UDPAddr{
+				IP:   net.IPv4(198, 18+byte(nw.next>>16&1), byte(nw.next>>8), byte(nw.next)),
+				Port: 6881,
+			}
+			if _, ok := nw.conns[addr.String()]; !ok {
+				break
+			}
+		}
+	}
+
+	if _, ok := nw.conns[addr.String()]; ok {
+		return nil, ErrAddressInUse
+	}
+
+	conn := &Conn{
+		nw:    nw,
+		addr:  addr,
+		inbox: make(chan Packet, inboxSize),
+		done:  make(chan struct{}),
+	}
+	nw.conns[addr.String()] = conn
+	return conn, nil
+}
+
+// Delivered returns how many packets are delivered.
+func (nw *Network) Delivered() uint64 {
+	return atomic.LoadUint64(&nw.delivered)
+}
+
+// Dropped returns how many packets are dropped, by the rule or because the
+// inbox of the receiver is full. The packets to unknown addresses aren't
+// counted.
+func (nw *Network) Dropped() uint64 {
+	return atomic.LoadUint64(&nw.dropped)
+}
+
+// send routes p by the rule.
+func (nw *Network) send(p Packet) {
+	nw.mu.Lock()
+	rule := nw.rule
+	nw.mu.Unlock()
+
+	var delay time.Duration
+	if rule != nil {
+		var drop bool
+		if drop, delay = rule(p); drop {
+			atomic.AddUint64(&nw.dropped, 1)
+			return
+		}
+	}
+
+	if delay > 0 {
+		time.AfterFunc(delay, func() { nw.deliver(p) })
+		return
+	}
+	nw.deliver(p)
+}
+
+// deliver puts p into the inbox of its receiver.
+func (nw *Network) deliver(p Packet) {
+	nw.mu.Lock()
+	conn, ok := nw.conns[p.To.String()]
+	nw.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case conn.inbox <- p:
+		atomic.AddUint64(&nw.delivered, 1)
+	default:
+		atomic.AddUint64(&nw.dropped, 1)
+	}
+}
+
+// remove stops routing the packets to conn.
+func (nw *Network) remove(conn *Conn) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	if nw.conns[conn.addr.String()] == conn {
+		delete(nw.conns, conn.addr.String())
+	}
+}
+
+// Conn is a dht.Transport on a Network.
+type Conn struct {
+	nw        *Network
+	addr      *net.UDPAddr
+	inbox     chan Packet
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// ReadFrom reads a packet into b. A packet longer than b is truncated. It
+// returns os.ErrDeadlineExceeded if the read deadline passes, which is
+// checked when the read starts, and net.ErrClosed if the conn is closed.
+func (conn *Conn) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	conn.mu.Lock()
+	deadline := conn.readDeadline
+	conn.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-conn.done:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+
+	select {
+	case p := <-conn.inbox:
+		return copy(b, p.Data), p.From, nil
+	case <-conn.done:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo writes a packet to addr. It never blocks, the packet is lost if
+// nobody listens on addr.
+func (conn *Conn) WriteTo(b []byte, addr *net.UDPAddr) error {
+	select {
+	case <-conn.done:
+		return net.ErrClosed
+	default:
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+
+	conn.nw.send(Packet{From: conn.addr, To: addr, Data: data})
+	return nil
+}
+
+// Close closes the conn and frees its address. The blocked reads return
+// net.ErrClosed.
+func (conn *Conn) Close() error {
+	err := net.ErrClosed
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+		conn.nw.remove(conn)
+		err = nil
+	})
+	return err
+}
+
+// LocalAddr returns the address of the conn.
+func (conn *Conn) LocalAddr() *net.UDPAddr {
+	return conn.addr
+}
+
+// SetReadDeadline sets the deadline of the reads starting afterwards. A
+// zero t means no deadline.
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.readDeadline = t
+	return nil
+}
@@ -0,0 +1,133 @@
+package dhttest
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MildC/dht-crawler/dht"
+)
+
+func TestConn(t *testing.T) {
+	nw := NewNetwork()
+
+	a, _ := nw.Listen(nil)
+	b, _ := nw.Listen(nil)
+	defer a.Close()
+
+	if _, err := nw.Listen(a.LocalAddr()); err != ErrAddressInUse {
+		t.Error(err)
+	}
+
+	// the packets arrive in order, and one to an unknown address is lost.
+	for _, data := range []string{"a", "b", "c"} {
+		a.WriteTo([]byte(data), b.LocalAddr())
+	}
+	a.WriteTo([]byte("x"), &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1})
+
+	buff := make([]byte, 8)
+	for _, data := range []string{"a", "b", "c"} {
+		n, from, err := b.ReadFrom(buff)
+		if err != nil || string(buff[:n]) != data || from.String() != a.LocalAddr().String() {
+			t.Error(string(buff[:n]), from, err)
+		}
+	}
+
+	b.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	if _, _, err := b.ReadFrom(buff); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error(err)
+	}
+
+	b.Close()
+	if _, _, err := b.ReadFrom(buff); !errors.Is(err, net.ErrClosed) {
+		t.Error(err)
+	}
+	if nw.Delivered() != 3 || nw.Dropped() != 0 {
+		t.Error(nw.Delivered(), nw.Dropped())
+	}
+}
+
+func TestRules(t *testing.T) {
+	nw := NewNetwork()
+
+	a, _ := nw.Listen(nil)
+	b, _ := nw.Listen(nil)
+	defer a.Close()
+	defer b.Close()
+
+	// the drops are the same for the same seed.
+	drops := func() uint64 {
+		before := nw.Dropped()
+		nw.SetRule(DropRate(0.5, 1))
+		for i := 0; i < 100; i++ {
+			a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+		}
+		return nw.Dropped() - before
+	}
+	if first, second := drops(), drops(); first == 0 || first == 100 || first != second {
+		t.Error(first, second)
+	}
+
+	nw.SetRule(Delay(time.Millisecond * 20))
+	start := time.Now()
+	a.WriteTo([]byte("delayed"), b.LocalAddr())
+
+	buff := make([]byte, 8)
+	for {
+		n, _, err := b.ReadFrom(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buff[:n]) == "delayed" {
+			break
+		}
+	}
+	if time.Since(start) < time.Millisecond*20 {
+		t.Error(time.Since(start))
+	}
+}
+
+func TestCluster(t *testing.T) {
+	nw := NewNetwork()
+
+	prime, err := nw.NewDHT(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prime.Close()
+
+	nodes := make([]*dht.DHT, 7)
+	for i := range nodes {
+		config := dht.NewStandardConfig()
+		config.PrimeNodes = Addresses(prime)
+
+		if nodes[i], err = nw.NewDHT(config); err != nil {
+			t.Fatal(err)
+		}
+		defer nodes[i].Close()
+	}
+
+	// the prime node learns all the nodes joining through it.
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		known, err := prime.ClosestNodes(string(make([]byte, 20)), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(known) == len(nodes) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d nodes in the routing table", len(known))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	nw.SetRule(Delay(time.Millisecond * 20))
+	rtt, err := prime.Ping(nodes[0].Address)
+	if err != nil || rtt < time.Millisecond*40 {
+		t.Error(rtt, err)
+	}
+}
@@ -0,0 +1,18 @@
+package dht
+
+import "expvar"
+
+// PublishExpvars publishes the core counters of d under the "dht" variable
+// of the expvar package, so they're served at /debug/vars by the default
+// http mux. The values are read when the variable is requested. Like
+// expvar.Publish, it panics if it's called more than once.
+func PublishExpvars(d *DHT) {
+	vars := expvar.NewMap("dht")
+
+	for _, m := range metrics {
+		value := m.value
+		vars.Set(m.name, expvar.Func(func() interface{} {
+			return value(d)
+		}))
+	}
+}
@@ -0,0 +1,28 @@
+package dht
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvars(t *testing.T) {
+	d := New(nil, nil)
+	d.droppedPackets = 3
+
+	PublishExpvars(d)
+
+	v := expvar.Get("dht")
+	if v == nil {
+		t.Fatal("dht vars not published")
+	}
+
+	vars := make(map[string]int)
+	if err := json.Unmarshal([]byte(v.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if vars["packets_dropped"] != 3 || vars["routing_table_size"] != 0 {
+		t.Errorf("unexpected vars %v", vars)
+	}
+}
@@ -0,0 +1,90 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// nodeFailure records the consecutive failures of a node.
+type nodeFailure struct {
+	count      int
+	retryAfter time.Time
+}
+
+// failureTracker counts the consecutive query failures of nodes keyed by
+// address. After every failure a node backs off for an exponentially
+// growing duration.
+type failureTracker struct {
+	sync.Mutex
+	backoff  time.Duration
+	failures map[string]*nodeFailure
+	clock    Clock
+}
+
+// newFailureTracker returns a failureTracker pointer. The first backoff
+// lasts backoff, then it doubles.
+func newFailureTracker(backoff time.Duration, clock Clock) *failureTracker {
+	return &failureTracker{
+		backoff:  backoff,
+		failures: make(map[string]*nodeFailure),
+		clock:    clock,
+	}
+}
+
+// fail records a failure of the node and returns its consecutive failures.
+func (ft *failureTracker) fail(address string) int {
+	ft.Lock()
+	defer ft.Unlock()
+
+	f, ok := ft.failures[address]
+	if !ok {
+		f = &nodeFailure{}
+		ft.failures[address] = f
+	}
+
+	f.retryAfter = ft.clock.Now().Add(ft.backoff << uint(f.count))
+	f.count++
+
+	return f.count
+}
+
+// reset forgets the failures of the node.
+func (ft *failureTracker) reset(address string) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	delete(ft.failures, address)
+}
+
+// backingOff returns whether the node should not be queried now.
+func (ft *failureTracker) backingOff(address string) bool {
+	ft.Lock()
+	defer ft.Unlock()
+
+	f, ok := ft.failures[address]
+	return ok && ft.clock.Now().Before(f.retryAfter)
+}
+
+// clear removes the failures whose backoff ended long ago every 10 minutes
+// until done is closed.
+func (ft *failureTracker) clear(done <-chan struct{}) {
+	ticker := ft.clock.NewTicker(time.Minute * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+		now := ft.clock.Now()
+
+		ft.Lock()
+		for address, f := range ft.failures {
+			if now.Sub(f.retryAfter) > time.Hour {
+				delete(ft.failures, address)
+			}
+		}
+		ft.Unlock()
+	}
+}
@@ -0,0 +1,58 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureTracker(t *testing.T) {
+	clock := newFakeClock()
+	ft := newFailureTracker(time.Hour, clock)
+	address := "1.1.1.1:8080"
+
+	if ft.backingOff(address) {
+		t.Fail()
+	}
+
+	for i := 1; i <= 3; i++ {
+		if ft.fail(address) != i || !ft.backingOff(address) {
+			t.Fail()
+		}
+	}
+
+	if ft.failures[address].retryAfter.Sub(clock.Now()) <= time.Hour*3 {
+		t.Error("backoff should grow exponentially")
+	}
+
+	clock.Advance(time.Hour * 4)
+	if ft.backingOff(address) {
+		t.Error("backoff should end")
+	}
+
+	ft.reset(address)
+	if ft.backingOff(address) || ft.fail(address) != 1 {
+		t.Fail()
+	}
+
+	// the failures are cleared an hour after their backoff ends.
+	done := make(chan struct{})
+	defer close(done)
+	go ft.clear(done)
+	waitPending(t, clock, 1)
+
+	clock.Advance(time.Hour * 3)
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		ft.Lock()
+		n := len(ft.failures)
+		ft.Unlock()
+
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d failures kept", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,47 @@
+package dht
+
+// IDStrategy is how many leading bytes of the target the node id sent in a
+// message about the target mirrors, the rest is taken from the real node
+// id. The target is e.g. the target of a find_node or the infohash of a
+// get_peers. A ping response always carries the real node id.
+//
+// The more bytes are mirrored, the closer the id looks to the target, so the
+// remote nodes are more likely to keep us in their routing tables and to
+// send us the queries about the target, which makes a crawler see more
+// traffic. But an id changing with every message is easy to detect, and a
+// node may penalize it, e.g. by dropping us from its routing table or
+// blacklisting our address. A fully mirrored id equals the target, which a
+// node usually ignores as its own id or as an obvious spoof.
+type IDStrategy int
+
+const (
+	// StaticID sends the node id in every message, like a standard node.
+	StaticID IDStrategy = -1
+	// DefaultIDStrategy is the zero IDStrategy, which is StaticID in
+	// standard mode and PrefixMirror(15) in crawling mode.
+	DefaultIDStrategy IDStrategy = 0
+	// FullMirror sends the target itself as the id.
+	FullMirror IDStrategy = 20
+)
+
+// PrefixMirror returns the IDStrategy mirroring the first n bytes of the
+// target. n is capped to [0, 20], so PrefixMirror(0) is StaticID and
+// PrefixMirror(20) is FullMirror.
+func PrefixMirror(n int) IDStrategy {
+	if n <= 0 {
+		return StaticID
+	} else if n > int(FullMirror) {
+		return FullMirror
+	}
+	return IDStrategy(n)
+}
+
+// mirror returns the id mirroring the prefix of target, the rest is taken
+// from id.
+func (s IDStrategy) mirror(target, id string) string {
+	n := int(s)
+	if n <= 0 || len(target) < n {
+		return id
+	}
+	return target[:n] + id[n:]
+}
@@ -0,0 +1,29 @@
+package dht
+
+import "testing"
+
+func TestIDStrategy(t *testing.T) {
+	id, target := "abcdefghijklmnopqrst", "ABCDEFGHIJKLMNOPQRST"
+
+	cases := []struct {
+		strategy IDStrategy
+		target   string
+		out      string
+	}{
+		{StaticID, target, id},
+		{PrefixMirror(-1), target, id},
+		{PrefixMirror(4), target, "ABCDefghijklmnopqrst"},
+		{PrefixMirror(15), target, "ABCDEFGHIJKLMNOpqrst"},
+		{PrefixMirror(32), target, target},
+		{FullMirror, target, target},
+		// no target, or one shorter than the prefix, isn't mirrored.
+		{FullMirror, "", id},
+		{PrefixMirror(4), "ABC", id},
+	}
+
+	for _, c := range cases {
+		if out := c.strategy.mirror(c.target, id); out != c.out {
+			t.Errorf("strategy %d: %s, want %s", c.strategy, out, c.out)
+		}
+	}
+}
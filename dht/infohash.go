@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"encoding/hex"
+)
+
+// InfoHash is the 20-byte sha1 of the info dict of a torrent. The zero value
+// isn't a valid infohash.
+type InfoHash [20]byte
+
+// ParseInfoHash returns the InfoHash of s, which is 20 raw bytes or 40 hex
+// characters. It returns ErrInvalidInfoHash for any other s.
+func ParseInfoHash(s string) (ih InfoHash, err error) {
+	switch len(s) {
+	case len(ih):
+		copy(ih[:], s)
+	case hex.EncodedLen(len(ih)):
+		err = ih.FromHex(s)
+	default:
+		err = ErrInvalidInfoHash
+	}
+	return
+}
+
+// rawInfoHash returns the InfoHash of the 20 raw bytes s, which is checked
+// by the caller.
+func rawInfoHash(s string) (ih InfoHash) {
+	copy(ih[:], s)
+	return
+}
+
+// NewInfoHash returns the InfoHash of the 20 raw bytes data. It returns
+// ErrInvalidInfoHash if data isn't 20 bytes.
+func NewInfoHash(data []byte) (ih InfoHash, err error) {
+	if len(data) != len(ih) {
+		return ih, ErrInvalidInfoHash
+	}
+	copy(ih[:], data)
+	return
+}
+
+// FromHex sets ih to the infohash of the 40 hex characters s. It returns
+// ErrInvalidInfoHash and leaves ih unchanged if s is malformed.
+func (ih *InfoHash) FromHex(s string) error {
+	var data InfoHash
+	if len(s) != hex.EncodedLen(len(data)) {
+		return ErrInvalidInfoHash
+	}
+
+	if _, err := hex.Decode(data[:], []byte(s)); err != nil {
+		return ErrInvalidInfoHash
+	}
+
+	*ih = data
+	return nil
+}
+
+// Hex returns the 40 lowercase hex characters of ih.
+func (ih InfoHash) Hex() string {
+	return hex.EncodeToString(ih[:])
+}
+
+// Raw returns the 20 raw bytes of ih as a string, the form the krpc messages
+// carry.
+func (ih InfoHash) Raw() string {
+	return string(ih[:])
+}
+
+// String returns the hex form of ih.
+func (ih InfoHash) String() string {
+	return ih.Hex()
+}
+
+// Valid returns whether ih is set, i.e. it isn't all zeros.
+func (ih InfoHash) Valid() bool {
+	return ih != InfoHash{}
+}
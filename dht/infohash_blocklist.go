@@ -0,0 +1,108 @@
+package dht
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// InfohashBlocklist is a goroutine-safe set of blocked infohashes, e.g. the
+// known spam swarms flooding the dht. It can be reloaded while the dht is
+// running.
+type InfohashBlocklist struct {
+	sync.RWMutex
+	hashes map[string]struct{}
+}
+
+// NewInfohashBlocklist returns an InfohashBlocklist pointer blocking the hex
+// encoded infohashes.
+func NewInfohashBlocklist(hexHashes ...string) (*InfohashBlocklist, error) {
+	hashes, err := decodeInfohashes(hexHashes)
+	if err != nil {
+		return nil, err
+	}
+	return &InfohashBlocklist{hashes: hashes}, nil
+}
+
+// decodeInfohashes decodes the hex encoded infohashes to a set of raw
+// infohashes.
+func decodeInfohashes(hexHashes []string) (map[string]struct{}, error) {
+	hashes := make(map[string]struct{}, len(hexHashes))
+
+	for _, h := range hexHashes {
+		var ih InfoHash
+		if err := ih.FromHex(h); err != nil {
+			return nil, err
+		}
+		hashes[ih.Raw()] = struct{}{}
+	}
+	return hashes, nil
+}
+
+// Has returns whether the raw infohash is blocked.
+func (bl *InfohashBlocklist) Has(infoHash string) bool {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	_, ok := bl.hashes[infoHash]
+	return ok
+}
+
+// Len returns how many infohashes are blocked.
+func (bl *InfohashBlocklist) Len() int {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	return len(bl.hashes)
+}
+
+// Load replaces the blocked infohashes with the ones read from r, one hex
+// encoded infohash per line. Blank lines and the lines starting with # are
+// skipped. If any line is invalid, an error is returned and the blocklist
+// isn't changed.
+func (bl *InfohashBlocklist) Load(r io.Reader) error {
+	lines := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	hashes, err := decodeInfohashes(lines)
+	if err != nil {
+		return err
+	}
+
+	bl.Lock()
+	bl.hashes = hashes
+	bl.Unlock()
+
+	return nil
+}
+
+// LoadFile replaces the blocked infohashes with the ones in the file at
+// path, see Load.
+func (bl *InfohashBlocklist) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bl.Load(f)
+}
+
+// isBlocked returns whether the raw infohash is in InfohashBlocklist.
+func (dht *DHT) isBlocked(infoHash string) bool {
+	return dht.InfohashBlocklist != nil && dht.InfohashBlocklist.Has(infoHash)
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInfohashBlocklistLoad(t *testing.T) {
+	blocked := strings.Repeat("a", 20)
+
+	bl, err := NewInfohashBlocklist(hex.EncodeToString([]byte(blocked)))
+	if err != nil || !bl.Has(blocked) {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		in  string
+		ok  bool
+		len int
+	}{
+		{"# spam\n\n" + strings.Repeat("62", 20) + "\n" + strings.Repeat("63", 20), true, 2},
+		{strings.Repeat("64", 20) + "\nxyz", false, 2},
+		{"", true, 0},
+	}
+
+	for _, c := range cases {
+		if err := bl.Load(strings.NewReader(c.in)); (err == nil) != c.ok || bl.Len() != c.len {
+			t.Errorf("Load(%q): error %v, %d infohashes", c.in, err, bl.Len())
+		}
+	}
+}
+
+func TestInfohashBlocklistHandler(t *testing.T) {
+	blocked, allowed := randomString(20), randomString(20)
+
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+	config.InfohashBlocklist, _ = NewInfohashBlocklist(
+		hex.EncodeToString([]byte(blocked)))
+
+	reported := make([]string, 0)
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		reported = append(reported, infoHash.Raw())
+	}
+	config.OnAnnouncePeerIP = func(infoHash InfoHash, ip net.IP, port int) {
+		reported = append(reported, infoHash.Raw())
+	}
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	id := randomString(20)
+
+	for _, infoHash := range []string{blocked, allowed} {
+		for _, q := range []DHTQueryType{DHTQueryTypeGetPeers, DHTQueryTypeAnnouncePeer} {
+			payload := NewDHTQuery("aa", q, map[string]interface{}{
+				"id":        id,
+				"info_hash": infoHash,
+				"port":      6881,
+				"token":     dht.tokenManager.token(addr),
+			}).ToPayload()
+
+			if !handleRequest(dht, addr, payload) {
+				t.Errorf("%s not handled", q)
+			}
+		}
+	}
+
+	if len(reported) != 2 || reported[0] != allowed || reported[1] != allowed {
+		t.Error("blocked infohash reported")
+	}
+
+	if len(dht.peersManager.GetPeers(blocked, 8)) != 0 ||
+		len(dht.peersManager.GetPeers(allowed, 8)) != 1 {
+		t.Error("blocked infohash stored")
+	}
+}
@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInfoHash(t *testing.T) {
+	raw := "abcdefghijklmnopqrst"
+
+	cases := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{raw, raw, true},
+		{"6162636465666768696a6b6c6d6e6f7071727374", raw, true},
+		{"6162636465666768696A6B6C6D6E6F7071727374", raw, true},
+		{strings.Repeat("z", 40), "", false},
+		{"short", "", false},
+	}
+
+	for _, c := range cases {
+		ih, err := ParseInfoHash(c.in)
+		if (err == nil) != c.ok || c.ok && ih.Raw() != c.out {
+			t.Errorf("ParseInfoHash(%q) = %q, %v", c.in, ih.Raw(), err)
+		}
+		if !c.ok && (err != ErrInvalidInfoHash || ih.Valid()) {
+			t.Errorf("ParseInfoHash(%q) = %q, %v", c.in, ih.Raw(), err)
+		}
+	}
+}
+
+func TestInfoHash(t *testing.T) {
+	hexHash := "6162636465666768696a6b6c6d6e6f7071727374"
+
+	var ih InfoHash
+	if ih.Valid() {
+		t.Error("zero infohash is valid")
+	}
+
+	if ih.FromHex(hexHash[:38]) == nil || ih.FromHex(strings.Repeat("z", 40)) == nil ||
+		ih.Valid() {
+		t.Error("malformed hex accepted")
+	}
+
+	if err := ih.FromHex(hexHash); err != nil || !ih.Valid() ||
+		ih.Hex() != hexHash || ih.String() != hexHash ||
+		ih.Raw() != "abcdefghijklmnopqrst" {
+		t.Errorf("FromHex(%q) = %q, %v", hexHash, ih.Raw(), err)
+	}
+
+	if _, err := NewInfoHash([]byte("short")); err != ErrInvalidInfoHash {
+		t.Error(err)
+	}
+	if from, err := NewInfoHash([]byte(ih.Raw())); err != nil || from != ih {
+		t.Error(err)
+	}
+}
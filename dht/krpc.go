@@ -4,20 +4,19 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
-)
 
-const (
-	generalError = 201 + iota
-	serverError
-	protocolError
-	unknownError
+	"go.uber.org/zap"
 )
 
 // packet represents the information receive from udp.
 type packet struct {
 	data  []byte
 	raddr *net.UDPAddr
+	// the pooled buffer holding data, it's put back after the packet is
+	// handled
+	buff *[]byte
 }
 
 // token represents the token when response getPeers request.
@@ -43,15 +42,21 @@ func newTokenManager(expiredAfter time.Duration, dht *DHT) *tokenManager {
 }
 
 // token returns a token. If it doesn't exist or is expired, it will add a
-// new token.
+// new token. When the tokens exceed the memory budget, an arbitrary one is
+// evicted for the new one.
 func (tm *tokenManager) token(addr *net.UDPAddr) string {
 	v, ok := tm.Get(addr.IP.String())
 	tk, _ := v.(token)
 
-	if !ok || time.Since(tk.createTime) > tm.expiredAfter {
+	now := tm.dht.Clock.Now()
+	if !ok || now.Sub(tk.createTime) > tm.expiredAfter {
 		tk = token{
 			data:       randomString(5),
-			createTime: time.Now(),
+			createTime: now,
+		}
+
+		if max := tm.dht.maxTokens(); !ok && max > 0 && tm.Len() >= max {
+			tm.DeleteAny()
 		}
 
 		tm.Set(addr.IP.String(), tk)
@@ -62,12 +67,12 @@ func (tm *tokenManager) token(addr *net.UDPAddr) string {
 
 // clear removes expired tokens.
 func (tm *tokenManager) clear() {
-	for range time.Tick(time.Minute * 3) {
+	for now := range tm.dht.Clock.Tick(time.Minute * 3) {
 		keys := make([]interface{}, 0, 100)
 
-		for item := range tm.Iter() {
-			if time.Since(item.val.(token).createTime) > tm.expiredAfter {
-				keys = append(keys, item.key)
+		for key, val := range tm.Snapshot() {
+			if now.Sub(val.(token).createTime) > tm.expiredAfter {
+				keys = append(keys, key)
 			}
 		}
 
@@ -75,24 +80,24 @@ func (tm *tokenManager) clear() {
 	}
 }
 
-// check returns whether the token is valid.
+// check returns whether the token is valid. A token stays valid until it
+// expires, so a peer can retry an announce whose response is lost, and a
+// wrong token doesn't invalidate the right one.
 func (tm *tokenManager) check(addr *net.UDPAddr, tokenString string) bool {
-	key := addr.IP.String()
-	v, ok := tm.Get(key)
+	v, ok := tm.Get(addr.IP.String())
 	tk, _ := v.(token)
 
-	if ok {
-		tm.Delete(key)
-	}
-
-	return ok && tokenString == tk.data
+	return ok && tokenString == tk.data &&
+		tm.dht.Clock.Now().Sub(tk.createTime) <= tm.expiredAfter
 }
 
-// send sends data to the udp.
+// send sends data to the udp. Nothing is sent if PassiveOnly is set.
 func send(dht *DHT, addr *net.UDPAddr, q DHTPayload) error {
-	dht.conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+	if dht.PassiveOnly {
+		return nil
+	}
 
-	_, err := dht.conn.WriteToUDP([]byte(Encode(q.ToPayload())), addr)
+	err := dht.transport.WriteTo([]byte(Encode(q.ToPayload())), addr)
 	if err != nil {
 		dht.blackList.insert(addr.IP.String(), -1)
 	}
@@ -154,17 +159,113 @@ func parseMessage(data interface{}) (map[string]interface{}, error) {
 	return response, nil
 }
 
+// onGetPeers calls OnGetPeers, OnGetPeersIP and OnGetPeersCount if they're
+// set.
+func (dht *DHT) onGetPeers(infoHash string, ip net.IP, port int) {
+	ih := rawInfoHash(infoHash)
+	if dht.OnGetPeers != nil {
+		dht.OnGetPeers(infoHash, ip.String(), port)
+	}
+	if dht.OnGetPeersIP != nil {
+		dht.OnGetPeersIP(ih, ip, port)
+	}
+	if dht.OnGetPeersCount != nil {
+		dht.OnGetPeersCount(ih, ip, port,
+			dht.peersManager.Count(infoHash))
+	}
+}
+
+// observeSwarm records the peer of infoHash by the swarm tracker if it's
+// enabled.
+func (dht *DHT) observeSwarm(infoHash string, p Peer) {
+	if dht.swarmTracker != nil {
+		dht.swarmTracker.Observe(infoHash, p)
+	}
+}
+
+// onAnnouncePeer calls both OnAnnouncePeer and OnAnnouncePeerIP if they're
+// set.
+func (dht *DHT) onAnnouncePeer(infoHash string, ip net.IP, port int) {
+	ih := rawInfoHash(infoHash)
+	if dht.OnAnnouncePeer != nil {
+		dht.OnAnnouncePeer(infoHash, ip.String(), port)
+	}
+	if dht.OnAnnouncePeerIP != nil {
+		dht.OnAnnouncePeerIP(ih, ip, port)
+	}
+}
+
+// want returns the want list(see http://www.bittorrent.org/beps/bep_0032.html)
+// of the outgoing queries, derived from Network.
+func (dht *DHT) want() []interface{} {
+	switch dht.Network {
+	case "udp4":
+		return []interface{}{"n4"}
+	case "udp6":
+		return []interface{}{"n6"}
+	default:
+		return []interface{}{"n4", "n6"}
+	}
+}
+
+// parseWant returns whether the query arguments a want IPv4 and IPv6 nodes.
+// If there is no valid want, only the nodes of the same family as addr are
+// wanted.
+func parseWant(a map[string]interface{}, addr *net.UDPAddr) (n4, n6 bool) {
+	if want, ok := a["want"].([]interface{}); ok {
+		for _, w := range want {
+			switch w {
+			case "n4":
+				n4 = true
+			case "n6":
+				n6 = true
+			}
+		}
+	}
+
+	if !n4 && !n6 {
+		n4 = addr.IP.To4() != nil
+		n6 = !n4
+	}
+	return
+}
+
+// putNodes puts the compact infos of the K nodes closest to target to nodes
+// if n4 is true, and to nodes6 if n6 is true.
+func putNodes(dht *DHT, r map[string]interface{}, target *bitmap, n4, n6 bool) {
+	for _, family := range []struct {
+		key    string
+		wanted bool
+		ipv6   bool
+	}{
+		{"nodes", n4, false},
+		{"nodes6", n6, true},
+	} {
+		if !family.wanted {
+			continue
+		}
+
+		neighbors := dht.routingTable.GetNeighborsByFamily(target, dht.K, family.ipv6)
+
+		infos := make([]string, len(neighbors))
+		for i, no := range neighbors {
+			infos[i] = no.CompactNodeInfo()
+		}
+		r[family.key] = strings.Join(infos, "")
+	}
+}
+
 // handleRequest handles the requests received from udp.
 func handleRequest(dht *DHT, addr *net.UDPAddr, payload map[string]interface{}) (success bool) {
 	q := NewDHTQueryFromPayload(payload)
 
 	if err := ParseKeys(payload, [][]string{{"q", "string"}, {"a", "map"}}); err != nil {
-		send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, err.Error()))
+		sendError(dht, addr, q.TransactionID, NewProtocolError(err.Error()))
 		return
 	}
 
 	if err := ParseKey(q.Arguments, "id", "string"); err != nil {
-		send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, err.Error()))
+		sendError(dht, addr, q.TransactionID, NewProtocolError(err.Error()))
 		return
 	}
 
@@ -174,7 +275,7 @@ func handleRequest(dht *DHT, addr *net.UDPAddr, payload map[string]interface{})
 	}
 
 	if len(id) != 20 {
-		send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, "invalid id"))
+		sendError(dht, addr, q.TransactionID, NewProtocolError("invalid id"))
 		return
 	}
 
@@ -184,89 +285,109 @@ func handleRequest(dht *DHT, addr *net.UDPAddr, payload map[string]interface{})
 		dht.blackList.insert(addr.IP.String(), addr.Port)
 		dht.routingTable.RemoveByAddr(addr.String())
 
-		send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, "invalid id"))
+		sendError(dht, addr, q.TransactionID, NewProtocolError("invalid id"))
+		return
+	}
+
+	// While bootstrapping, nothing but the pings are answered, which would
+	// return few or no nodes, but the querying node still fills the table.
+	if q.QueryType != DHTQueryTypePing && dht.bootstrapping() {
+		dht.routingTable.Insert(newNodeAt(id, addr, dht.Clock.Now()))
 		return
 	}
 
 	switch q.QueryType {
 	case DHTQueryTypePing:
+		// a ping is about no target, so it's answered with the node id
+		// whatever IDStrategy is.
 		send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
-			"id": dht.id(id),
+			"id": dht.node.IDRawString(),
 		}))
 	case DHTQueryTypeFindNode:
 		if dht.IsStandardMode() {
 			if err := ParseKey(q.Arguments, "target", "string"); err != nil {
-				send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, err.Error()))
+				sendError(dht, addr, q.TransactionID, NewProtocolError(err.Error()))
 				return
 			}
 
 			target := q.Arguments["target"].(string)
 			if len(target) != 20 {
-				send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, "invalid target"))
+				sendError(dht, addr, q.TransactionID, NewProtocolError("invalid target"))
 				return
 			}
 
-			var nodes string
+			r := map[string]interface{}{"id": dht.id(target)}
 			targetID := newBitmapFromString(target)
+			n4, n6 := parseWant(q.Arguments, addr)
 
 			no, _ := dht.routingTable.GetNodeKBucktByID(targetID)
 			if no != nil {
-				nodes = no.CompactNodeInfo()
+				if no.Address().IP.To4() != nil {
+					r["nodes"] = no.CompactNodeInfo()
+				} else {
+					r["nodes6"] = no.CompactNodeInfo()
+				}
 			} else {
-				nodes = strings.Join(
-					dht.routingTable.GetNeighborCompactInfos(targetID, dht.K),
-					"",
-				)
+				putNodes(dht, r, targetID, n4, n6)
 			}
 
-			send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
-				"id":    dht.id(target),
-				"nodes": nodes,
-			}))
+			send(dht, addr, NewDHTQueryResponse(q.TransactionID, r))
 		}
 	case DHTQueryTypeGetPeers:
 		if err := ParseKey(q.Arguments, "info_hash", "string"); err != nil {
-			send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, err.Error()))
+			sendError(dht, addr, q.TransactionID, NewProtocolError(err.Error()))
 			return
 		}
 
 		infoHash := q.Arguments["info_hash"].(string)
 
 		if len(infoHash) != 20 {
-			send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, "invalid info_hash"))
+			sendError(dht, addr, q.TransactionID, NewProtocolError("invalid info_hash"))
 			return
 		}
 
-		if dht.IsCrawlMode() {
+		// A blocked infohash is answered with nodes only, and isn't
+		// reported.
+		blocked := dht.isBlocked(infoHash)
+
+		if dht.IsCrawlMode() && !dht.CrawlRespondNodes {
 			send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
 				"id":    dht.id(infoHash),
 				"token": dht.tokenManager.token(addr),
 				"nodes": "",
 			}))
-		} else if peers := dht.peersManager.GetPeers(
-			infoHash, dht.K); len(peers) > 0 {
-
-			values := make([]interface{}, len(peers))
-			for i, p := range peers {
-				values[i] = p.CompactIPPortInfo()
-			}
-
-			send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
-				"id":     dht.id(infoHash),
-				"values": values,
-				"token":  dht.tokenManager.token(addr),
-			}))
 		} else {
-			send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
+			r := map[string]interface{}{
 				"id":    dht.id(infoHash),
 				"token": dht.tokenManager.token(addr),
-				"nodes": strings.Join(dht.routingTable.GetNeighborCompactInfos(
-					newBitmapFromString(infoHash), dht.K), ""),
-			}))
+			}
+
+			if peers := dht.peersManager.GetPeers(
+				infoHash, dht.GetPeersValueLimit); len(peers) > 0 && !blocked {
+
+				values := make([]interface{}, len(peers))
+				for i, p := range peers {
+					values[i] = p.CompactIPPortInfo()
+				}
+				r["values"] = values
+			} else {
+				n4, n6 := parseWant(q.Arguments, addr)
+				putNodes(dht, r, newBitmapFromString(infoHash), n4, n6)
+			}
+
+			if scrape, _ := q.Arguments["scrape"].(int); scrape == 1 &&
+				dht.ScrapeFilters && dht.IsStandardMode() && !blocked {
+
+				seeds, peers := dht.peersManager.ScrapeFilters(infoHash)
+				r["BFsd"], r["BFpe"] = string(seeds[:]), string(peers[:])
+			}
+
+			send(dht, addr, NewDHTQueryResponse(q.TransactionID, r))
 		}
 
-		if dht.OnGetPeers != nil {
-			dht.OnGetPeers(infoHash, addr.IP.String(), addr.Port)
+		if !blocked {
+			dht.firstSeen.add(infoHash, dht.Clock.Now())
+			dht.onGetPeers(infoHash, addr.IP, addr.Port)
 		}
 	case DHTQueryTypeAnnouncePeer:
 		if err := ParseKeys(q.Arguments, [][]string{
@@ -275,7 +396,7 @@ func handleRequest(dht *DHT, addr *net.UDPAddr, payload map[string]interface{})
 			{"token", "string"},
 		}); err != nil {
 
-			send(dht, addr, NewDHTErrorResponse(q.TransactionID, protocolError, err.Error()))
+			sendError(dht, addr, q.TransactionID, NewProtocolError(err.Error()))
 			return
 		}
 
@@ -283,56 +404,124 @@ func handleRequest(dht *DHT, addr *net.UDPAddr, payload map[string]interface{})
 		port := q.Arguments["port"].(int)
 		token := q.Arguments["token"].(string)
 
+		if len(infoHash) != 20 {
+			sendError(dht, addr, q.TransactionID, NewProtocolError("invalid info_hash"))
+			return
+		}
+
 		if !dht.tokenManager.check(addr, token) {
-			//			send(dht, addr, makeError(t, protocolError, "invalid token"))
+			reportError(dht, addr, NewProtocolError("invalid token"))
 			return
 		}
 
-		if impliedPort, ok := q.Arguments["implied_port"]; ok &&
-			impliedPort.(int) != 0 {
+		// the source port of the packet is used with a non-zero
+		// implied_port, e.g. behind a NAT, and the port is ignored.
+		if impliedPort, ok := q.Arguments["implied_port"].(int); ok &&
+			impliedPort != 0 {
 
 			port = addr.Port
 		}
 
+		if port < 1 || port > 65535 {
+			sendError(dht, addr, q.TransactionID, NewProtocolError("invalid port"))
+			return
+		}
+
+		// A blocked infohash is acknowledged, but neither stored nor
+		// reported.
+		blocked := dht.isBlocked(infoHash)
+
 		if dht.IsStandardMode() {
-			dht.peersManager.Insert(infoHash, NewPeer(addr.IP, port, token))
+			if !blocked {
+				seed, _ := q.Arguments["seed"].(int)
+				dht.peersManager.Insert(infoHash, newAnnouncedPeer(
+					addr.IP, port, token, dht.Clock.Now(), seed == 1))
+			}
 
 			send(dht, addr, NewDHTQueryResponse(q.TransactionID, map[string]interface{}{
 				"id": dht.id(id),
 			}))
 		}
 
-		if dht.OnAnnouncePeer != nil {
-			dht.OnAnnouncePeer(infoHash, addr.IP.String(), port)
+		if blocked {
+			break
 		}
+
+		dht.firstSeen.add(infoHash, dht.Clock.Now())
+		dht.observeSwarm(infoHash, newPeerAt(addr.IP, port, "", dht.Clock.Now()))
+		dht.onAnnouncePeer(infoHash, addr.IP, port)
+		dht.announceStream.emit(infoHash, addr.IP, port)
+
+		dht.torrentPipeline.request(infoHash, addr.IP.String(), port)
 	default:
-		//		send(dht, addr, makeError(t, protocolError, "invalid q"))
+		reportError(dht, addr, NewMethodUnknownError("invalid q"))
 		return
 	}
 
-	no := NewNode(id, addr)
+	no := newNodeAt(id, addr, dht.Clock.Now())
 	dht.routingTable.Insert(no)
 	return true
 }
 
-// findOn puts nodes in the response to the routingTable, then if target is in
-// the nodes or all nodes are in the routingTable, it stops. Otherwise it
-// continues to findNode or getPeers.
-func findOn(dht *DHT, r map[string]interface{}, target *bitmap, queryType DHTQueryType) error {
-	if err := ParseKey(r, "nodes", "string"); err != nil {
-		return err
+// nodesFamilies are the keys of the compact node infos in a response and the
+// length of each info.
+var nodesFamilies = []struct {
+	key  string
+	size int
+}{
+	{"nodes", 26},
+	{"nodes6", 38},
+}
+
+// parseNodes returns the nodes in the nodes and nodes6 of the response r.
+// At least one of them is required. The nodes whose family isn't supported
+// by dht.Network are skipped. Some buggy clients append stray bytes, so a
+// partial trailing entry is ignored instead of failing the whole list.
+func parseNodes(dht *DHT, r map[string]interface{}) ([]Node, error) {
+	nodes, ok := make([]Node, 0, 8), false
+
+	for _, family := range nodesFamilies {
+		if ParseKey(r, family.key, "string") != nil {
+			continue
+		}
+		ok = true
+
+		infos := r[family.key].(string)
+		if len(infos)%family.size != 0 {
+			dht.logger.Debug("nodes truncated", zap.String("key", family.key),
+				zap.Int("length", len(infos)))
+		}
+
+		for i := 0; i < len(infos)/family.size; i++ {
+			no, err := NewNodeFromCompactInfo(
+				infos[i*family.size:(i+1)*family.size], dht.Network)
+			if err == nil {
+				nodes = append(nodes, activeAt(no, dht.Clock.Now()))
+			}
+		}
 	}
 
-	nodes := r["nodes"].(string)
-	if len(nodes)%26 != 0 {
-		return errors.New("the length of nodes should can be divided by 26")
+	if !ok {
+		return nil, errors.New("lack of nodes")
 	}
+	return nodes, nil
+}
 
-	hasNew, found := false, false
-	for i := 0; i < len(nodes)/26; i++ {
-		no, _ := NewNodeFromCompactInfo(
-			string(nodes[i*26:(i+1)*26]), dht.Network)
+// findOn puts nodes in the response to the routingTable, then if target is in
+// the nodes or all nodes are in the routingTable, it stops. Otherwise it
+// continues to findNode or getPeers on the neighbors the lookup of target
+// doesn't query yet, until the lookup runs lookupMaxRounds rounds. It
+// returns whether target is in the nodes.
+func findOn(dht *DHT, r map[string]interface{}, target *bitmap,
+	queryType DHTQueryType) (found bool, err error) {
 
+	nodes, err := parseNodes(dht, r)
+	if err != nil {
+		return
+	}
+
+	hasNew := false
+	for _, no := range nodes {
 		if no.IDRawString() == target.RawString() {
 			found = true
 		}
@@ -343,11 +532,13 @@ func findOn(dht *DHT, r map[string]interface{}, target *bitmap, queryType DHTQue
 	}
 
 	if found || !hasNew {
-		return nil
+		return
 	}
 
 	targetID := target.RawString()
-	for _, no := range dht.routingTable.GetNeighbors(target, dht.K) {
+	for _, no := range dht.lookups.next(queryType, targetID,
+		dht.routingTable.GetNeighbors(target, dht.K)) {
+
 		switch queryType {
 		case DHTQueryTypeFindNode:
 			dht.transactionManager.findNode(no, targetID)
@@ -357,6 +548,71 @@ func findOn(dht *DHT, r map[string]interface{}, target *bitmap, queryType DHTQue
 			panic("invalid find type")
 		}
 	}
+	return
+}
+
+// responseShape represents the keys a response to a query type must or
+// mustn't have. It must have at least one of the keys in anyOf if it's set.
+type responseShape struct {
+	required  []string
+	anyOf     []string
+	forbidden []string
+}
+
+// responseShapes is the responseShape of each query type.
+var responseShapes = map[DHTQueryType]responseShape{
+	DHTQueryTypePing: {
+		forbidden: []string{"nodes", "nodes6", "values", "token", "samples"},
+	},
+	DHTQueryTypeFindNode: {
+		anyOf:     []string{"nodes", "nodes6"},
+		forbidden: []string{"values", "token", "samples"},
+	},
+	DHTQueryTypeGetPeers: {
+		required:  []string{"token"},
+		forbidden: []string{"samples"},
+	},
+	DHTQueryTypeAnnouncePeer: {
+		forbidden: []string{"nodes", "nodes6", "values", "token", "samples"},
+	},
+	DHTQueryTypeSampleInfohashes: {
+		required:  []string{"samples"},
+		forbidden: []string{"values", "token"},
+	},
+}
+
+// validateResponse returns an error if the response r isn't consistent with
+// the query type, e.g. a ping is answered with a get_peers response.
+func validateResponse(queryType DHTQueryType, r map[string]interface{}) error {
+	shape, ok := responseShapes[queryType]
+	if !ok {
+		return errors.New("invalid query type")
+	}
+
+	for _, key := range shape.required {
+		if _, ok := r[key]; !ok {
+			return errors.New("lack of key")
+		}
+	}
+
+	if len(shape.anyOf) > 0 {
+		found := false
+		for _, key := range shape.anyOf {
+			if _, ok := r[key]; ok {
+				found = true
+			}
+		}
+
+		if !found {
+			return errors.New("lack of key")
+		}
+	}
+
+	for _, key := range shape.forbidden {
+		if _, ok := r[key]; ok {
+			return errors.New("unexpected key " + key)
+		}
+	}
 	return nil
 }
 
@@ -390,19 +646,22 @@ func handleResponse(dht *DHT, addr *net.UDPAddr, response map[string]interface{}
 		return
 	}
 
-	node := NewNode(id, addr)
+	// If the response doesn't match the query, drop it.
+	if validateResponse(trans.Data.QueryType, r) != nil {
+		return
+	}
+
+	node := newNodeAt(id, addr, dht.Clock.Now())
 
 	switch trans.Data.QueryType {
 	case DHTQueryTypePing:
 	case DHTQueryTypeFindNode:
-		if trans.Data.QueryType != DHTQueryTypeFindNode {
-			return
-		}
-
 		target := trans.Data.Arguments["target"].(string)
-		if findOn(dht, r, newBitmapFromString(target), DHTQueryTypeFindNode) != nil {
+		found, err := findOn(dht, r, newBitmapFromString(target), DHTQueryTypeFindNode)
+		if err != nil {
 			return
 		}
+		trans.found = found
 	case DHTQueryTypeGetPeers:
 		if err := ParseKey(r, "token", "string"); err != nil {
 			return
@@ -410,23 +669,45 @@ func handleResponse(dht *DHT, addr *net.UDPAddr, response map[string]interface{}
 
 		token := r["token"].(string)
 		infoHash := trans.Data.Arguments["info_hash"].(string)
+		trans.token = token
+
+		if !dht.isBlocked(infoHash) {
+			dht.onScrape(infoHash, r)
+		}
 
 		if err := ParseKey(r, "values", "list"); err == nil {
+			if dht.isBlocked(infoHash) {
+				break
+			}
+
+			// A swarm may mix IPv4 and IPv6 peers in values, an entry
+			// of another length or type is skipped.
 			values := r["values"].([]interface{})
 			for _, v := range values {
-				p, err := NewPeerFromCompactIPPortInfo(v.(string), token)
+				info, ok := v.(string)
+				if !ok {
+					continue
+				}
+
+				p, err := newPeerFromCompactIPPortInfoAt(info, token, dht.Clock.Now())
 				if err != nil {
 					continue
 				}
 				dht.peersManager.Insert(infoHash, p)
+				dht.observeSwarm(infoHash, p)
 				if dht.OnGetPeersResponse != nil {
-					dht.OnGetPeersResponse(infoHash, p)
+					dht.OnGetPeersResponse(rawInfoHash(infoHash), p)
 				}
 			}
-		} else if findOn(dht, r, newBitmapFromString(infoHash), DHTQueryTypeGetPeers) != nil {
+		} else if _, err := findOn(dht, r, newBitmapFromString(infoHash),
+			DHTQueryTypeGetPeers); err != nil {
 			return
 		}
 	case DHTQueryTypeAnnouncePeer:
+	case DHTQueryTypeSampleInfohashes:
+		if handleSamples(dht, addr, r) != nil {
+			return
+		}
 	default:
 		return
 	}
@@ -448,13 +729,18 @@ func handleError(dht *DHT, addr *net.UDPAddr,
 		return
 	}
 
-	if e := response["e"].([]interface{}); len(e) != 2 {
+	e := response["e"].([]interface{})
+	if len(e) != 2 {
 		return
 	}
 
 	if trans := dht.transactionManager.filterOne(
 		response["t"].(string), addr); trans != nil {
 
+		code, _ := e[0].(int)
+		message, _ := e[1].(string)
+		trans.err = NewKRPCError(code, message)
+
 		trans.Response <- struct{}{}
 	}
 
@@ -467,20 +753,35 @@ var handlers = map[string]func(*DHT, *net.UDPAddr, map[string]interface{}) bool{
 	"e": handleError,
 }
 
-// handle handles packets received from udp.
+// handle handles packets received from udp. If the dht is paused or all the
+// packet workers are busy, the packet is dropped. The buffer of the packet
+// is put back to the pool once it's handled or dropped, and the decoded
+// message never refers to it.
 func handle(dht *DHT, pkt packet) {
-	if len(dht.workerTokens) == dht.PacketWorkerLimit {
+	if dht.Paused() {
+		dht.buffers.put(pkt.buff)
 		return
 	}
 
-	dht.workerTokens <- struct{}{}
+	if !dht.packetWorkers.acquire() {
+		dht.buffers.put(pkt.buff)
+		atomic.AddUint64(&dht.droppedPackets, 1)
+		dht.logger.Debug("packet dropped", zap.Stringer("from", pkt.raddr))
+
+		if dht.OnPacketDropped != nil {
+			dht.OnPacketDropped(pkt.raddr)
+		}
+		return
+	}
 
 	go func() {
 		defer func() {
-			<-dht.workerTokens
+			dht.buffers.put(pkt.buff)
+			dht.packetWorkers.release()
 		}()
 
-		if dht.blackList.in(pkt.raddr.IP.String(), pkt.raddr.Port) {
+		if dht.blackList.in(pkt.raddr.IP.String(), pkt.raddr.Port) ||
+			dht.rejects(pkt.raddr.IP) {
 			return
 		}
 
@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+)
+
+// The standard KRPC error codes. See http://www.bittorrent.org/beps/bep_0005.html.
+const (
+	// GenericErrorCode represents a generic error.
+	GenericErrorCode = 201 + iota
+	// ServerErrorCode represents a server error.
+	ServerErrorCode
+	// ProtocolErrorCode represents a protocol error, such as a malformed
+	// packet, invalid arguments, or bad token.
+	ProtocolErrorCode
+	// MethodUnknownErrorCode represents an unknown query method.
+	MethodUnknownErrorCode
+)
+
+// KRPCError represents the error of a KRPC message.
+type KRPCError struct {
+	Code    int
+	Message string
+}
+
+// NewKRPCError returns a KRPCError pointer.
+func NewKRPCError(code int, message string) *KRPCError {
+	return &KRPCError{Code: code, Message: message}
+}
+
+// NewGenericError returns a KRPCError whose code is GenericErrorCode.
+func NewGenericError(message string) *KRPCError {
+	return NewKRPCError(GenericErrorCode, message)
+}
+
+// NewServerError returns a KRPCError whose code is ServerErrorCode.
+func NewServerError(message string) *KRPCError {
+	return NewKRPCError(ServerErrorCode, message)
+}
+
+// NewProtocolError returns a KRPCError whose code is ProtocolErrorCode.
+func NewProtocolError(message string) *KRPCError {
+	return NewKRPCError(ProtocolErrorCode, message)
+}
+
+// NewMethodUnknownError returns a KRPCError whose code is
+// MethodUnknownErrorCode.
+func NewMethodUnknownError(message string) *KRPCError {
+	return NewKRPCError(MethodUnknownErrorCode, message)
+}
+
+// Error implements the error interface.
+func (e *KRPCError) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", e.Code, e.Message)
+}
+
+// isTokenRejection returns whether err may reject the token of an
+// announce_peer. A bad token has no code of its own but ProtocolErrorCode,
+// and the messages differ between the implementations, so every protocol
+// error is taken as a rejected token.
+func isTokenRejection(err *KRPCError) bool {
+	return err != nil && err.Code == ProtocolErrorCode
+}
+
+// reportError calls OnProtocolError if it's set.
+func reportError(dht *DHT, addr *net.UDPAddr, err *KRPCError) {
+	if dht.OnProtocolError != nil {
+		dht.OnProtocolError(addr, err)
+	}
+}
+
+// sendError reports err and sends it to addr as an error response.
+func sendError(dht *DHT, addr *net.UDPAddr, transID string, err *KRPCError) {
+	reportError(dht, addr, err)
+	send(dht, addr, NewDHTErrorResponse(transID, err.Code, err.Message))
+}
@@ -0,0 +1,1081 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestHandleDropsPacket(t *testing.T) {
+	config := NewStandardConfig()
+	config.PacketWorkerLimit = 1
+
+	var dropped *net.UDPAddr
+	config.OnPacketDropped = func(from *net.UDPAddr) {
+		dropped = from
+	}
+
+	dht := &DHT{
+		Config:        config,
+		logger:        zap.NewNop(),
+		packetWorkers: newPacketWorkers(0, config.PacketWorkerLimit),
+		buffers:       newBufferPool(config.ReadBufferSize),
+	}
+	dht.packetWorkers.acquire()
+
+	raddr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	handle(dht, packet{data: []byte("de"), raddr: raddr})
+
+	if dht.DroppedPackets() != 1 || dropped != raddr {
+		t.Fail()
+	}
+}
+
+func TestOnAnnouncePeerCallbacks(t *testing.T) {
+	config := NewStandardConfig()
+	dht := &DHT{Config: config}
+	hash := strings.Repeat("h", 20)
+
+	var legacy, typed bool
+	config.OnAnnouncePeer = func(infoHash, ip string, port int) {
+		legacy = infoHash == hash && ip == "1.1.1.1" && port == 6881
+	}
+	config.OnAnnouncePeerIP = func(infoHash InfoHash, ip net.IP, port int) {
+		typed = infoHash.Raw() == hash && ip.Equal(net.IPv4(1, 1, 1, 1)) &&
+			port == 6881
+	}
+
+	dht.onAnnouncePeer(hash, net.IPv4(1, 1, 1, 1), 6881)
+	if !legacy || !typed {
+		t.Fail()
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	responses := map[DHTQueryType]map[string]interface{}{
+		DHTQueryTypePing:         {"id": "id"},
+		DHTQueryTypeFindNode:     {"id": "id", "nodes": ""},
+		DHTQueryTypeGetPeers:     {"id": "id", "token": "tk", "values": []interface{}{}},
+		DHTQueryTypeAnnouncePeer: {"id": "id"},
+		DHTQueryTypeSampleInfohashes: {
+			"id": "id", "samples": "", "interval": 60, "nodes": "",
+		},
+	}
+
+	// ping and announce_peer responses have the same shape.
+	compatible := map[DHTQueryType]DHTQueryType{
+		DHTQueryTypePing:         DHTQueryTypeAnnouncePeer,
+		DHTQueryTypeAnnouncePeer: DHTQueryTypePing,
+	}
+
+	for queryType := range responses {
+		for responseType, r := range responses {
+			err := validateResponse(queryType, r)
+			ok := queryType == responseType || compatible[queryType] == responseType
+
+			if (err == nil) != ok {
+				t.Errorf("%s response to %s query: unexpected error %v",
+					responseType, queryType, err)
+			}
+		}
+	}
+
+	if validateResponse(DHTQueryTypeGetPeers, map[string]interface{}{
+		"id": "id", "token": "tk", "nodes": "",
+	}) != nil {
+		t.Fail()
+	}
+}
+
+func TestParseWant(t *testing.T) {
+	addr4 := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	addr6 := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 6881}
+
+	cases := []struct {
+		a      map[string]interface{}
+		addr   *net.UDPAddr
+		n4, n6 bool
+	}{
+		{map[string]interface{}{}, addr4, true, false},
+		{map[string]interface{}{}, addr6, false, true},
+		{map[string]interface{}{"want": []interface{}{"n6"}}, addr4, false, true},
+		{map[string]interface{}{"want": []interface{}{"n4", "n6"}}, addr4, true, true},
+		{map[string]interface{}{"want": []interface{}{"n5", 1}}, addr4, true, false},
+		{map[string]interface{}{"want": "n6"}, addr4, true, false},
+	}
+
+	for _, c := range cases {
+		if n4, n6 := parseWant(c.a, c.addr); n4 != c.n4 || n6 != c.n6 {
+			t.Errorf("parseWant(%v) = %v, %v", c.a, n4, n6)
+		}
+	}
+}
+
+func TestIsTokenRejection(t *testing.T) {
+	cases := []struct {
+		err *KRPCError
+		out bool
+	}{
+		{nil, false},
+		{NewProtocolError("invalid token"), true},
+		{NewProtocolError("Bad Token"), true},
+		{NewProtocolError("invalid id"), true},
+		{NewGenericError("invalid token"), false},
+	}
+
+	for _, c := range cases {
+		if isTokenRejection(c.err) != c.out {
+			t.Errorf("isTokenRejection(%v) != %v", c.err, c.out)
+		}
+	}
+}
+
+// newHandlerDHT returns a dht which can handle packets without running.
+func newHandlerDHT(config *Config) *DHT {
+	dht := &DHT{
+		Config:          config,
+		logger:          zap.NewNop(),
+		node:            NewNode(rawInfoHash(randomString(20)), nil),
+		blackList:       newBlackList(256, realClock{}),
+		torrentPipeline: newTorrentPipeline(zap.NewNop(), config),
+		firstSeen:       newSeenFilter(config.SeenMaxSize),
+		announceStream:  newAnnounceStream(config),
+	}
+	dht.routingTable = newRoutingTable(config.KBucketSize, dht)
+	dht.peersManager = newPeersManager(dht)
+	dht.tokenManager = newTokenManager(config.TokenExpiredAfter, dht)
+	dht.transactionManager = newTransactionManager(config.transactionIDs(), dht)
+	dht.lookups = newLookupTracker(config.MaxConcurrentLookups, config.Clock)
+
+	return dht
+}
+
+func TestPingResponseID(t *testing.T) {
+	requester := strings.Repeat("r", 20)
+
+	cases := []struct {
+		config   *Config
+		strategy IDStrategy
+	}{
+		{NewStandardConfig(), DefaultIDStrategy},
+		{NewCrawlConfig(), DefaultIDStrategy},
+		{NewCrawlConfig(), PrefixMirror(4)},
+		{NewCrawlConfig(), FullMirror},
+	}
+
+	for _, c := range cases {
+		config := c.config
+		config.IDStrategy = c.strategy
+
+		dht := newHandlerDHT(config)
+		transport := &mockTransport{}
+		dht.transport = transport
+
+		handleRequest(dht, &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881},
+			NewDHTQuery("aa", DHTQueryTypePing,
+				map[string]interface{}{"id": requester}).ToPayload())
+
+		if len(transport.written) != 1 {
+			t.Fatalf("strategy %d: %d responses", config.IDStrategy, len(transport.written))
+		}
+		response, _ := Decode([]byte(transport.written[0]))
+		id := response.(map[string]interface{})["r"].(map[string]interface{})["id"].(string)
+
+		if id != dht.node.IDRawString() {
+			t.Errorf("strategy %d: id %x", config.IDStrategy, id)
+		}
+	}
+}
+
+func TestPassiveOnly(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	var announced bool
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		announced = true
+	}
+
+	dht := newHandlerDHT(config)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+
+	id := randomString(20)
+
+	// dht.transport is nil, so it panics if anything is sent.
+	for _, q := range []DHTQueryType{DHTQueryTypePing, DHTQueryTypeGetPeers} {
+		payload := NewDHTQuery("aa", q, map[string]interface{}{
+			"id":        id,
+			"info_hash": randomString(20),
+		}).ToPayload()
+
+		if !handleRequest(dht, addr, payload) {
+			t.Errorf("%s not handled", q)
+		}
+	}
+
+	if !announced || dht.routingTable.Len() != 1 {
+		t.Fail()
+	}
+
+	if dht.transactionManager.ping(NewTempNode(addr)) != nil {
+		t.Error("query sent in passive mode")
+	}
+}
+
+func TestOnGetPeersCount(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	counts := make([]int, 0, 2)
+	config.OnGetPeersCount = func(infoHash InfoHash, ip net.IP, port int, peers int) {
+		counts = append(counts, peers)
+	}
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	infoHash := randomString(20)
+
+	payload := NewDHTQuery("aa", DHTQueryTypeGetPeers, map[string]interface{}{
+		"id":        randomString(20),
+		"info_hash": infoHash,
+	}).ToPayload()
+
+	handleRequest(dht, addr, payload)
+
+	dht.peersManager.Insert(infoHash, NewPeer(net.IPv4(2, 2, 2, 2), 6881, ""))
+	dht.peersManager.Insert(infoHash, NewPeer(net.IPv4(3, 3, 3, 3), 6881, ""))
+	handleRequest(dht, addr, payload)
+
+	if len(counts) != 2 || counts[0] != 0 || counts[1] != 2 {
+		t.Errorf("peer counts %v", counts)
+	}
+}
+
+func TestParseNodes(t *testing.T) {
+	node4 := NewNode(rawInfoHash(randomString(20)), &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881})
+	node6 := NewNode(rawInfoHash(randomString(20)), &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6881})
+
+	cases := []struct {
+		network string
+		r       map[string]interface{}
+		out     int
+		ok      bool
+	}{
+		{"udp", map[string]interface{}{
+			"nodes": node4.CompactNodeInfo(), "nodes6": node6.CompactNodeInfo(),
+		}, 2, true},
+		{"udp4", map[string]interface{}{
+			"nodes": node4.CompactNodeInfo(), "nodes6": node6.CompactNodeInfo(),
+		}, 1, true},
+		{"udp6", map[string]interface{}{"nodes6": node6.CompactNodeInfo()}, 1, true},
+		{"udp6", map[string]interface{}{"nodes6": node4.CompactNodeInfo()}, 0, true},
+		{"udp4", map[string]interface{}{
+			"nodes": node4.CompactNodeInfo() + node4.CompactNodeInfo() + "x",
+		}, 2, true},
+		{"udp", map[string]interface{}{"values": []interface{}{}}, 0, false},
+	}
+
+	for i, c := range cases {
+		config := NewStandardConfig()
+		config.Network = c.network
+
+		nodes, err := parseNodes(&DHT{Config: config, logger: zap.NewNop()}, c.r)
+		if (err == nil) != c.ok || len(nodes) != c.out {
+			t.Errorf("case %d: %d nodes, error %v", i, len(nodes), err)
+		}
+	}
+
+	if validateResponse(DHTQueryTypeFindNode, map[string]interface{}{
+		"id": "id", "nodes6": node6.CompactNodeInfo(),
+	}) != nil {
+		t.Error("find_node response with only nodes6 rejected")
+	}
+}
+
+func TestAnnounceRetryWithSameToken(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	announces := 0
+	config.OnAnnouncePeerIP = func(infoHash InfoHash, ip net.IP, port int) {
+		announces++
+	}
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	tk := dht.tokenManager.token(addr)
+
+	announce := func(tk string) bool {
+		return handleRequest(dht, addr, NewDHTQuery("aa", DHTQueryTypeAnnouncePeer,
+			map[string]interface{}{
+				"id":        string(make([]byte, 20)),
+				"info_hash": randomString(20),
+				"port":      6881,
+				"token":     tk,
+			}).ToPayload())
+	}
+
+	cases := []struct {
+		token string
+		ok    bool
+	}{
+		// a wrong token doesn't invalidate the right one.
+		{"wrong", false},
+		// the response of the first announce is dropped, so the peer
+		// retries with the same token.
+		{tk, true},
+		{tk, true},
+	}
+
+	for i, c := range cases {
+		if announce(c.token) != c.ok {
+			t.Errorf("announce %d: ok should be %v", i, c.ok)
+		}
+	}
+
+	if announces != 2 {
+		t.Error(announces)
+	}
+
+	dht.tokenManager.expiredAfter = 0
+	if dht.tokenManager.check(addr, tk) {
+		t.Error("expired token accepted")
+	}
+}
+
+func TestAnnouncePeerPort(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	var announced int
+	config.OnAnnouncePeerIP = func(infoHash InfoHash, ip net.IP, port int) {
+		announced = port
+	}
+
+	var protocolErr *KRPCError
+	config.OnProtocolError = func(addr *net.UDPAddr, err *KRPCError) {
+		protocolErr = err
+	}
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	id := randomString(20)
+
+	cases := []struct {
+		port        int
+		impliedPort interface{}
+		announced   int
+	}{
+		{0, nil, 0},
+		{-1, nil, 0},
+		{65536, nil, 0},
+		{1 << 40, nil, 0},
+		{1, nil, 1},
+		{65535, nil, 65535},
+		{0, 0, 0},
+		// the invalid port is ignored with implied_port.
+		{0, 1, addr.Port},
+		{65536, 1, addr.Port},
+		// a malformed implied_port is ignored.
+		{6882, "1", 6882},
+	}
+
+	for _, c := range cases {
+		announced, protocolErr = 0, nil
+		infoHash := randomString(20)
+
+		a := map[string]interface{}{
+			"id":        id,
+			"info_hash": infoHash,
+			"port":      c.port,
+			"token":     dht.tokenManager.token(addr),
+		}
+		if c.impliedPort != nil {
+			a["implied_port"] = c.impliedPort
+		}
+
+		ok := handleRequest(dht, addr, NewDHTQuery("aa", DHTQueryTypeAnnouncePeer, a).ToPayload())
+		stored := dht.peersManager.GetPeers(infoHash, 8)
+
+		if c.announced == 0 {
+			if ok || protocolErr == nil || len(stored) != 0 || announced != 0 {
+				t.Errorf("port %d, implied_port %v accepted", c.port, c.impliedPort)
+			}
+			continue
+		}
+
+		if !ok || announced != c.announced || len(stored) != 1 ||
+			stored[0].Port() != c.announced {
+			t.Errorf("port %d, implied_port %v: announced %d", c.port,
+				c.impliedPort, announced)
+		}
+	}
+
+	// an info_hash of another length is refused.
+	announced, protocolErr = 0, nil
+	ok := handleRequest(dht, addr, NewDHTQuery("aa", DHTQueryTypeAnnouncePeer,
+		map[string]interface{}{
+			"id":        id,
+			"info_hash": "hash",
+			"port":      6881,
+			"token":     dht.tokenManager.token(addr),
+		}).ToPayload())
+	if ok || announced != 0 {
+		t.Error("short info_hash announced")
+	}
+}
+
+func TestCrawlRespondNodes(t *testing.T) {
+	cases := []struct {
+		respond bool
+		nodes   int
+	}{
+		{false, 0},
+		{true, 8},
+	}
+
+	for _, c := range cases {
+		config := NewCrawlConfig()
+		config.Network = "udp4"
+		config.CrawlRespondNodes = c.respond
+
+		dht := newHandlerDHT(config)
+		for i := 0; i < 16; i++ {
+			dht.routingTable.Insert(NewNode(rawInfoHash(randomString(20)), &net.UDPAddr{
+				IP: net.IPv4(1, 1, 1, byte(i+1)), Port: 6881}))
+		}
+
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dht.transport = NewUDPTransport(conn)
+
+		peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := NewDHTQuery("aa", DHTQueryTypeGetPeers, map[string]interface{}{
+			"id":        randomString(20),
+			"info_hash": randomString(20),
+		}).ToPayload()
+
+		if !handleRequest(dht, peer.LocalAddr().(*net.UDPAddr), payload) {
+			t.Fatal("get_peers not handled")
+		}
+
+		buff := make([]byte, 1024)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := peer.ReadFromUDP(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := Decode(buff[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := response.(map[string]interface{})["r"].(map[string]interface{})
+		if nodes := r["nodes"].(string); len(nodes) != c.nodes*26 {
+			t.Errorf("respond %v: %d bytes of nodes", c.respond, len(nodes))
+		}
+
+		conn.Close()
+		peer.Close()
+	}
+}
+
+func TestGetPeersResponseMixedValues(t *testing.T) {
+	config := NewStandardConfig()
+
+	var peers []Peer
+	config.OnGetPeersResponse = func(infoHash InfoHash, p Peer) {
+		peers = append(peers, p)
+	}
+
+	dht := newHandlerDHT(config)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+
+	id, infoHash := randomString(20), randomString(20)
+	tm := dht.transactionManager
+	tm.insert(tm.newTransaction("aa", &Query{
+		Node: NewNode(rawInfoHash(id), addr),
+		Data: NewDHTQuery("aa", DHTQueryTypeGetPeers, map[string]interface{}{
+			"id":        dht.node.IDRawString(),
+			"info_hash": infoHash,
+		}),
+	}))
+
+	v4, _ := encodeCompactIPPortInfo(net.IPv4(2, 2, 2, 2), 6881)
+	v6, _ := encodeCompactIPPortInfo(net.ParseIP("2001:db8::1"), 6882)
+
+	handleResponse(dht, addr, map[string]interface{}{
+		"t": "aa",
+		"y": "r",
+		"r": map[string]interface{}{
+			"id":     id,
+			"token":  "tk",
+			"values": []interface{}{v4, "short", v6, 1},
+		},
+	})
+
+	if len(peers) != 2 ||
+		!peers[0].IP().Equal(net.IPv4(2, 2, 2, 2)) || peers[0].Port() != 6881 ||
+		!peers[1].IP().Equal(net.ParseIP("2001:db8::1")) || peers[1].Port() != 6882 {
+
+		t.Errorf("unexpected peers %v", peers)
+	}
+
+	if stored := dht.peersManager.GetPeers(infoHash, 8); len(stored) != 2 {
+		t.Errorf("%d peers stored", len(stored))
+	}
+}
+
+func TestInjectPacket(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	got := make(chan string, 2)
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		got <- ip.String()
+	}
+
+	dht := newHandlerDHT(config)
+	dht.buffers = newBufferPool(config.ReadBufferSize)
+	dht.packets = make(chan packet, 1)
+	dht.packetWorkers = newPacketWorkers(0, config.PacketWorkerLimit)
+	dht.done = make(chan struct{})
+
+	data := []byte(Encode(NewDHTQuery("aa", DHTQueryTypeGetPeers,
+		map[string]interface{}{
+			"id":        randomString(20),
+			"info_hash": randomString(20),
+		}).ToPayload()))
+
+	blocked := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	allowed := &net.UDPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 6881}
+	dht.blackList.insert(blocked.IP.String(), blocked.Port)
+
+	if dht.InjectPacket(data, allowed) != ErrNotReady {
+		t.Error("packet injected before ready")
+	}
+	dht.Ready = true
+
+	for _, from := range []*net.UDPAddr{blocked, allowed} {
+		if err := dht.InjectPacket(data, from); err != nil {
+			t.Fatal(err)
+		}
+		handle(dht, <-dht.packets)
+	}
+
+	select {
+	case ip := <-got:
+		if ip != allowed.IP.String() {
+			t.Errorf("packet from %s handled", ip)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("injected packet not handled")
+	}
+
+	large := make([]byte, config.ReadBufferSize+1)
+	if dht.InjectPacket(large, allowed) != ErrPacketTooLarge {
+		t.Error("oversized packet injected")
+	}
+
+	// the queue is full, so the packet can only be refused.
+	dht.packets <- packet{}
+	close(dht.done)
+	if dht.InjectPacket(data, allowed) != ErrClosed {
+		t.Error("packet injected after close")
+	}
+}
+
+func TestPauseBeforeRun(t *testing.T) {
+	dht := New(nil, NewStandardConfig())
+
+	dht.Pause()
+	if !dht.Paused() {
+		t.Error("not paused")
+	}
+
+	dht.Resume()
+	if dht.Paused() {
+		t.Error("not resumed")
+	}
+}
+
+func TestPause(t *testing.T) {
+	config := NewStandardConfig()
+
+	got := make(chan struct{}, 2)
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		got <- struct{}{}
+	}
+
+	dht := newHandlerDHT(config)
+	dht.buffers = newBufferPool(config.ReadBufferSize)
+	dht.packetWorkers = newPacketWorkers(0, config.PacketWorkerLimit)
+
+	no := NewTempNode(&net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881})
+	trans := dht.transactionManager.newTransaction("aa", &Query{
+		Node: no,
+		Data: NewDHTQuery("aa", DHTQueryTypePing, nil),
+	})
+	dht.transactionManager.insert(trans)
+
+	dht.Pause()
+	dht.Pause()
+
+	select {
+	case <-trans.cancel:
+	default:
+		t.Error("in-flight transaction not canceled")
+	}
+
+	if !dht.Paused() || dht.transactionManager.len() != 0 ||
+		dht.transactionManager.ping(no) != nil {
+		t.Error("query sent while paused")
+	}
+
+	// dht.transport is nil, so it panics if the get_peers is answered.
+	config.PassiveOnly = true
+	data := []byte(Encode(NewDHTQuery("aa", DHTQueryTypeGetPeers,
+		map[string]interface{}{
+			"id":        randomString(20),
+			"info_hash": randomString(20),
+		}).ToPayload()))
+
+	for _, paused := range []bool{true, false} {
+		if !paused {
+			dht.Resume()
+		}
+
+		buff := dht.buffers.get()
+		n := copy(*buff, data)
+		handle(dht, packet{(*buff)[:n], no.Address(), buff})
+	}
+
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("packet not handled after resume")
+	}
+
+	if len(got) != 0 || dht.Paused() {
+		t.Error("packet handled while paused")
+	}
+}
+
+func TestReset(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+
+	dht := newHandlerDHT(config)
+	dht.primeResolver = newPrimeResolver(zap.NewNop(), config.Network, nil)
+	dht.done = make(chan struct{})
+
+	if dht.Reset(false) != ErrNotReady {
+		t.Error("reset before ready")
+	}
+	dht.Ready = true
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	no := NewNode(rawInfoHash(randomString(20)), addr)
+	infoHash := randomString(20)
+
+	dht.routingTable.Insert(no)
+	dht.peersManager.Insert(infoHash, NewPeer(addr.IP, addr.Port, ""))
+	token := dht.tokenManager.token(addr)
+	dht.blackList.insert("2.2.2.2", 6881)
+
+	trans := dht.transactionManager.newTransaction("aa", &Query{
+		Node: no,
+		Data: NewDHTQuery("aa", DHTQueryTypePing, nil),
+	})
+	dht.transactionManager.insert(trans)
+
+	for _, clearBlackList := range []bool{false, true} {
+		if err := dht.Reset(clearBlackList); err != nil {
+			t.Fatal(err)
+		}
+
+		if dht.routingTable.Len() != 0 || dht.peersManager.Total() != 0 ||
+			len(dht.peersManager.GetPeers(infoHash, 8)) != 0 ||
+			dht.tokenManager.check(addr, token) ||
+			dht.transactionManager.len() != 0 {
+			t.Error("state not cleared")
+		}
+
+		if dht.blackList.in("2.2.2.2", 6881) == clearBlackList {
+			t.Errorf("blacklist kept: %v", !clearBlackList)
+		}
+	}
+
+	select {
+	case <-trans.cancel:
+	default:
+		t.Error("in-flight transaction not canceled")
+	}
+
+	// the cleared table still works.
+	if !dht.routingTable.Insert(no) || dht.routingTable.Len() != 1 {
+		t.Error("insert after reset failed")
+	}
+
+	close(dht.done)
+	if dht.Reset(false) != ErrClosed {
+		t.Error("reset after close")
+	}
+}
+
+func TestMinTableSizeForService(t *testing.T) {
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+	config.MinTableSizeForService = 2
+
+	var served int
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		served++
+	}
+
+	dht := newHandlerDHT(config)
+	dht.Ready = true
+
+	getPeers := func(ip net.IP) bool {
+		return handleRequest(dht, &net.UDPAddr{IP: ip, Port: 6881},
+			NewDHTQuery("aa", DHTQueryTypeGetPeers, map[string]interface{}{
+				"id":        randomString(20),
+				"info_hash": randomString(20),
+			}).ToPayload())
+	}
+
+	// the querying node is kept while bootstrapping, but not served.
+	if getPeers(net.IPv4(1, 1, 1, 1)) || served != 0 || !dht.Bootstrapping() ||
+		dht.routingTable.Len() != 1 {
+		t.Fatal("served while bootstrapping")
+	}
+
+	// the second node fills the table, so the third query is served.
+	if getPeers(net.IPv4(1, 1, 1, 2)) || !getPeers(net.IPv4(1, 1, 1, 3)) ||
+		served != 1 || dht.Bootstrapping() {
+		t.Fatal("not served after bootstrapping")
+	}
+
+	// it stays steady after the table shrinks.
+	dht.routingTable.Clear()
+	if dht.Bootstrapping() || !getPeers(net.IPv4(1, 1, 1, 4)) {
+		t.Error("bootstrapping again")
+	}
+}
+
+// serveQueries answers the queries on conn as the node id, until conn is
+// closed. The find_node queries are answered with the compact node info
+// nodes.
+func serveQueries(conn *net.UDPConn, id, nodes string) {
+	buff := make([]byte, 1024)
+	for {
+		n, raddr, err := conn.ReadFromUDP(buff)
+		if err != nil {
+			return
+		}
+
+		v, err := Decode(buff[:n])
+		if err != nil {
+			continue
+		}
+		query := v.(map[string]interface{})
+
+		r := map[string]interface{}{"id": id}
+		if query["q"] == string(DHTQueryTypeFindNode) {
+			r["nodes"] = nodes
+		}
+
+		response := NewDHTQueryResponse(query["t"].(string), r)
+		conn.WriteToUDP([]byte(Encode(response.ToPayload())), raddr)
+	}
+}
+
+// newUDPDHT returns a ready dht listening on a local udp port, which
+// handles packets and queries without running. Close it after use.
+func newUDPDHT(t *testing.T, config *Config) *DHT {
+	config.Network = "udp4"
+
+	dht := newHandlerDHT(config)
+	dht.buffers = newBufferPool(config.ReadBufferSize)
+	dht.packets = make(chan packet, 16)
+	dht.packetWorkers = newPacketWorkers(0, config.PacketWorkerLimit)
+	dht.primeResolver = newPrimeResolver(zap.NewNop(), config.Network, nil)
+	dht.done = make(chan struct{})
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dht.transport = NewUDPTransport(conn)
+
+	go dht.transactionManager.run()
+	dht.listen()
+	go func() {
+		for {
+			select {
+			case pkt := <-dht.packets:
+				handle(dht, pkt)
+			case <-dht.done:
+				return
+			}
+		}
+	}()
+	dht.Ready = true
+
+	return dht
+}
+
+// newUDPPeer returns a node listening on a local udp port, and its conn.
+// localAddr returns the address of the udp socket of dht.
+func localAddr(dht *DHT) *net.UDPAddr {
+	return dht.transport.(*udpTransport).conn.LocalAddr().(*net.UDPAddr)
+}
+
+func newUDPPeer(t *testing.T) (Node, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNode(rawInfoHash(randomString(20)), conn.LocalAddr().(*net.UDPAddr)), conn
+}
+
+func TestBootstrapRounds(t *testing.T) {
+	dht := newHandlerDHT(NewStandardConfig())
+
+	cases := []struct {
+		rounds int
+		err    error
+	}{
+		{-1, ErrInvalidRounds},
+		{0, ErrInvalidRounds},
+		{1, ErrNotReady},
+	}
+
+	for _, c := range cases {
+		if stats, err := dht.Bootstrap(c.rounds); err != c.err || stats != nil {
+			t.Errorf("Bootstrap(%d) = %v, %v, want %v", c.rounds, stats, err, c.err)
+		}
+	}
+}
+
+func TestSelfLookup(t *testing.T) {
+	dht := newUDPDHT(t, NewStandardConfig())
+	defer dht.Close()
+
+	if _, err := dht.SelfLookup(); err != ErrNoResponse {
+		t.Errorf("lookup without nodes: %v", err)
+	}
+
+	self := NewNode(rawInfoHash(dht.node.IDRawString()), localAddr(dht))
+
+	peers, peerConns := make([]Node, 2), make([]*net.UDPConn, 2)
+	for i := range peers {
+		peers[i], peerConns[i] = newUDPPeer(t)
+		defer peerConns[i].Close()
+
+		dht.routingTable.Insert(peers[i])
+	}
+
+	// the first peer knows us, the second one only knows the peers.
+	go serveQueries(peerConns[0], peers[0].IDRawString(), self.CompactNodeInfo())
+	go serveQueries(peerConns[1], peers[1].IDRawString(),
+		peers[0].CompactNodeInfo()+peers[1].CompactNodeInfo())
+
+	if listed, err := dht.SelfLookup(); err != nil || listed != 1 {
+		t.Errorf("listed by %d nodes, %v", listed, err)
+	}
+}
+
+// serveAnnounces answers the queries read from conn as the node of id. It
+// returns a token to get_peers, and accepts announce_peer if accept is
+// true, otherwise rejects it by a protocol error. Every query is sent to
+// queries.
+func serveAnnounces(conn *net.UDPConn, id string, accept bool, queries chan<- string) {
+	buff := make([]byte, 1024)
+	for {
+		n, raddr, err := conn.ReadFromUDP(buff)
+		if err != nil {
+			return
+		}
+
+		v, err := Decode(buff[:n])
+		if err != nil {
+			continue
+		}
+		query := v.(map[string]interface{})
+		q := query["q"].(string)
+		queries <- q
+
+		var response map[string]interface{}
+		switch {
+		case q == string(DHTQueryTypeGetPeers):
+			response = NewDHTQueryResponse(query["t"].(string),
+				map[string]interface{}{
+					"id": id, "token": "tk", "values": []interface{}{},
+				}).ToPayload()
+		case accept:
+			response = NewDHTQueryResponse(query["t"].(string),
+				map[string]interface{}{"id": id}).ToPayload()
+		default:
+			response = NewDHTErrorResponse(query["t"].(string),
+				ProtocolErrorCode, "invalid token").ToPayload()
+		}
+		conn.WriteToUDP([]byte(Encode(response)), raddr)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	infoHash := randomString(20)
+
+	cases := []struct {
+		accept bool
+		err    error
+		// the queries the node receives
+		queries []string
+	}{
+		{true, nil, []string{"get_peers", "announce_peer"}},
+		// a rejected announce is retried once with a fresh token.
+		{false, ErrAnnounceFailed, []string{
+			"get_peers", "announce_peer", "get_peers", "announce_peer",
+		}},
+	}
+
+	for i, c := range cases {
+		dht := newUDPDHT(t, NewStandardConfig())
+
+		if err := dht.Announce(infoHash, 6881, false); err != ErrNoAnnounceToken {
+			t.Errorf("case %d: announce without nodes: %v", i, err)
+		}
+
+		peer, conn := newUDPPeer(t)
+		queries := make(chan string, 8)
+		go serveAnnounces(conn, peer.IDRawString(), c.accept, queries)
+		dht.routingTable.Insert(peer)
+
+		if err := dht.Announce(infoHash, 6881, false); err != c.err {
+			t.Errorf("case %d: %v", i, err)
+		}
+
+		conn.Close()
+		dht.Close()
+
+		received := make([]string, 0, len(c.queries))
+		for len(queries) > 0 {
+			received = append(received, <-queries)
+		}
+		if strings.Join(received, ",") != strings.Join(c.queries, ",") {
+			t.Errorf("case %d: queries %v", i, received)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	dht := newUDPDHT(t, NewStandardConfig())
+	defer dht.Close()
+
+	ctx := context.Background()
+	if dht.HealthCheck(ctx) != ErrQueryNotSent {
+		t.Error("health check without nodes")
+	}
+
+	// a silent node times out the context.
+	silent, silentConn := newUDPPeer(t)
+	defer silentConn.Close()
+	dht.routingTable.Insert(silent)
+
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if err := dht.HealthCheck(timeout); err != context.DeadlineExceeded {
+		t.Errorf("health check of a silent node: %v", err)
+	}
+
+	// one responding node is enough.
+	peer, conn := newUDPPeer(t)
+	defer conn.Close()
+	dht.routingTable.Insert(peer)
+	go serveQueries(conn, peer.IDRawString(), "")
+
+	if err := dht.HealthCheck(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	clock := newFakeClock()
+	config := NewStandardConfig()
+	config.Clock = clock
+	config.Try = 2
+
+	dht := newUDPDHT(t, config)
+	defer dht.Close()
+
+	// the ticker clearing the node failures is always pending.
+	waitPending(t, clock, 1)
+
+	// waitTimeout waits for the query to wait for the response, then times
+	// it out.
+	waitTimeout := func() {
+		for clock.pending() == 1 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Second * 15)
+	}
+
+	peer, conn := newUDPPeer(t)
+	defer conn.Close()
+	go serveQueries(conn, peer.IDRawString(), "")
+
+	if rtt, err := dht.Ping(peer.Address().String()); err != nil || rtt != 0 {
+		t.Errorf("ping: %v, %v", rtt, err)
+	}
+	// an answered ping leaves its timeout, which is fired so waitTimeout
+	// only sees the timeout of the next ping.
+	clock.Advance(time.Second * 15)
+
+	// the first ping is dropped, so the time includes the timed out one.
+	retried, retriedConn := newUDPPeer(t)
+	defer retriedConn.Close()
+	go func() {
+		retriedConn.ReadFromUDP(make([]byte, 1024))
+		serveQueries(retriedConn, retried.IDRawString(), "")
+	}()
+
+	result := make(chan error)
+	go func() {
+		rtt, err := dht.Ping(retried.Address().String())
+		if err == nil && rtt != time.Second*15 {
+			t.Errorf("retried ping: %v", rtt)
+		}
+		result <- err
+	}()
+	waitTimeout()
+	if err := <-result; err != nil {
+		t.Errorf("retried ping: %v", err)
+	}
+	clock.Advance(time.Second * 15)
+
+	silent, silentConn := newUDPPeer(t)
+	defer silentConn.Close()
+
+	go func() {
+		_, err := dht.Ping(silent.Address().String())
+		result <- err
+	}()
+	waitTimeout()
+	waitTimeout()
+	if err := <-result; err != ErrPingTimeout {
+		t.Errorf("silent ping: %v", err)
+	}
+}
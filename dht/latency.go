@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySubBits is the bits of the sub-buckets every power of two is
+	// split into, so a recorded value is off by at most 1/2^latencySubBits.
+	latencySubBits = 3
+	// latencyMaxExp is the exponent of the largest power of two recorded in
+	// microseconds, 2^25us is about 33s. Larger values are recorded as it.
+	latencyMaxExp = 25
+	// latencyBuckets is the bucket count of a latencyHistogram.
+	latencyBuckets = (latencyMaxExp - latencySubBits + 2) << latencySubBits
+)
+
+// latencyHistogram is a log-linear histogram of durations in microseconds,
+// like an HDR histogram. It takes a fixed latencyBuckets counters however
+// many durations are recorded.
+type latencyHistogram struct {
+	sync.Mutex
+	counts [latencyBuckets]uint64
+	total  uint64
+}
+
+// newLatencyHistogram returns a latencyHistogram pointer.
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{}
+}
+
+// latencyIndex returns the bucket index of v microseconds.
+func latencyIndex(v uint64) int {
+	if v < 1<<latencySubBits {
+		return int(v)
+	}
+
+	exp := bits.Len64(v) - 1
+	if exp > latencyMaxExp {
+		return latencyBuckets - 1
+	}
+
+	sub := (v >> uint(exp-latencySubBits)) & (1<<latencySubBits - 1)
+	return (exp-latencySubBits+1)<<latencySubBits + int(sub)
+}
+
+// latencyValue returns the lower bound in microseconds of the bucket index.
+func latencyValue(index int) uint64 {
+	if index < 1<<latencySubBits {
+		return uint64(index)
+	}
+
+	exp := index>>latencySubBits + latencySubBits - 1
+	sub := uint64(index & (1<<latencySubBits - 1))
+	return (1<<latencySubBits + sub) << uint(exp-latencySubBits)
+}
+
+// record records the duration d.
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.counts[latencyIndex(uint64(d/time.Microsecond))]++
+	h.total++
+}
+
+// percentile returns the duration which p percent of the recorded durations
+// don't exceed. It returns 0 if nothing is recorded.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := uint64(float64(h.total)*p/100 + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+
+	var count uint64
+	for i, c := range h.counts {
+		count += c
+		if count >= rank {
+			return time.Duration(latencyValue(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(latencyValue(latencyBuckets-1)) * time.Microsecond
+}
+
+// reset clears the recorded durations.
+func (h *latencyHistogram) reset() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.counts = [latencyBuckets]uint64{}
+	h.total = 0
+}
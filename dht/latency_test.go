@@ -0,0 +1,52 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyIndex(t *testing.T) {
+	for _, v := range []uint64{0, 1, 7, 8, 9, 15, 16, 100, 1000, 123456, 1 << 25} {
+		low := latencyValue(latencyIndex(v))
+		if low > v || v-low > v/8 {
+			t.Errorf("%d is put to the bucket of %d", v, low)
+		}
+	}
+
+	if latencyIndex(1<<40) != latencyBuckets-1 {
+		t.Fail()
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram()
+
+	if h.percentile(50) != 0 {
+		t.Fail()
+	}
+
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	cases := []struct {
+		p   float64
+		out time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		d := h.percentile(c.p)
+		if d > c.out || c.out-d > c.out/8 {
+			t.Errorf("p%v = %v, want about %v", c.p, d, c.out)
+		}
+	}
+
+	h.reset()
+	if h.percentile(99) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,196 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// lookupMaxRounds is the max rounds a lookup re-issues queries to the
+	// neighbors of its target.
+	lookupMaxRounds = 16
+	// lookupExpiredAfter is how long a lookup is forgotten after its last
+	// round, then a new lookup of the same target starts over.
+	lookupExpiredAfter = time.Minute
+)
+
+// lookup is the state of an iterative find_node or get_peers lookup.
+type lookup struct {
+	visited    map[string]bool
+	rounds     int
+	lastActive time.Time
+	// whether the lookup holds a slot, i.e. it's active
+	active bool
+}
+
+// lookupTracker tracks the lookups keyed by query type and target, so the
+// concurrent lookups of the same target share the state. A lookup holds one
+// of the max slots from its first round until it runs lookupMaxRounds
+// rounds or expires, no limit if max is 0.
+type lookupTracker struct {
+	sync.Mutex
+	lookups  map[string]*lookup
+	max      int
+	active   int
+	released *sync.Cond
+	clock    Clock
+}
+
+// newLookupTracker returns a lookupTracker pointer which runs at most max
+// lookups at once.
+func newLookupTracker(max int, clock Clock) *lookupTracker {
+	lt := &lookupTracker{
+		lookups: make(map[string]*lookup),
+		max:     max,
+		clock:   clock,
+	}
+	lt.released = sync.NewCond(&lt.Mutex)
+	return lt
+}
+
+// acquire takes a slot. If all slots are taken, it waits for a free one if
+// wait is true, otherwise it returns false. The lock must be held.
+func (lt *lookupTracker) acquire(wait bool) bool {
+	for lt.max > 0 && lt.active >= lt.max {
+		if !wait {
+			return false
+		}
+		lt.released.Wait()
+	}
+	lt.active++
+	return true
+}
+
+// release frees a slot. The lock must be held.
+func (lt *lookupTracker) release() {
+	lt.active--
+	lt.released.Signal()
+}
+
+// get returns the lookup keyed by key, or starts a new one which takes a
+// slot. It returns nil if all slots are taken.
+func (lt *lookupTracker) get(key string) *lookup {
+	l, ok := lt.lookups[key]
+	if ok && lt.clock.Now().Sub(l.lastActive) <= lookupExpiredAfter {
+		return l
+	}
+
+	// an expired lookup passes its slot to the new one.
+	if !ok || !l.active {
+		if !lt.acquire(false) {
+			return nil
+		}
+	}
+
+	l = &lookup{
+		visited:    make(map[string]bool),
+		lastActive: lt.clock.Now(),
+		active:     true,
+	}
+	lt.lookups[key] = l
+	return l
+}
+
+// start starts the lookup of target unless it's running. It returns false
+// if all slots are taken.
+func (lt *lookupTracker) start(queryType DHTQueryType, target string) bool {
+	lt.Lock()
+	defer lt.Unlock()
+
+	return lt.get(queryType.String()+":"+target) != nil
+}
+
+// next returns the nodes the lookup of target should query in its next
+// round. The nodes queried before by the lookup are skipped, and it returns
+// nil once the lookup runs lookupMaxRounds rounds, or if a new lookup can't
+// start as all slots are taken.
+func (lt *lookupTracker) next(queryType DHTQueryType, target string, nodes []Node) []Node {
+	lt.Lock()
+	defer lt.Unlock()
+
+	l := lt.get(queryType.String() + ":" + target)
+	if l == nil || l.rounds >= lookupMaxRounds {
+		return nil
+	}
+
+	result := make([]Node, 0, len(nodes))
+	for _, no := range nodes {
+		address := no.Address().String()
+		if !l.visited[address] {
+			l.visited[address] = true
+			result = append(result, no)
+		}
+	}
+
+	if len(result) > 0 {
+		l.rounds++
+		l.lastActive = lt.clock.Now()
+	}
+
+	// the lookup queries no more, so it frees its slot.
+	if l.rounds >= lookupMaxRounds && l.active {
+		l.active = false
+		lt.release()
+	}
+	return result
+}
+
+// begin takes a slot for a lookup which isn't tracked, e.g. a Bootstrap
+// round, waiting for a free one if all slots are taken. Call end after the
+// lookup finishes.
+func (lt *lookupTracker) begin() {
+	lt.Lock()
+	defer lt.Unlock()
+
+	lt.acquire(true)
+}
+
+// end frees the slot taken by begin.
+func (lt *lookupTracker) end() {
+	lt.Lock()
+	defer lt.Unlock()
+
+	lt.release()
+}
+
+// activeLen returns how many lookups are active.
+func (lt *lookupTracker) activeLen() int {
+	lt.Lock()
+	defer lt.Unlock()
+
+	return lt.active
+}
+
+// len returns how many lookups are tracked.
+func (lt *lookupTracker) len() int {
+	lt.Lock()
+	defer lt.Unlock()
+
+	return len(lt.lookups)
+}
+
+// clear removes the expired lookups until done is closed.
+func (lt *lookupTracker) clear(done <-chan struct{}) {
+	ticker := lt.clock.NewTicker(lookupExpiredAfter)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+		now := lt.clock.Now()
+
+		lt.Lock()
+		for key, l := range lt.lookups {
+			if now.Sub(l.lastActive) > lookupExpiredAfter {
+				if l.active {
+					lt.release()
+				}
+				delete(lt.lookups, key)
+			}
+		}
+		lt.Unlock()
+	}
+}
@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLookupTracker(t *testing.T) {
+	lt := newLookupTracker(0, realClock{})
+	target := randomString(20)
+
+	nodes := make([]Node, 0, lookupMaxRounds+2)
+	for i := 0; i <= lookupMaxRounds+1; i++ {
+		nodes = append(nodes, NewTempNode(&net.UDPAddr{
+			IP: net.IPv4(1, 1, 1, byte(i)), Port: 6881,
+		}))
+	}
+
+	if len(lt.next(DHTQueryTypeFindNode, target, nodes[:2])) != 2 {
+		t.Fail()
+	}
+
+	// visited nodes are skipped.
+	if next := lt.next(DHTQueryTypeFindNode, target, nodes[:3]); len(next) != 1 ||
+		next[0] != nodes[2] {
+		t.Fail()
+	}
+
+	// lookups of other query types don't share the state.
+	if len(lt.next(DHTQueryTypeGetPeers, target, nodes[:2])) != 2 || lt.len() != 2 {
+		t.Fail()
+	}
+
+	for i := 3; i <= lookupMaxRounds; i++ {
+		lt.next(DHTQueryTypeFindNode, target, nodes[i:i+1])
+	}
+
+	if lt.next(DHTQueryTypeFindNode, target, nodes) != nil {
+		t.Fail()
+	}
+}
+
+func TestLookupTrackerLimit(t *testing.T) {
+	lt := newLookupTracker(2, realClock{})
+	no := NewTempNode(&net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881})
+
+	if !lt.start(DHTQueryTypeGetPeers, "a") || lt.next(DHTQueryTypeFindNode, "b",
+		[]Node{no}) == nil || lt.activeLen() != 2 {
+		t.Fail()
+	}
+
+	// the running lookups go on, a new one is rejected.
+	if !lt.start(DHTQueryTypeGetPeers, "a") || lt.start(DHTQueryTypeGetPeers, "c") ||
+		lt.next(DHTQueryTypeFindNode, "c", []Node{no}) != nil {
+		t.Fail()
+	}
+
+	// begin waits until a lookup runs out of rounds.
+	began := make(chan struct{})
+	go func() {
+		lt.begin()
+		close(began)
+	}()
+
+	for i := 1; i < lookupMaxRounds; i++ {
+		lt.next(DHTQueryTypeFindNode, "b", []Node{NewTempNode(&net.UDPAddr{
+			IP: net.IPv4(1, 1, 2, byte(i)), Port: 6881,
+		})})
+	}
+	<-began
+
+	if lt.activeLen() != 2 {
+		t.Fail()
+	}
+
+	lt.end()
+	if lt.activeLen() != 1 || !lt.start(DHTQueryTypeGetPeers, "c") {
+		t.Fail()
+	}
+}
+
+func TestLookupTrackerExpiry(t *testing.T) {
+	clock := newFakeClock()
+	lt := newLookupTracker(1, clock)
+	no := NewTempNode(&net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881})
+
+	if len(lt.next(DHTQueryTypeFindNode, "a", []Node{no})) != 1 {
+		t.Fail()
+	}
+
+	// an expired lookup starts over with its slot.
+	clock.Advance(lookupExpiredAfter + time.Second)
+	if len(lt.next(DHTQueryTypeFindNode, "a", []Node{no})) != 1 ||
+		lt.activeLen() != 1 {
+
+		t.Fail()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go lt.clear(done)
+	waitPending(t, clock, 1)
+
+	clock.Advance(lookupExpiredAfter * 2)
+	deadline := time.Now().Add(time.Second * 5)
+	for lt.len() != 0 || lt.activeLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d lookups kept", lt.len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
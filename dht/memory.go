@@ -0,0 +1,66 @@
+package dht
+
+// The rough sizes in bytes of the entries, including the container
+// overhead, and the shares of MemoryBudgetMB they get.
+const (
+	nodeEntryBytes    = 512
+	peerEntryBytes    = 192
+	blockedEntryBytes = 160
+	tokenEntryBytes   = 128
+
+	nodeBudgetShare    = 0.2
+	peerBudgetShare    = 0.5
+	blockedBudgetShare = 0.15
+	tokenBudgetShare   = 0.15
+)
+
+// budgetCap returns how many entries of entryBytes the share of
+// MemoryBudgetMB holds, or limit if it's smaller and positive. It returns
+// limit if there is no budget.
+func (config *Config) budgetCap(limit int, share float64, entryBytes int) int {
+	if config.MemoryBudgetMB <= 0 {
+		return limit
+	}
+
+	n := int(float64(config.MemoryBudgetMB) * (1 << 20) * share / float64(entryBytes))
+	if limit > 0 && limit < n {
+		return limit
+	}
+	return n
+}
+
+// maxNodes returns MaxNodes capped by the memory budget.
+func (config *Config) maxNodes() int {
+	return config.budgetCap(config.MaxNodes, nodeBudgetShare, nodeEntryBytes)
+}
+
+// maxPeersTotal returns MaxPeersTotal capped by the memory budget.
+func (config *Config) maxPeersTotal() int {
+	return config.budgetCap(config.MaxPeersTotal, peerBudgetShare, peerEntryBytes)
+}
+
+// blackListMaxSize returns BlackListMaxSize capped by the memory budget.
+func (config *Config) blackListMaxSize() int {
+	return config.budgetCap(config.BlackListMaxSize, blockedBudgetShare,
+		blockedEntryBytes)
+}
+
+// maxTokens returns how many tokens are kept, no limit if it's 0. Only the
+// memory budget limits it.
+func (config *Config) maxTokens() int {
+	return config.budgetCap(0, tokenBudgetShare, tokenEntryBytes)
+}
+
+// EstimatedMemory returns the rough bytes taken by the routing table, the
+// peers, the blacklist and the tokens, which MemoryBudgetMB bounds. It
+// returns 0 if the dht isn't running.
+func (dht *DHT) EstimatedMemory() int {
+	if !dht.Ready {
+		return 0
+	}
+
+	return dht.routingTable.Len()*nodeEntryBytes +
+		dht.peersManager.Total()*peerEntryBytes +
+		dht.blackList.list.Len()*blockedEntryBytes +
+		dht.tokenManager.Len()*tokenEntryBytes
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBudgetCap(t *testing.T) {
+	cases := []struct {
+		budget int
+		limit  int
+		out    int
+	}{
+		{0, 5000, 5000},
+		{0, 0, 0},
+		// 1MB * 0.5 / 128 bytes.
+		{1, 0, 4096},
+		{1, 10000, 4096},
+		{1, 1000, 1000},
+		{16, 10000, 10000},
+	}
+
+	for _, c := range cases {
+		config := NewStandardConfig()
+		config.MemoryBudgetMB = c.budget
+
+		if out := config.budgetCap(c.limit, 0.5, 128); out != c.out {
+			t.Errorf("budget %dMB, limit %d: %d", c.budget, c.limit, out)
+		}
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	config := NewStandardConfig()
+	config.MemoryBudgetMB = 1
+
+	dht := newHandlerDHT(config)
+	dht.Ready = true
+
+	max := config.maxTokens()
+	for i := 0; i <= max; i++ {
+		dht.tokenManager.token(&net.UDPAddr{
+			IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 6881})
+	}
+	if dht.tokenManager.Len() != max {
+		t.Errorf("%d tokens kept, max %d", dht.tokenManager.Len(), max)
+	}
+
+	maxPeers := config.maxPeersTotal()
+	for i := 0; i <= maxPeers; i++ {
+		dht.peersManager.Insert(randomString(20), NewPeer(net.IPv4(1, 1, 1, 1), 6881, ""))
+	}
+	if dht.peersManager.Total() != maxPeers {
+		t.Errorf("%d peers kept, max %d", dht.peersManager.Total(), maxPeers)
+	}
+
+	if used := dht.EstimatedMemory(); used <= 0 || used > 1<<20 {
+		t.Errorf("estimated %d bytes", used)
+	}
+}
@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/MildC/dht-crawler/torrent"
+)
+
+// ParseMetadata parses the bencoded info dict fetched by Wire into a
+// torrent.BitTorrent. It returns ErrSizeLimitExceeded if decoding the info
+// dict takes more than maxSize bytes, see DecodeWithLimit. The RawInfo of
+// the torrent refers to metadataInfo, it isn't copied.
+func ParseMetadata(infoHash, metadataInfo []byte, maxSize int) (
+	bt torrent.BitTorrent, err error) {
+
+	v, err := DecodeWithLimit(metadataInfo, maxSize)
+	if err != nil {
+		return
+	}
+
+	info, ok := v.(map[string]interface{})
+	if !ok {
+		err = errors.New("metadata is not dict")
+		return
+	}
+
+	if err = ParseKey(info, "name", "string"); err != nil {
+		return
+	}
+
+	bt = torrent.BitTorrent{
+		InfoHash: hex.EncodeToString(infoHash),
+		Name:     info["name"].(string),
+		RawInfo:  metadataInfo,
+	}
+
+	// Both are optional, so they default to false and 0.
+	if private, ok := info["private"].(int); ok {
+		bt.Private = private == 1
+	}
+	if pieceLength, ok := info["piece length"].(int); ok {
+		bt.PieceLength = int64(pieceLength)
+	}
+	if pieces, ok := info["pieces"].(string); ok {
+		bt.PieceCount = len(pieces) / 20
+	}
+	if source, ok := info["source"].(string); ok {
+		bt.Source = source
+	}
+
+	// They're seldom in the info dict, see torrent.BitTorrent.
+	if creationDate, ok := info["creation date"].(int); ok {
+		bt.CreationDate = int64(creationDate)
+	}
+	if comment, ok := info["comment"].(string); ok {
+		bt.Comment = comment
+	}
+	if createdBy, ok := info["created by"].(string); ok {
+		bt.CreatedBy = createdBy
+	}
+
+	if files, ok := info["files"].([]interface{}); ok {
+		bt.Files = make([]torrent.File, len(files))
+
+		for i, item := range files {
+			f, ok := item.(map[string]interface{})
+			if !ok {
+				err = errors.New("file is not dict")
+				return
+			}
+
+			if err = ParseKeys(
+				f, [][]string{{"path", "list"}, {"length", "int"}}); err != nil {
+				return
+			}
+
+			bt.Files[i] = torrent.File{
+				Path:   f["path"].([]interface{}),
+				Length: f["length"].(int),
+			}
+		}
+	} else if length, ok := info["length"].(int); ok {
+		bt.Length = length
+	}
+
+	return
+}
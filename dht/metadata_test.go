@@ -0,0 +1,66 @@
+package dht
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	cases := []struct {
+		in          string
+		private     bool
+		pieceLength int64
+		ok          bool
+	}{
+		{"d6:lengthi10e4:name1:ae", false, 0, true},
+		{"d6:lengthi10e4:name1:a12:piece lengthi16384e7:privatei1ee", true, 16384, true},
+		{"d6:lengthi10e4:name1:a7:privatei0ee", false, 0, true},
+		{"d6:lengthi10e4:name1:a7:private1:1e", false, 0, true},
+		{"d6:lengthi10ee", false, 0, false},
+	}
+
+	for _, c := range cases {
+		bt, err := ParseMetadata([]byte("infohash"), []byte(c.in), 0)
+		if (err == nil) != c.ok || bt.Private != c.private ||
+			bt.PieceLength != c.pieceLength {
+
+			t.Errorf("ParseMetadata(%q) = %+v, %v", c.in, bt, err)
+		}
+	}
+}
+
+func TestParseMetadataOptionalFields(t *testing.T) {
+	bt, err := ParseMetadata([]byte("infohash"), []byte(
+		"d7:comment3:foo10:created by4:bar113:creation datei1500000000e"+
+			"6:lengthi10e4:name1:ae"), 0)
+	if err != nil || bt.CreationDate != 1500000000 || bt.Comment != "foo" ||
+		bt.CreatedBy != "bar1" {
+
+		t.Errorf("unexpected torrent %+v, %v", bt, err)
+	}
+
+	bt, err = ParseMetadata([]byte("infohash"), []byte("d6:lengthi10e4:name1:ae"), 0)
+	if err != nil || bt.CreationDate != 0 || bt.Comment != "" || bt.CreatedBy != "" {
+		t.Errorf("unexpected torrent %+v, %v", bt, err)
+	}
+}
+
+func TestParseMetadataPieces(t *testing.T) {
+	cases := []struct {
+		in         string
+		pieceCount int
+		source     string
+	}{
+		{"d6:lengthi10e4:name1:ae", 0, ""},
+		{"d6:lengthi10e4:name1:a6:pieces40:" + strings.Repeat("a", 40) +
+			"6:source3:fooe", 2, "foo"},
+		{"d6:lengthi10e4:name1:a6:piecesi1e6:sourcei1ee", 0, ""},
+	}
+
+	for _, c := range cases {
+		bt, err := ParseMetadata([]byte("infohash"), []byte(c.in), 0)
+		if err != nil || bt.PieceCount != c.pieceCount || bt.Source != c.source {
+			t.Errorf("ParseMetadata(%q) = %+v, %v", c.in, bt, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package dht
+
+// metric is a core counter or gauge of the dht. The reporters read the same
+// metrics, so they stay consistent.
+type metric struct {
+	name string
+	// whether the value only grows, otherwise it's a gauge
+	counter bool
+	value   func(d *DHT) uint64
+}
+
+// metrics are the metrics every reporter publishes.
+var metrics = []metric{
+	{"routing_table_size", false, func(d *DHT) uint64 {
+		if !d.Ready {
+			return 0
+		}
+		return uint64(d.routingTable.Len())
+	}},
+	{"blacklist_size", false, func(d *DHT) uint64 {
+		return uint64(d.blackList.list.Len())
+	}},
+	{"transactions", false, func(d *DHT) uint64 {
+		if !d.Ready {
+			return 0
+		}
+		return uint64(d.transactionManager.len())
+	}},
+	{"peers", false, func(d *DHT) uint64 {
+		return uint64(d.StoredPeers())
+	}},
+	{"memory_estimated_bytes", false, func(d *DHT) uint64 {
+		return uint64(d.EstimatedMemory())
+	}},
+	{"packets_received", true, func(d *DHT) uint64 {
+		return d.ReceivedPackets()
+	}},
+	{"packets_dropped", true, func(d *DHT) uint64 {
+		return d.DroppedPackets()
+	}},
+	{"torrents_discovered", true, func(d *DHT) uint64 {
+		return d.DiscoveredTorrents()
+	}},
+	{"torrents_dropped", true, func(d *DHT) uint64 {
+		return d.DroppedTorrents()
+	}},
+}
@@ -0,0 +1,225 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// EncryptionDisabled uses the plaintext BitTorrent handshake only.
+	EncryptionDisabled = iota
+	// EncryptionPrefer tries MSE first and accepts either RC4 or plaintext
+	// selected by the peer.
+	EncryptionPrefer
+	// EncryptionRequire only accepts RC4 encrypted connections.
+	EncryptionRequire
+)
+
+const (
+	// cryptoPlaintext is the crypto_provide bit of plaintext.
+	cryptoPlaintext = 0x01
+	// cryptoRC4 is the crypto_provide bit of RC4.
+	cryptoRC4 = 0x02
+	// msePublicKeyLength is the length of the DH public key.
+	msePublicKeyLength = 96
+	// mseMaxPadLength is the max length of paddings.
+	mseMaxPadLength = 512
+)
+
+var (
+	// mseP is the 768-bit prime of the DH key exchange.
+	mseP, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563",
+		16,
+	)
+	// mseG is the generator of the DH key exchange.
+	mseG = big.NewInt(2)
+	// mseVC is the verification constant.
+	mseVC = make([]byte, 8)
+)
+
+// mseConn is a connection after the MSE handshake. The data is RC4
+// encrypted if the peer selected RC4, otherwise it's plaintext.
+type mseConn struct {
+	net.Conn
+	reader    io.Reader
+	encrypter *rc4.Cipher
+	decrypter *rc4.Cipher
+}
+
+// Read reads and decrypts data from the connection.
+func (c *mseConn) Read(b []byte) (n int, err error) {
+	n, err = c.reader.Read(b)
+	if c.decrypter != nil {
+		c.decrypter.XORKeyStream(b[:n], b[:n])
+	}
+	return
+}
+
+// Write encrypts and writes data to the connection.
+func (c *mseConn) Write(b []byte) (int, error) {
+	if c.encrypter == nil {
+		return c.Conn.Write(b)
+	}
+
+	data := make([]byte, len(b))
+	c.encrypter.XORKeyStream(data, b)
+	return c.Conn.Write(data)
+}
+
+// mseHash returns the sha1 of the concatenated parts.
+func mseHash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// newMSECipher returns a RC4 cipher which discards the first 1024 bytes of
+// the key stream.
+func newMSECipher(key []byte) *rc4.Cipher {
+	cipher, _ := rc4.NewCipher(key)
+
+	discard := make([]byte, 1024)
+	cipher.XORKeyStream(discard, discard)
+	return cipher
+}
+
+// msePad returns a random padding whose length is in [0, maxLength].
+func msePad(maxLength int) []byte {
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(maxLength+1)))
+	return []byte(randomString(int(n.Int64())))
+}
+
+// msePublicKey returns a private key and the msePublicKeyLength-length public
+// key.
+func msePublicKey() (x *big.Int, y []byte) {
+	x = new(big.Int).SetBytes([]byte(randomString(20)))
+	y = mseBytes(new(big.Int).Exp(mseG, x, mseP))
+	return
+}
+
+// mseBytes returns the msePublicKeyLength-length big-endian bytes of n.
+func mseBytes(n *big.Int) []byte {
+	data := make([]byte, msePublicKeyLength)
+	b := n.Bytes()
+	copy(data[msePublicKeyLength-len(b):], b)
+	return data
+}
+
+// mseSync reads from reader until the data read ends with mark. At most
+// mseMaxPadLength bytes can be skipped before mark.
+func mseSync(reader *bufio.Reader, mark []byte) error {
+	window := make([]byte, 0, mseMaxPadLength+len(mark))
+
+	for len(window) < cap(window) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		window = append(window, b)
+		if bytes.HasSuffix(window, mark) {
+			return nil
+		}
+	}
+	return errors.New("mse sync mark not found")
+}
+
+// mseHandshake performs the MSE handshake as the initiator. infoHash is used
+// as SKEY. If policy is EncryptionRequire only RC4 is provided, otherwise
+// both RC4 and plaintext are provided and the peer selects one.
+func mseHandshake(conn net.Conn, infoHash []byte, policy int) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(time.Second * 15))
+	defer conn.SetDeadline(time.Time{})
+
+	xa, ya := msePublicKey()
+	if _, err := conn.Write(append(ya, msePad(mseMaxPadLength)...)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	yb := make([]byte, msePublicKeyLength)
+	if _, err := io.ReadFull(reader, yb); err != nil {
+		return nil, err
+	}
+
+	s := mseBytes(new(big.Int).Exp(new(big.Int).SetBytes(yb), xa, mseP))
+
+	encrypter := newMSECipher(mseHash([]byte("keyA"), s, infoHash))
+	decrypter := newMSECipher(mseHash([]byte("keyB"), s, infoHash))
+
+	provide := uint32(cryptoRC4)
+	if policy != EncryptionRequire {
+		provide |= cryptoPlaintext
+	}
+
+	// VC, crypto_provide, len(PadC), PadC and len(IA). Both PadC and IA are
+	// empty.
+	payload := make([]byte, 16)
+	binary.BigEndian.PutUint32(payload[8:12], provide)
+	encrypter.XORKeyStream(payload, payload)
+
+	req2, req3 := mseHash([]byte("req2"), infoHash), mseHash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+
+	msg := bytes.Join([][]byte{mseHash([]byte("req1"), s), req2, payload}, nil)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	// The peer's VC is encrypted by the first 8 bytes of the keyB stream.
+	mark := make([]byte, len(mseVC))
+	newMSECipher(mseHash([]byte("keyB"), s, infoHash)).XORKeyStream(mark, mseVC)
+	if err := mseSync(reader, mark); err != nil {
+		return nil, err
+	}
+	decrypter.XORKeyStream(mark, mark)
+
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	decrypter.XORKeyStream(header, header)
+
+	selected := binary.BigEndian.Uint32(header[:4])
+	padLength := int(binary.BigEndian.Uint16(header[4:]))
+	if padLength > mseMaxPadLength {
+		return nil, errors.New("mse padD too long")
+	}
+
+	padD := make([]byte, padLength)
+	if _, err := io.ReadFull(reader, padD); err != nil {
+		return nil, err
+	}
+	decrypter.XORKeyStream(padD, padD)
+
+	switch {
+	case selected == cryptoRC4:
+		return &mseConn{
+			Conn:      conn,
+			reader:    reader,
+			encrypter: encrypter,
+			decrypter: decrypter,
+		}, nil
+	case selected == cryptoPlaintext && provide&cryptoPlaintext != 0:
+		return &mseConn{Conn: conn, reader: reader}, nil
+	default:
+		return nil, errors.New("invalid mse crypto_select")
+	}
+}
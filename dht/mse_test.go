@@ -0,0 +1,145 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// mseRespond performs the receiver side of the MSE handshake, selecting
+// crypto if the initiator provides it, then echoes one message.
+func mseRespond(conn net.Conn, infoHash []byte, crypto uint32) error {
+	reader := bufio.NewReader(conn)
+
+	ya := make([]byte, msePublicKeyLength)
+	if _, err := io.ReadFull(reader, ya); err != nil {
+		return err
+	}
+
+	xb, yb := msePublicKey()
+	if _, err := conn.Write(append(yb, msePad(16)...)); err != nil {
+		return err
+	}
+
+	s := mseBytes(new(big.Int).Exp(new(big.Int).SetBytes(ya), xb, mseP))
+	if err := mseSync(reader, mseHash([]byte("req1"), s)); err != nil {
+		return err
+	}
+
+	req2, req3 := mseHash([]byte("req2"), infoHash), mseHash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+
+	skey := make([]byte, 20)
+	if _, err := io.ReadFull(reader, skey); err != nil {
+		return err
+	}
+	if !bytes.Equal(skey, req2) {
+		return errors.New("invalid skey")
+	}
+
+	decrypter := newMSECipher(mseHash([]byte("keyA"), s, infoHash))
+	encrypter := newMSECipher(mseHash([]byte("keyB"), s, infoHash))
+
+	payload := make([]byte, 16)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return err
+	}
+	decrypter.XORKeyStream(payload, payload)
+
+	if !bytes.Equal(payload[:8], mseVC) {
+		return errors.New("invalid vc")
+	}
+	if binary.BigEndian.Uint32(payload[8:12])&crypto == 0 {
+		return errors.New("crypto not provided")
+	}
+
+	reply := make([]byte, 14)
+	binary.BigEndian.PutUint32(reply[8:12], crypto)
+	encrypter.XORKeyStream(reply, reply)
+	if _, err := conn.Write(reply); err != nil {
+		return err
+	}
+
+	if crypto == cryptoPlaintext {
+		decrypter, encrypter = nil, nil
+	}
+
+	peer := &mseConn{
+		Conn:      conn,
+		reader:    reader,
+		encrypter: encrypter,
+		decrypter: decrypter,
+	}
+
+	msg := make([]byte, 5)
+	if _, err := io.ReadFull(peer, msg); err != nil {
+		return err
+	}
+	_, err := peer.Write(msg)
+	return err
+}
+
+func TestMSEHandshake(t *testing.T) {
+	cases := []struct {
+		policy int
+		crypto uint32
+		ok     bool
+	}{
+		{EncryptionPrefer, cryptoRC4, true},
+		{EncryptionPrefer, cryptoPlaintext, true},
+		{EncryptionRequire, cryptoRC4, true},
+		{EncryptionRequire, cryptoPlaintext, false},
+	}
+
+	infoHash := []byte(randomString(20))
+
+	for _, c := range cases {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func(crypto uint32) {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			mseRespond(conn, infoHash, crypto)
+		}(c.crypto)
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted, err := mseHandshake(conn, infoHash, c.policy)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("policy %d should reject crypto %d", c.policy, c.crypto)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else {
+			encrypted.Write([]byte("hello"))
+
+			msg := make([]byte, 5)
+			if _, err := io.ReadFull(encrypted, msg); err != nil ||
+				string(msg) != "hello" {
+
+				t.Errorf("unexpected echo %q, %v", msg, err)
+			}
+		}
+
+		conn.Close()
+		listener.Close()
+	}
+}
@@ -15,6 +15,7 @@ type Node interface {
 	LastActiveTime() time.Time
 	CompactIPPortInfo() string
 	CompactNodeInfo() string
+	Equal(other Node) bool
 }
 
 type node struct {
@@ -23,33 +24,60 @@ type node struct {
 	lastActiveTime time.Time
 }
 
-func NewNode(id string, address *net.UDPAddr) Node {
-	return &node{newBitmapFromString(id), address, time.Now()}
+// NewNode returns the node of id at address, which is active now. A node id
+// is in the keyspace of the infohashes, so it's an InfoHash too. The DHT
+// creates its nodes by Config.Clock instead.
+func NewNode(id InfoHash, address *net.UDPAddr) Node {
+	return newNodeAt(id.Raw(), address, time.Now())
 }
 
-func NewTempNode(address *net.UDPAddr) Node {
-	return &node{address: address, lastActiveTime: time.Now()}
+// newNodeAt returns a node which is last active at t.
+func newNodeAt(id string, address *net.UDPAddr, t time.Time) Node {
+	return &node{newBitmapFromString(id), address, t}
 }
 
-func NewNodeNetworkAddress(id, network, address string) (Node, error) {
-	if len(id) != 20 {
-		return nil, errors.New("node id should be a 20-length string")
+// activeAt returns a copy of no which is last active at t. A Node not
+// created by this package is returned as it is.
+func activeAt(no Node, t time.Time) Node {
+	n, ok := no.(*node)
+	if !ok {
+		return no
 	}
 
+	c := *n
+	c.lastActiveTime = t
+	return &c
+}
+
+// NewTempNode returns the node at address without id, which is active now.
+func NewTempNode(address *net.UDPAddr) Node {
+	return newTempNodeAt(address, time.Now())
+}
+
+// newTempNodeAt returns a node without id which is last active at t.
+func newTempNodeAt(address *net.UDPAddr, t time.Time) Node {
+	return &node{address: address, lastActiveTime: t}
+}
+
+// NewNodeNetworkAddress returns the node of id at address, which is
+// resolved in network.
+func NewNodeNetworkAddress(id InfoHash, network, address string) (Node, error) {
 	addr, err := net.ResolveUDPAddr(network, address)
 	if err != nil {
 		return nil, err
 	}
 
-	return &node{newBitmapFromString(id), addr, time.Now()}, nil
+	return &node{newBitmapFromString(id.Raw()), addr, time.Now()}, nil
 }
 
+// NewNodeFromCompactInfo returns the node of the compact node info, which is
+// 26 bytes for an IPv4 node and 38 bytes for an IPv6 node.
 func NewNodeFromCompactInfo(compactNodeInfo string, network string) (Node, error) {
-	if len(compactNodeInfo) != 26 {
-		return nil, errors.New("compactNodeInfo should be a 26-length string")
+	if len(compactNodeInfo) != 26 && len(compactNodeInfo) != 38 {
+		return nil, errors.New("compactNodeInfo should be a 26-length or 38-length string")
 	}
 
-	id := compactNodeInfo[:20]
+	id := rawInfoHash(compactNodeInfo[:20])
 	ip, port, _ := decodeCompactIPPortInfo(compactNodeInfo[20:])
 
 	return NewNodeNetworkAddress(id, network, genAddress(ip.String(), port))
@@ -85,3 +113,20 @@ func (n *node) CompactNodeInfo() string {
 		n.id.RawString(), n.CompactIPPortInfo(),
 	}, "")
 }
+
+// Equal returns whether two nodes have the same id and the same address.
+// Temporary nodes are only equal to temporary nodes with the same address.
+// The last active time is ignored.
+func (n *node) Equal(other Node) bool {
+	if other == nil {
+		return false
+	}
+
+	if (n.id == nil) != (other.ID() == nil) ||
+		n.id != nil && n.IDRawString() != other.IDRawString() {
+		return false
+	}
+
+	addr := other.Address()
+	return n.address.IP.Equal(addr.IP) && n.address.Port == addr.Port
+}
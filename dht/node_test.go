@@ -0,0 +1,53 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNodeEqual(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881}
+	id := "aaaaaaaaaaaaaaaaaaaa"
+
+	cases := []struct {
+		a, b  Node
+		equal bool
+	}{
+		{NewNode(rawInfoHash(id), addr), NewNode(rawInfoHash(id), addr), true},
+		{NewNode(rawInfoHash(id), addr), NewNode(rawInfoHash("bbbbbbbbbbbbbbbbbbbb"), addr), false},
+		{NewNode(rawInfoHash(id), addr), NewNode(rawInfoHash(id), &net.UDPAddr{
+			IP: net.IPv4(1, 2, 3, 4), Port: 6882}), false},
+		{NewNode(rawInfoHash(id), addr), NewNode(rawInfoHash(id), &net.UDPAddr{
+			IP: net.ParseIP("1.2.3.4").To4(), Port: 6881}), true},
+		{NewTempNode(addr), NewTempNode(addr), true},
+		{NewTempNode(addr), NewNode(rawInfoHash(id), addr), false},
+		{NewNode(rawInfoHash(id), addr), NewTempNode(addr), false},
+	}
+
+	for i, c := range cases {
+		if c.a.Equal(c.b) != c.equal {
+			t.Errorf("case %d: Equal should be %v", i, c.equal)
+		}
+	}
+
+	if NewNode(rawInfoHash(id), addr).Equal(nil) {
+		t.Fail()
+	}
+}
+
+func TestNewNodeFromCompactInfoIPv6(t *testing.T) {
+	id := "aaaaaaaaaaaaaaaaaaaa"
+	ip := net.ParseIP("2001:db8::1")
+	info := id + string(ip) + "\x1a\xe1"
+
+	no, err := NewNodeFromCompactInfo(info, "udp6")
+	if err != nil || no.IDRawString() != id || !no.Address().IP.Equal(ip) ||
+		no.Address().Port != 6881 || no.CompactNodeInfo() != info {
+
+		t.Fatal(no, err)
+	}
+
+	if _, err := NewNodeFromCompactInfo(info, "udp4"); err == nil {
+		t.Error("IPv6 node accepted on udp4")
+	}
+}
@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// packetWorkerShrinkPeriod is how often the packet worker limit is checked
+// for shrinking.
+const packetWorkerShrinkPeriod = time.Second * 10
+
+// packetWorkers limits the goroutines handling the packets. The limit starts
+// at min, it doubles up to max whenever a packet arrives while all the
+// workers are busy, and halves down to min every packetWorkerShrinkPeriod if
+// less than half of it is used. It's static if min is 0 or no less than max.
+type packetWorkers struct {
+	limit  int32
+	peak   int32
+	min    int
+	max    int
+	tokens chan struct{}
+}
+
+// newPacketWorkers returns a packetWorkers pointer.
+func newPacketWorkers(min, max int) *packetWorkers {
+	if min <= 0 || min > max {
+		min = max
+	}
+
+	return &packetWorkers{
+		limit:  int32(min),
+		min:    min,
+		max:    max,
+		tokens: make(chan struct{}, max),
+	}
+}
+
+// adaptive returns whether the limit changes with the load.
+func (pw *packetWorkers) adaptive() bool {
+	return pw.min < pw.max
+}
+
+// acquire takes a worker, growing the limit if all the workers are busy. It
+// returns false if the limit reaches max. It's called by a single goroutine.
+func (pw *packetWorkers) acquire() bool {
+	busy, limit := len(pw.tokens), int(atomic.LoadInt32(&pw.limit))
+
+	if busy >= limit {
+		if limit >= pw.max {
+			return false
+		}
+
+		if limit *= 2; limit > pw.max {
+			limit = pw.max
+		}
+		atomic.StoreInt32(&pw.limit, int32(limit))
+	}
+
+	pw.tokens <- struct{}{}
+	if int32(busy+1) > atomic.LoadInt32(&pw.peak) {
+		atomic.StoreInt32(&pw.peak, int32(busy+1))
+	}
+	return true
+}
+
+// release gives back a worker.
+func (pw *packetWorkers) release() {
+	<-pw.tokens
+}
+
+// shrink halves the limit if the peak of the busy workers since the last
+// shrink is less than half of it.
+func (pw *packetWorkers) shrink() {
+	peak := int(atomic.SwapInt32(&pw.peak, int32(len(pw.tokens))))
+	limit := int(atomic.LoadInt32(&pw.limit))
+
+	if limit <= pw.min || peak >= limit/2 {
+		return
+	}
+
+	if limit /= 2; limit < pw.min {
+		limit = pw.min
+	}
+	atomic.StoreInt32(&pw.limit, int32(limit))
+}
+
+// run shrinks the limit periodically until done is closed.
+func (pw *packetWorkers) run(clock Clock, done <-chan struct{}) {
+	tick := clock.Tick(packetWorkerShrinkPeriod)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+			pw.shrink()
+		}
+	}
+}
+
+// PacketWorkers returns how many packet workers are busy and the current
+// limit of them, see Config.PacketWorkerMin.
+func (dht *DHT) PacketWorkers() (busy, limit int) {
+	return len(dht.packetWorkers.tokens), int(atomic.LoadInt32(&dht.packetWorkers.limit))
+}
@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"net"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestPacketWorkersBurst(t *testing.T) {
+	config := NewStandardConfig()
+	config.PacketWorkerMin = 4
+	config.PacketWorkerLimit = 16
+
+	dropped := 0
+	config.OnPacketDropped = func(from *net.UDPAddr) {
+		dropped++
+	}
+
+	dht := &DHT{
+		Config:        config,
+		logger:        zap.NewNop(),
+		packetWorkers: newPacketWorkers(config.PacketWorkerMin, config.PacketWorkerLimit),
+		buffers:       newBufferPool(config.ReadBufferSize),
+	}
+	pw := dht.packetWorkers
+
+	// a burst of 20 packets whose handlers are all stuck, the limit
+	// doubles from 4 to 16 and the packets beyond it are dropped.
+	for i := 0; i < 20; i++ {
+		if !pw.acquire() {
+			dht.droppedPackets++
+		}
+	}
+
+	if busy, limit := dht.PacketWorkers(); busy != 16 || limit != 16 || dht.DroppedPackets() != 4 {
+		t.Errorf("busy %d, limit %d, dropped %d", busy, limit, dht.DroppedPackets())
+	}
+
+	raddr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	handle(dht, packet{data: []byte("de"), raddr: raddr})
+	if dropped != 1 {
+		t.Error("packet not dropped at the max limit")
+	}
+
+	// the burst ends, the limit shrinks back to min step by step. The
+	// first check still sees the peak of the burst.
+	for i := 0; i < 16; i++ {
+		pw.release()
+	}
+
+	for _, expected := range []int{16, 16, 8, 4, 4} {
+		if _, limit := dht.PacketWorkers(); limit != expected {
+			t.Errorf("limit %d, should be %d", limit, expected)
+		}
+		pw.shrink()
+	}
+}
+
+func TestPacketWorkersStatic(t *testing.T) {
+	for _, min := range []int{0, 8, 32} {
+		pw := newPacketWorkers(min, 8)
+		if pw.adaptive() {
+			t.Errorf("min %d: adaptive", min)
+		}
+
+		for i := 0; i < 8; i++ {
+			if !pw.acquire() {
+				t.Fatalf("min %d: acquire failed", min)
+			}
+		}
+
+		if pw.acquire() || int(pw.limit) != 8 {
+			t.Errorf("min %d: limit %d", min, pw.limit)
+		}
+	}
+}
@@ -1,35 +1,72 @@
 package dht
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 type Peer interface {
 	IP() net.IP
 	Port() int
 	Token() string
 	CompactIPPortInfo() string
+	LastSeen() time.Time
 }
 
 type peer struct {
-	ip    net.IP
-	port  int
-	token string
+	ip       net.IP
+	port     int
+	token    string
+	lastSeen time.Time
+	// whether the peer announces itself as a seed, see BEP 33
+	seed bool
 }
 
+// NewPeer returns a Peer which is seen now. The DHT creates its peers by
+// Config.Clock instead.
 func NewPeer(ip net.IP, port int, token string) Peer {
+	return newPeerAt(ip, port, token, time.Now())
+}
+
+// newPeerAt returns a Peer which is seen at t.
+func newPeerAt(ip net.IP, port int, token string, t time.Time) Peer {
 	return &peer{
-		ip:    ip,
-		port:  port,
-		token: token,
+		ip:       ip,
+		port:     port,
+		token:    token,
+		lastSeen: t,
 	}
 }
 
+// newAnnouncedPeer returns a Peer which announces at t, as a seed if seed is
+// true.
+func newAnnouncedPeer(ip net.IP, port int, token string, t time.Time, seed bool) Peer {
+	p := newPeerAt(ip, port, token, t).(*peer)
+	p.seed = seed
+	return p
+}
+
+// isSeed returns whether p announces itself as a seed.
+func isSeed(p Peer) bool {
+	pp, ok := p.(*peer)
+	return ok && pp.seed
+}
+
+// NewPeerFromCompactIPPortInfo returns the peer of the compact peer info,
+// which is 6 bytes for an IPv4 peer and 18 bytes for an IPv6 peer.
 func NewPeerFromCompactIPPortInfo(compactInfo, token string) (Peer, error) {
+	return newPeerFromCompactIPPortInfoAt(compactInfo, token, time.Now())
+}
+
+// newPeerFromCompactIPPortInfoAt returns the peer of the compact peer info,
+// which is seen at t.
+func newPeerFromCompactIPPortInfoAt(compactInfo, token string, t time.Time) (Peer, error) {
 	ip, port, err := decodeCompactIPPortInfo(compactInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewPeer(ip, port, token), nil
+	return newPeerAt(ip, port, token, t), nil
 }
 
 func (p *peer) IP() net.IP {
@@ -44,6 +81,12 @@ func (p *peer) Token() string {
 	return p.token
 }
 
+// LastSeen returns when the peer is announced or returned by a get_peers
+// response.
+func (p *peer) LastSeen() time.Time {
+	return p.lastSeen
+}
+
 // CompactIPPortInfo returns "Compact node info".
 // See http://www.bittorrent.org/beps/bep_0005.html.
 func (p *peer) CompactIPPortInfo() string {
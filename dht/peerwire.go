@@ -8,8 +8,11 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 const (
@@ -32,24 +35,40 @@ const (
 	HANDSHAKE = 0
 )
 
+// extHandshakeReqq is the reqq of our extension handshake, i.e. how many
+// outstanding requests we queue, which is the default of libtorrent.
+const extHandshakeReqq = 250
+
+// errNoUTMetadata is the error that the extension handshake of a peer
+// doesn't advertise ut_metadata, so it can't send the metadata.
+var errNoUTMetadata = errors.New("ut_metadata not supported")
+
+// fetchRetryDelay is how long Wire waits before it retries a failed fetch
+// with another peer.
+const fetchRetryDelay = time.Second
+
 var handshakePrefix = []byte{
 	19, 66, 105, 116, 84, 111, 114, 114, 101, 110, 116, 32, 112, 114,
 	111, 116, 111, 99, 111, 108, 0, 0, 0, 0, 0, 16, 0, 1,
 }
 
 // read reads size-length bytes from conn to data.
-func read(conn *net.TCPConn, size int, data *bytes.Buffer) error {
+func read(conn net.Conn, size int, data *bytes.Buffer) error {
 	conn.SetReadDeadline(time.Now().Add(time.Second * 15))
 
 	n, err := io.CopyN(data, conn, int64(size))
-	if err != nil || n != int64(size) {
+	if err != nil {
+		return err
+	}
+
+	if n != int64(size) {
 		return errors.New("read error")
 	}
 	return nil
 }
 
 // readMessage gets a message from the tcp connection.
-func readMessage(conn *net.TCPConn, data *bytes.Buffer) (
+func readMessage(conn net.Conn, data *bytes.Buffer) (
 	length int, err error) {
 
 	if err = read(conn, 4, data); err != nil {
@@ -68,7 +87,7 @@ func readMessage(conn *net.TCPConn, data *bytes.Buffer) (
 }
 
 // sendMessage sends data to the connection.
-func sendMessage(conn *net.TCPConn, data []byte) error {
+func sendMessage(conn net.Conn, data []byte) error {
 	length := int32(len(data))
 
 	buffer := bytes.NewBuffer(nil)
@@ -80,7 +99,7 @@ func sendMessage(conn *net.TCPConn, data []byte) error {
 }
 
 // sendHandshake sends handshake message to conn.
-func sendHandshake(conn *net.TCPConn, infoHash, peerID []byte) error {
+func sendHandshake(conn net.Conn, infoHash, peerID []byte) error {
 	data := make([]byte, 68)
 	copy(data[:28], handshakePrefix)
 	copy(data[28:48], infoHash)
@@ -99,22 +118,41 @@ func onHandshake(data []byte) (err error) {
 	return
 }
 
-// sendExtHandshake requests for the ut_metadata and metadata_size.
-func sendExtHandshake(conn *net.TCPConn) error {
-	data := append(
-		[]byte{EXTENDED, HANDSHAKE},
-		Encode(map[string]interface{}{
-			"m": map[string]interface{}{"ut_metadata": 1},
-		})...,
-	)
+// sendExtHandshake sends the extension handshake, which advertises
+// ut_metadata and the client version, and tells the peer the ip we see it at
+// if ip is valid.
+func sendExtHandshake(conn net.Conn, version string, ip net.IP) error {
+	handshake := map[string]interface{}{
+		"m":    map[string]interface{}{"ut_metadata": 1},
+		"reqq": extHandshakeReqq,
+	}
+	if version != "" {
+		handshake["v"] = version
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		handshake["yourip"] = string(ip4)
+	} else if len(ip) == net.IPv6len {
+		handshake["yourip"] = string(ip)
+	}
 
+	data := append([]byte{EXTENDED, HANDSHAKE}, Encode(handshake)...)
 	return sendMessage(conn, data)
 }
 
-// getUTMetaSize returns the ut_metadata and metadata_size.
-func getUTMetaSize(data []byte) (
-	utMetadata int, metadataSize int, err error) {
+// extHandshake is the extension handshake of a peer.
+type extHandshake struct {
+	utMetadata   int
+	metadataSize int
+	// the client version of the peer, empty if it's not told
+	client string
+	// the ip the peer sees us at, nil if it's not told
+	yourIP net.IP
+}
 
+// parseExtHandshake parses the extension handshake of a peer. It returns
+// errNoUTMetadata if the peer doesn't support ut_metadata, and
+// ErrSizeLimitExceeded if metadata_size is greater than maxSize.
+func parseExtHandshake(data []byte, maxSize int) (h extHandshake, err error) {
 	v, err := Decode(data)
 	if err != nil {
 		return
@@ -126,28 +164,40 @@ func getUTMetaSize(data []byte) (
 		return
 	}
 
-	if err = ParseKeys(
-		dict, [][]string{{"metadata_size", "int"}, {"m", "map"}}); err != nil {
-		return
+	// They're optional and only informative, so the invalid ones are
+	// ignored.
+	if client, ok := dict["v"].(string); ok {
+		h.client = client
+	}
+	if yourIP, ok := dict["yourip"].(string); ok &&
+		(len(yourIP) == net.IPv4len || len(yourIP) == net.IPv6len) {
+		h.yourIP = net.IP(yourIP)
 	}
 
-	m := dict["m"].(map[string]interface{})
-	if err = ParseKey(m, "ut_metadata", "int"); err != nil {
+	m, ok := dict["m"].(map[string]interface{})
+	if !ok {
+		err = errNoUTMetadata
+		return
+	}
+	if h.utMetadata, ok = m["ut_metadata"].(int); !ok || h.utMetadata == 0 {
+		err = errNoUTMetadata
 		return
 	}
 
-	utMetadata = m["ut_metadata"].(int)
-	metadataSize = dict["metadata_size"].(int)
+	if err = ParseKey(dict, "metadata_size", "int"); err != nil {
+		return
+	}
+	h.metadataSize = dict["metadata_size"].(int)
 
-	if metadataSize > MaxMetadataSize {
-		err = errors.New("metadata_size too long")
+	if h.metadataSize > maxSize {
+		err = ErrSizeLimitExceeded
 	}
 	return
 }
 
 // Request represents the request context.
 type Request struct {
-	InfoHash []byte
+	InfoHash InfoHash
 	IP       string
 	Port     int
 }
@@ -155,16 +205,140 @@ type Request struct {
 // Response contains the request context and the metadata info.
 type Response struct {
 	Request
+	// the raw bencoded info dict, exactly as the peer sent it. Its sha1 is
+	// verified to equal the infohash, so it can be archived and parsed again
+	// later. The slice is not reused by the wire, it's owned by the receiver
 	MetadataInfo []byte
+	// the metadata_size advertised by the peer, i.e. len(MetadataInfo)
+	MetadataSize int
+	// how many pieces the metadata is fetched in
+	Pieces int
+}
+
+// WireConfig represents the configure of wire.
+type WireConfig struct {
+	// the blacklist size
+	BlackListSize int
+	// the max requests it can buffers
+	RequestQueueSize int
+	// the max goroutine downloading workers
+	WorkerQueueSize int
+	// the transport connecting to peers, TCP by default. There's no built-in
+	// uTP transport, see WireTransport
+	Transport WireTransport
+	// EncryptionDisabled, EncryptionPrefer or EncryptionRequire
+	EncryptionPolicy int
+	// whether to redial in plaintext when the MSE handshake fails. It's
+	// ignored when EncryptionPolicy is EncryptionRequire
+	PlaintextFallback bool
+	// the max metadata_size it accepts, no greater than MaxMetadataSize
+	MaxMetadataSize int
+	// how many other peers announcing the same infohash are tried after a
+	// fetch fails
+	MaxFetchRetries int
+	// callback when the fetch of an infohash fails after all the sources are
+	// tried, tried is how many peers are tried
+	OnFetchFailed func(infoHash InfoHash, tried int)
+	// the failure rate of the fetched infohashes over which the circuit
+	// breaker opens and Request fails with ErrWireOverloaded. The rate is
+	// checked every BreakerWindow finished infohashes. No breaker if it's 0
+	BreakerFailureRate float64
+	// how many finished infohashes the failure rate is computed over
+	BreakerWindow int
+	// how long the breaker stays open
+	BreakerCooldown time.Duration
+	// the client version sent as v in the extension handshake, not sent if
+	// it's empty
+	ClientVersion string
+	// how many infohashes whose fetches failed are held, so the peers
+	// announcing them later retry the fetches. No retry if it's 0
+	RetryQueueSize int
+	// how long after a failure the fetch of the infohash isn't retried, the
+	// new peers meanwhile are held until then. It doubles after every
+	// failure of the infohash
+	RetryBackoff time.Duration
+	// how long after the first failure an infohash is given up
+	RetryLifetime time.Duration
+}
+
+// NewWireConfig returns a WireConfig pointer with default values.
+func NewWireConfig() *WireConfig {
+	return &WireConfig{
+		BlackListSize:     65536,
+		RequestQueueSize:  1024,
+		WorkerQueueSize:   256,
+		Transport:         NewTCPTransport(),
+		EncryptionPolicy:  EncryptionDisabled,
+		PlaintextFallback: true,
+		MaxMetadataSize:   DefaultMaxInfoSize,
+		MaxFetchRetries:   2,
+		BreakerWindow:     256,
+		BreakerCooldown:   time.Second * 30,
+		ClientVersion:     "dht-crawler",
+		RetryQueueSize:    0,
+		RetryBackoff:      time.Second * 30,
+		RetryLifetime:     time.Minute * 10,
+	}
+}
+
+// WireStats represents the statistics of a Wire.
+type WireStats struct {
+	// how many requests are enqueued
+	Requests uint64
+	// how many metadata are fetched and verified
+	Fetched uint64
+	// how many peers fail to connect
+	DialFailures uint64
+	// how many fetches time out after the handshakes
+	Timeouts uint64
+	// how many BitTorrent or extension handshakes fail
+	HandshakeFailures uint64
+	// how many fetched metadata don't match the infohash
+	HashMismatches uint64
+	// how many metadata pieces are received and accepted
+	Pieces uint64
+	// how many pieces are beyond metadata_size or have wrong lengths
+	InvalidPieces uint64
+	// how many fetches fail because the peer rejects a piece
+	Rejects uint64
+	// how many infohashes fail to fetch after all the sources are tried
+	Failed uint64
+	// how many requests are rejected because the queue is full or the
+	// circuit breaker is open
+	Shed uint64
+	// how many times the circuit breaker opens
+	BreakerTrips uint64
+	// how many requests held by the retry queue are tried after the backoff
+	Retried uint64
 }
 
-// Wire represents the wire protocol.
+// Wire fetches the metadata of torrents from the peers announcing them, with
+// the extension protocol(see http://www.bittorrent.org/beps/bep_0010.html)
+// and the metadata extension(see
+// http://www.bittorrent.org/beps/bep_0009.html). Create it by NewWire or
+// NewWireWithConfig, start it by Run, then push the peers with Request and
+// receive the verified metadata from the chan returned by Response.
 type Wire struct {
-	blackList    *blackList
-	queue        *syncedMap
-	requests     chan Request
-	responses    chan Response
-	workerTokens chan struct{}
+	// the 64-bit atomic fields are kept first for alignment.
+	stats             WireStats
+	logger            *zap.Logger
+	transport         WireTransport
+	encryptionPolicy  int
+	plaintextFallback bool
+	maxMetadataSize   int
+	blackList         *blackList
+	jobs              *fetchJobs
+	maxFetchRetries   int
+	onFetchFailed     func(infoHash InfoHash, tried int)
+	breaker           *circuitBreaker
+	clientVersion     string
+	ipVoter           *ipVoter
+	retries           *retryQueue
+	requests          chan Request
+	responses         chan Response
+	workerTokens      chan struct{}
+	done              chan struct{}
+	closeOnce         sync.Once
 }
 
 // NewWire returns a Wire pointer.
@@ -172,97 +346,286 @@ type Wire struct {
 //   - requestQueueSize: the max requests it can buffers
 //   - workerQueueSize: the max goroutine downloading workers
 func NewWire(blackListSize, requestQueueSize, workerQueueSize int) *Wire {
+	config := NewWireConfig()
+	config.BlackListSize = blackListSize
+	config.RequestQueueSize = requestQueueSize
+	config.WorkerQueueSize = workerQueueSize
+
+	return NewWireWithConfig(config)
+}
+
+// NewWireWithConfig returns a Wire pointer. If config is nil, then config
+// will be set to the default config.
+func NewWireWithConfig(config *WireConfig) *Wire {
+	if config == nil {
+		config = NewWireConfig()
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		transport = NewTCPTransport()
+	}
+
+	maxMetadataSize := config.MaxMetadataSize
+	if maxMetadataSize <= 0 || maxMetadataSize > MaxMetadataSize {
+		maxMetadataSize = MaxMetadataSize
+	}
+
+	breaker := newCircuitBreaker(
+		config.BreakerFailureRate, config.BreakerWindow, config.BreakerCooldown)
+
+	var retries *retryQueue
+	if config.RetryQueueSize > 0 {
+		retries = newRetryQueue(
+			config.RetryQueueSize, config.RetryBackoff, config.RetryLifetime)
+	}
+
 	return &Wire{
-		blackList:    newBlackList(blackListSize),
-		queue:        newSyncedMap(),
-		requests:     make(chan Request, requestQueueSize),
-		responses:    make(chan Response, 1024),
-		workerTokens: make(chan struct{}, workerQueueSize),
+		logger:            zap.NewNop(),
+		transport:         transport,
+		encryptionPolicy:  config.EncryptionPolicy,
+		plaintextFallback: config.PlaintextFallback,
+		maxMetadataSize:   maxMetadataSize,
+		blackList:         newBlackList(config.BlackListSize, realClock{}),
+		jobs:              newFetchJobs(config.MaxFetchRetries + 1),
+		maxFetchRetries:   config.MaxFetchRetries,
+		onFetchFailed:     config.OnFetchFailed,
+		breaker:           breaker,
+		clientVersion:     config.ClientVersion,
+		ipVoter:           newIPVoter(),
+		retries:           retries,
+		requests:          make(chan Request, config.RequestQueueSize),
+		responses:         make(chan Response, 1024),
+		workerTokens:      make(chan struct{}, config.WorkerQueueSize),
+		done:              make(chan struct{}),
+	}
+}
+
+// WithLogger sets the logger which logs the metadata fetching and returns
+// the wire. A nil logger disables logging.
+func (wire *Wire) WithLogger(logger *zap.Logger) *Wire {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	wire.logger = logger
+	return wire
+}
+
+// Stats returns the statistics of the wire. The counters are read
+// atomically one by one, so it never blocks the fetches.
+func (wire *Wire) Stats() WireStats {
+	return WireStats{
+		Requests:          atomic.LoadUint64(&wire.stats.Requests),
+		Fetched:           atomic.LoadUint64(&wire.stats.Fetched),
+		DialFailures:      atomic.LoadUint64(&wire.stats.DialFailures),
+		Timeouts:          atomic.LoadUint64(&wire.stats.Timeouts),
+		HandshakeFailures: atomic.LoadUint64(&wire.stats.HandshakeFailures),
+		HashMismatches:    atomic.LoadUint64(&wire.stats.HashMismatches),
+		Pieces:            atomic.LoadUint64(&wire.stats.Pieces),
+		InvalidPieces:     atomic.LoadUint64(&wire.stats.InvalidPieces),
+		Rejects:           atomic.LoadUint64(&wire.stats.Rejects),
+		Failed:            atomic.LoadUint64(&wire.stats.Failed),
+		Shed:              atomic.LoadUint64(&wire.stats.Shed),
+		BreakerTrips:      atomic.LoadUint64(&wire.stats.BreakerTrips),
+		Retried:           atomic.LoadUint64(&wire.stats.Retried),
 	}
 }
 
-// Request pushes the request to the queue.
-func (wire *Wire) Request(infoHash []byte, ip string, port int) {
-	wire.requests <- Request{InfoHash: infoHash, IP: ip, Port: port}
+// isTimeout returns whether err is a timeout error.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// Request pushes the request to fetch the metadata of infoHash from the peer
+// at ip:port to the queue. The requests of an infohash being fetched are used
+// as the fallback sources of the fetch. It never blocks, it returns
+// ErrWireQueueFull if the queue is full, or ErrWireOverloaded if the circuit
+// breaker is open(see WireConfig.BreakerFailureRate), so the caller can back
+// off. It returns ErrInvalidInfoHash if infoHash isn't set.
+func (wire *Wire) Request(infoHash InfoHash, ip string, port int) error {
+	if !infoHash.Valid() {
+		return ErrInvalidInfoHash
+	}
+
+	if !wire.breaker.allow() {
+		atomic.AddUint64(&wire.stats.Shed, 1)
+		return ErrWireOverloaded
+	}
+
+	select {
+	case wire.requests <- Request{InfoHash: infoHash, IP: ip, Port: port}:
+		atomic.AddUint64(&wire.stats.Requests, 1)
+		return nil
+	default:
+		atomic.AddUint64(&wire.stats.Shed, 1)
+		return ErrWireQueueFull
+	}
 }
 
-// Response returns a chan of Response.
+// Response returns a chan of Response. Every fetched metadata is sent to it
+// once, and the fetches block while it's full, so it must be consumed.
 func (wire *Wire) Response() <-chan Response {
 	return wire.responses
 }
 
-// isDone returns whether the wire get all pieces of the metadata info.
-func (wire *Wire) isDone(pieces [][]byte) bool {
-	for _, piece := range pieces {
-		if len(piece) == 0 {
-			return false
-		}
+// requestPiece requests piece of the metadata by the extended message id
+// utMetadata.
+func requestPiece(conn net.Conn, utMetadata int, piece int) error {
+	return sendMessage(conn, append(
+		[]byte{EXTENDED, byte(utMetadata)},
+		Encode(map[string]interface{}{
+			"msg_type": REQUEST,
+			"piece":    piece,
+		})...,
+	))
+}
+
+// dial connects to the peer. If encryption is enabled, the MSE handshake is
+// performed on the connection.
+func (wire *Wire) dial(address string, infoHash []byte) (net.Conn, error) {
+	conn, err := wire.transport.Dial(address, time.Second*15)
+	if err != nil || wire.encryptionPolicy == EncryptionDisabled {
+		return conn, err
+	}
+
+	encrypted, err := mseHandshake(conn, infoHash, wire.encryptionPolicy)
+	if err == nil {
+		return encrypted, nil
+	}
+	conn.Close()
+
+	if wire.encryptionPolicy == EncryptionRequire || !wire.plaintextFallback {
+		return nil, err
 	}
-	return true
+	return wire.transport.Dial(address, time.Second*15)
 }
 
-func (wire *Wire) requestPieces(
-	conn *net.TCPConn, utMetadata int, metadataSize int, piecesNum int) {
+// fetchMetadata fetchs medata info accroding to infohash from dht. It
+// returns whether the metadata is fetched.
+func (wire *Wire) fetchMetadata(r Request) bool {
+	address := genAddress(r.IP, r.Port)
 
-	buffer := make([]byte, 1024)
-	for i := 0; i < piecesNum; i++ {
-		buffer[0] = EXTENDED
-		buffer[1] = byte(utMetadata)
+	logger := wire.logger.With(
+		zap.String("infohash", r.InfoHash.Hex()),
+		zap.String("peer", address),
+	)
+	logger.Debug("fetching metadata")
 
-		msg := Encode(map[string]interface{}{
-			"msg_type": REQUEST,
-			"piece":    i,
-		})
+	return wire.fetch(r, address, logger)
+}
 
-		length := len(msg) + 2
-		copy(buffer[2:length], msg)
+// recordFetch counts the result of a job to the circuit breaker.
+func (wire *Wire) recordFetch(ok bool) {
+	if wire.breaker.record(ok) {
+		atomic.AddUint64(&wire.stats.BreakerTrips, 1)
+		wire.logger.Warn("wire circuit breaker opens, requests are shed")
+	}
+}
 
-		sendMessage(conn, buffer[:length])
+// runJob fetches the metadata of the job from its sources in turn. After a
+// failure it waits fetchRetryDelay and tries the next source, at most
+// MaxFetchRetries times. OnFetchFailed is called once all the tries fail,
+// and the infohash is put to the retry queue.
+func (wire *Wire) runJob(job *fetchJob) {
+	defer wire.jobs.done(job)
+
+	for i := 0; i <= wire.maxFetchRetries; i++ {
+		if i > 0 {
+			time.Sleep(fetchRetryDelay)
+		}
+
+		r, ok := wire.jobs.next(job)
+		if !ok {
+			break
+		}
+
+		if wire.blackList.in(r.IP, r.Port) {
+			continue
+		}
+
+		if wire.fetchMetadata(r) {
+			wire.recordFetch(true)
+			if wire.retries != nil {
+				wire.retries.succeed(job.infoHash)
+			}
+			return
+		}
+	}
+
+	atomic.AddUint64(&wire.stats.Failed, 1)
+	wire.recordFetch(false)
+	if wire.retries != nil {
+		wire.retries.fail(job.infoHash, time.Now())
+	}
+
+	wire.logger.Debug("metadata fetch failed",
+		zap.String("infohash", job.infoHash.Hex()),
+		zap.Int("tried", job.tried))
+
+	if wire.onFetchFailed != nil {
+		wire.onFetchFailed(job.infoHash, job.tried)
 	}
-	buffer = nil
 }
 
-// fetchMetadata fetchs medata info accroding to infohash from dht.
-func (wire *Wire) fetchMetadata(r Request) {
+// fetch fetches the metadata over a new connection to address.
+// The pieces are requested one by one, the next after the previous arrives.
+// The fetch fails if a piece is beyond metadata_size or has a wrong length,
+// or the peer rejects a piece, then the next source is tried by runJob. The
+// metadata is assembled and verified only after all the pieces arrive. It
+// returns whether the metadata is fetched.
+func (wire *Wire) fetch(r Request, address string, logger *zap.Logger) (ok bool) {
 	var (
 		length       int
 		msgType      byte
-		piecesNum    int
-		pieces       [][]byte
+		pieces       *metadataPieces
 		utMetadata   int
 		metadataSize int
 	)
 
 	defer func() {
-		pieces = nil
 		recover()
 	}()
 
-	infoHash := r.InfoHash
-	address := genAddress(r.IP, r.Port)
+	infoHash := r.InfoHash[:]
+	data := bytes.NewBuffer(nil)
+	data.Grow(BLOCK)
 
-	dial, err := net.DialTimeout("tcp", address, time.Second*15)
+	conn, err := wire.dial(address, infoHash)
 	if err != nil {
+		logger.Debug("dial failed", zap.Error(err))
+		atomic.AddUint64(&wire.stats.DialFailures, 1)
 		wire.blackList.insert(r.IP, r.Port)
 		return
 	}
-	conn := dial.(*net.TCPConn)
-	conn.SetLinger(0)
 	defer conn.Close()
 
-	data := bytes.NewBuffer(nil)
-	data.Grow(BLOCK)
-
-	if sendHandshake(conn, infoHash, []byte(randomString(20))) != nil ||
-		read(conn, 68, data) != nil ||
-		onHandshake(data.Next(68)) != nil ||
-		sendExtHandshake(conn) != nil {
+	err = sendHandshake(conn, infoHash, []byte(randomString(20)))
+	if err == nil {
+		err = read(conn, 68, data)
+	}
+	if err == nil {
+		err = onHandshake(data.Next(68))
+	}
+	if err == nil {
+		err = sendExtHandshake(conn, wire.clientVersion, net.ParseIP(r.IP))
+	}
+	if err != nil {
+		logger.Debug("handshake failed", zap.Error(err))
+		atomic.AddUint64(&wire.stats.HandshakeFailures, 1)
 		return
 	}
 
 	for {
 		length, err = readMessage(conn, data)
 		if err != nil {
+			if isTimeout(err) {
+				logger.Debug("fetch timeout")
+				atomic.AddUint64(&wire.stats.Timeouts, 1)
+			} else {
+				logger.Debug("read message failed", zap.Error(err))
+			}
 			return
 		}
 
@@ -292,19 +655,33 @@ func (wire *Wire) fetchMetadata(r Request) {
 					return
 				}
 
-				utMetadata, metadataSize, err = getUTMetaSize(payload)
+				h, err := parseExtHandshake(payload, wire.maxMetadataSize)
+				if h.yourIP != nil {
+					wire.ipVoter.vote(h.yourIP)
+				}
+				if err == errNoUTMetadata {
+					// It never serves metadata, so skip it from now on.
+					logger.Debug("ut_metadata not supported",
+						zap.String("client", h.client))
+					atomic.AddUint64(&wire.stats.HandshakeFailures, 1)
+					wire.blackList.insert(r.IP, r.Port)
+					return
+				}
+				if err == nil && h.metadataSize <= 0 {
+					err = errors.New("invalid metadata_size")
+				}
 				if err != nil {
+					logger.Debug("invalid extension handshake",
+						zap.String("client", h.client), zap.Error(err))
+					atomic.AddUint64(&wire.stats.HandshakeFailures, 1)
 					return
 				}
+				utMetadata, metadataSize = h.utMetadata, h.metadataSize
 
-				piecesNum = metadataSize / BLOCK
-				if metadataSize%BLOCK != 0 {
-					piecesNum++
+				pieces = newMetadataPieces(metadataSize)
+				if requestPiece(conn, utMetadata, pieces.next()) != nil {
+					return
 				}
-
-				pieces = make([][]byte, piecesNum)
-				go wire.requestPieces(conn, utMetadata, metadataSize, piecesNum)
-
 				continue
 			}
 
@@ -324,62 +701,134 @@ func (wire *Wire) fetchMetadata(r Request) {
 				return
 			}
 
-			if dict["msg_type"].(int) != DATA {
+			piece := dict["piece"].(int)
+
+			switch dict["msg_type"].(int) {
+			case REJECT:
+				if piece == pieces.next() {
+					logger.Debug("piece rejected", zap.Int("piece", piece))
+					atomic.AddUint64(&wire.stats.Rejects, 1)
+					return
+				}
+				continue
+			case DATA:
+			default:
 				continue
 			}
 
-			piece := dict["piece"].(int)
-			pieceLen := length - 2 - index
-
-			if (piece != piecesNum-1 && pieceLen != BLOCK) ||
-				(piece == piecesNum-1 && pieceLen != metadataSize%BLOCK) {
+			if totalSize, has := dict["total_size"].(int); has &&
+				totalSize != metadataSize {
 				return
 			}
 
-			pieces[piece] = payload[index:]
-
-			if wire.isDone(pieces) {
-				metadataInfo := bytes.Join(pieces, nil)
+			if err = pieces.put(piece, payload[index:]); err != nil {
+				logger.Debug("invalid piece", zap.Int("piece", piece),
+					zap.Error(err))
+				atomic.AddUint64(&wire.stats.InvalidPieces, 1)
+				return
+			}
+			atomic.AddUint64(&wire.stats.Pieces, 1)
 
-				info := sha1.Sum(metadataInfo)
-				if !bytes.Equal(infoHash, info[:]) {
+			if !pieces.done() {
+				if requestPiece(conn, utMetadata, pieces.next()) != nil {
 					return
 				}
+				continue
+			}
 
-				wire.responses <- Response{
-					Request:      r,
-					MetadataInfo: metadataInfo,
-				}
+			metadataInfo := pieces.join()
+
+			info := sha1.Sum(metadataInfo)
+			if !bytes.Equal(infoHash, info[:]) {
+				logger.Warn("metadata hash mismatch")
+				atomic.AddUint64(&wire.stats.HashMismatches, 1)
 				return
 			}
+
+			logger.Debug("metadata fetched", zap.Int("size", metadataSize))
+			atomic.AddUint64(&wire.stats.Fetched, 1)
+
+			wire.responses <- Response{
+				Request:      r,
+				MetadataInfo: metadataInfo,
+				MetadataSize: metadataSize,
+				Pieces:       pieces.num(),
+			}
+			return true
 		default:
 			data.Reset()
 		}
 	}
 }
 
-// Run starts the peer wire protocol.
+// Run starts the peer wire protocol. The requests of an infohash being
+// fetched don't start new fetches, their peers are tried if the running
+// fetch fails. The requests of an infohash backing off in the retry queue
+// are held, and tried once the backoff ends. It blocks until Close is
+// called.
 func (wire *Wire) Run() {
-	go wire.blackList.clear()
-
-	for r := range wire.requests {
-		wire.workerTokens <- struct{}{}
+	go wire.blackList.clear(wire.done)
 
-		go func(r Request) {
-			defer func() {
-				<-wire.workerTokens
-			}()
+	var due <-chan time.Time
+	if wire.retries != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 
-			key := strings.Join([]string{
-				string(r.InfoHash), genAddress(r.IP, r.Port),
-			}, ":")
+		due = ticker.C
+	}
 
-			if len(r.InfoHash) != 20 || wire.blackList.in(r.IP, r.Port) ||
-				wire.queue.Has(key) {
-				return
+	for {
+		select {
+		case <-wire.done:
+			return
+		case r := <-wire.requests:
+			if wire.retries != nil && wire.retries.hold(r, time.Now()) {
+				continue
+			}
+			wire.start(r)
+		case now := <-due:
+			for _, r := range wire.retries.due(now) {
+				atomic.AddUint64(&wire.stats.Retried, 1)
+				wire.start(r)
 			}
+		}
+	}
+}
 
-			wire.fetchMetadata(r)
-		}(r)
+// Close stops Run and the goroutine cleaning the blacklist. The running
+// fetches finish on their own. It's safe to call Close more than once.
+func (wire *Wire) Close() error {
+	wire.closeOnce.Do(func() {
+		close(wire.done)
+	})
+	return nil
+}
+
+// start starts the job of the infohash of r, or adds r to the sources of the
+// running job. It blocks while WorkerQueueSize jobs are running, or until
+// the wire is closed.
+func (wire *Wire) start(r Request) {
+	if !r.InfoHash.Valid() || wire.blackList.in(r.IP, r.Port) {
+		return
 	}
+
+	job, isNew := wire.jobs.add(r)
+	if !isNew {
+		return
+	}
+
+	select {
+	case wire.workerTokens <- struct{}{}:
+	case <-wire.done:
+		wire.jobs.done(job)
+		return
+	}
+
+	go func(job *fetchJob) {
+		defer func() {
+			<-wire.workerTokens
+		}()
+
+		wire.runJob(job)
+	}(job)
 }
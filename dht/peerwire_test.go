@@ -0,0 +1,344 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dataMessage returns the payload of the data message of piece.
+func dataMessage(info []byte, piece int) []byte {
+	end := (piece + 1) * BLOCK
+	if end > len(info) {
+		end = len(info)
+	}
+
+	return append([]byte(Encode(map[string]interface{}{
+		"msg_type":   DATA,
+		"piece":      piece,
+		"total_size": len(info),
+	})), info[piece*BLOCK:end]...)
+}
+
+// servePeer serves the metadata info over conn like a peer supporting
+// BEP 9, until conn is closed. reply returns the payload replying the
+// request of piece, it's dataMessage if reply is nil.
+func servePeer(conn net.Conn, info []byte, reply func(piece int) []byte) {
+	defer conn.Close()
+
+	data := bytes.NewBuffer(nil)
+	if read(conn, 68, data) != nil {
+		return
+	}
+	handshake := append([]byte(nil), data.Next(68)...)
+	copy(handshake[48:], randomString(20))
+	if _, err := conn.Write(handshake); err != nil {
+		return
+	}
+
+	for {
+		length, err := readMessage(conn, data)
+		if err != nil || length < 2 {
+			return
+		}
+		msg := data.Next(length)
+
+		if msg[1] == HANDSHAKE {
+			sendMessage(conn, append([]byte{EXTENDED, HANDSHAKE},
+				Encode(map[string]interface{}{
+					"m":             map[string]interface{}{"ut_metadata": 2},
+					"metadata_size": len(info),
+					"yourip":        "\x05\x06\x07\x08",
+				})...))
+			continue
+		}
+
+		v, err := Decode(msg[2:])
+		if err != nil {
+			return
+		}
+		piece := v.(map[string]interface{})["piece"].(int)
+
+		payload := dataMessage(info, piece)
+		if reply != nil {
+			payload = reply(piece)
+		}
+		sendMessage(conn, append([]byte{EXTENDED, 1}, payload...))
+	}
+}
+
+// newPeerWire returns a wire whose every dial connects to a peer serving
+// info, see servePeer.
+func newPeerWire(info []byte, reply func(piece int) []byte) *Wire {
+	config := NewWireConfig()
+	config.Transport = WireTransportFunc(
+		func(address string, timeout time.Duration) (net.Conn, error) {
+			client, server := net.Pipe()
+			go servePeer(server, info, reply)
+			return client, nil
+		})
+	return NewWireWithConfig(config)
+}
+
+func TestWireStats(t *testing.T) {
+	config := NewWireConfig()
+	config.Transport = WireTransportFunc(
+		func(address string, timeout time.Duration) (net.Conn, error) {
+			return nil, errors.New("refused")
+		})
+
+	wire := NewWireWithConfig(config)
+	if wire.Request(rawInfoHash(randomString(20)), "1.1.1.1", 6881) != nil {
+		t.Fatal("request not queued")
+	}
+
+	wire.fetchMetadata(<-wire.requests)
+
+	stats := wire.Stats()
+	if stats.Requests != 1 || stats.DialFailures != 1 || stats.Fetched != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWireFetchRetry(t *testing.T) {
+	dialed := make(chan string, 3)
+
+	config := NewWireConfig()
+	config.MaxFetchRetries = 1
+	config.Transport = WireTransportFunc(
+		func(address string, timeout time.Duration) (net.Conn, error) {
+			dialed <- address
+			return nil, errors.New("refused")
+		})
+
+	var failedTried int
+	config.OnFetchFailed = func(infoHash InfoHash, tried int) {
+		failedTried = tried
+	}
+
+	wire := NewWireWithConfig(config)
+	infoHash := rawInfoHash(randomString(20))
+
+	job, _ := wire.jobs.add(Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 6881})
+	wire.jobs.add(Request{InfoHash: infoHash, IP: "2.2.2.2", Port: 6881})
+	wire.jobs.add(Request{InfoHash: infoHash, IP: "3.3.3.3", Port: 6881})
+
+	wire.runJob(job)
+	close(dialed)
+
+	addresses := make([]string, 0, 3)
+	for address := range dialed {
+		addresses = append(addresses, address)
+	}
+
+	if len(addresses) != 2 || addresses[0] != "1.1.1.1:6881" ||
+		addresses[1] != "2.2.2.2:6881" {
+		t.Errorf("unexpected dialed peers %v", addresses)
+	}
+
+	if failedTried != 2 || wire.Stats().Failed != 1 || wire.jobs.len() != 0 {
+		t.Errorf("unexpected failure, tried %d, stats %+v",
+			failedTried, wire.Stats())
+	}
+}
+
+func TestFetchJobs(t *testing.T) {
+	jobs := newFetchJobs(2)
+	infoHash := rawInfoHash(randomString(20))
+
+	cases := []struct {
+		in    Request
+		isNew bool
+	}{
+		{Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 1}, true},
+		{Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 1}, false},
+		{Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 2}, false},
+		// beyond maxSources.
+		{Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 3}, false},
+	}
+
+	var job *fetchJob
+	for _, c := range cases {
+		j, isNew := jobs.add(c.in)
+		if isNew != c.isNew {
+			t.Errorf("add %v: isNew %v, want %v", c.in, isNew, c.isNew)
+		}
+		job = j
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := jobs.next(job); !ok {
+			t.Fatal("source missing")
+		}
+	}
+
+	if _, ok := jobs.next(job); ok || job.tried != 2 || len(job.known) != 2 {
+		t.Error("duplicated or extra source")
+	}
+
+	jobs.done(job)
+	if jobs.len() != 0 {
+		t.Fail()
+	}
+}
+
+func TestWireRawMetadata(t *testing.T) {
+	// the info dict spans two pieces.
+	info := []byte("d4:name20000:" + strings.Repeat("a", 20000) + "e")
+	infoHash := sha1.Sum(info)
+
+	wire := newPeerWire(info, nil)
+	if !wire.fetchMetadata(Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 6881}) {
+		t.Fatal("fetch failed")
+	}
+
+	resp := <-wire.Response()
+	if !bytes.Equal(resp.MetadataInfo, info) {
+		t.Error("metadata info is modified")
+	}
+
+	bt, err := ParseMetadata(resp.InfoHash[:], resp.MetadataInfo, 0)
+	if err != nil || !bytes.Equal(bt.RawInfo, info) {
+		t.Error(err)
+	}
+
+	if ip, votes := wire.ExternalIP(); !ip.Equal(net.IPv4(5, 6, 7, 8)) || votes != 1 {
+		t.Errorf("external ip %v with %d votes", ip, votes)
+	}
+}
+
+func TestParseExtHandshake(t *testing.T) {
+	cases := []struct {
+		in  map[string]interface{}
+		out extHandshake
+		err error
+	}{
+		{map[string]interface{}{
+			"m":             map[string]interface{}{"ut_metadata": 2},
+			"metadata_size": 100,
+			"v":             "client 1.0",
+			"yourip":        "\x01\x02\x03\x04",
+		}, extHandshake{2, 100, "client 1.0", net.IP{1, 2, 3, 4}}, nil},
+		{map[string]interface{}{
+			"m":             map[string]interface{}{"ut_metadata": 2},
+			"metadata_size": 100,
+			"yourip":        "bad",
+		}, extHandshake{utMetadata: 2, metadataSize: 100}, nil},
+		{map[string]interface{}{
+			"m":      map[string]interface{}{"ut_pex": 1},
+			"v":      "client 1.0",
+			"yourip": "\x01\x02\x03\x04",
+		}, extHandshake{client: "client 1.0", yourIP: net.IP{1, 2, 3, 4}},
+			errNoUTMetadata},
+		{map[string]interface{}{"metadata_size": 100}, extHandshake{},
+			errNoUTMetadata},
+		{map[string]interface{}{
+			"m":             map[string]interface{}{"ut_metadata": 2},
+			"metadata_size": 1000,
+		}, extHandshake{utMetadata: 2, metadataSize: 1000}, ErrSizeLimitExceeded},
+	}
+
+	for i, c := range cases {
+		h, err := parseExtHandshake([]byte(Encode(c.in)), 500)
+		if err != c.err || h.utMetadata != c.out.utMetadata ||
+			h.metadataSize != c.out.metadataSize || h.client != c.out.client ||
+			!h.yourIP.Equal(c.out.yourIP) {
+			t.Errorf("case %d: %+v, %v", i, h, err)
+		}
+	}
+}
+
+func TestWireMetadataPieces(t *testing.T) {
+	// the last piece is a full block.
+	info := []byte("d4:name32754:" + strings.Repeat("a", 32754) + "e")
+	infoHash := sha1.Sum(info)
+
+	reject := func(piece int) []byte {
+		return []byte(Encode(map[string]interface{}{"msg_type": REJECT, "piece": piece}))
+	}
+
+	cases := []struct {
+		name  string
+		reply func(piece int) []byte
+		ok    bool
+		stats func(WireStats) bool
+	}{
+		{"complete", nil, true, func(s WireStats) bool {
+			return s.Pieces == 2
+		}},
+		{"reject", func(piece int) []byte {
+			if piece == 1 {
+				return reject(piece)
+			}
+			return dataMessage(info, piece)
+		}, false, func(s WireStats) bool {
+			return s.Rejects == 1 && s.Pieces == 1
+		}},
+		{"beyond metadata_size", func(piece int) []byte {
+			return append([]byte(Encode(map[string]interface{}{
+				"msg_type": DATA, "piece": 2,
+			})), info[:BLOCK]...)
+		}, false, func(s WireStats) bool {
+			return s.InvalidPieces == 1
+		}},
+		{"short piece", func(piece int) []byte {
+			return dataMessage(info, piece)[:100]
+		}, false, func(s WireStats) bool {
+			return s.InvalidPieces == 1
+		}},
+	}
+
+	for _, c := range cases {
+		wire := newPeerWire(info, c.reply)
+		ok := wire.fetchMetadata(Request{InfoHash: infoHash, IP: "1.1.1.1", Port: 6881})
+
+		if ok != c.ok || !c.stats(wire.Stats()) {
+			t.Errorf("%s: fetched %v, stats %+v", c.name, ok, wire.Stats())
+			continue
+		}
+
+		if ok {
+			resp := <-wire.Response()
+			if resp.MetadataSize != len(info) || resp.Pieces != 2 ||
+				!bytes.Equal(resp.MetadataInfo, info) {
+				t.Errorf("%s: unexpected response", c.name)
+			}
+		}
+	}
+}
+
+func TestMetadataPieces(t *testing.T) {
+	mp := newMetadataPieces(BLOCK + 10)
+
+	cases := []struct {
+		piece int
+		size  int
+		ok    bool
+	}{
+		{2, BLOCK, false},
+		{-1, BLOCK, false},
+		{1, BLOCK, false},
+		{1, 10, true},
+		{1, 10, true},
+		{0, 10, false},
+	}
+
+	for _, c := range cases {
+		if err := mp.put(c.piece, make([]byte, c.size)); (err == nil) != c.ok {
+			t.Errorf("put(%d, %d bytes): unexpected error %v", c.piece, c.size, err)
+		}
+	}
+
+	if mp.done() || mp.next() != 0 {
+		t.Fatal("piece 0 missing")
+	}
+
+	mp.put(0, make([]byte, BLOCK))
+	if !mp.done() || mp.next() != -1 || len(mp.join()) != BLOCK+10 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of events. It holds at most burst tokens and
+// refills rate tokens per second.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full tokenBucket pointer. If rate is no greater
+// than 0, it returns nil, which means unlimited.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token if there is one and returns 0. Otherwise it returns
+// how long to wait until a token is available.
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.Lock()
+	defer tb.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+// wait blocks until a token is taken. A nil tokenBucket never blocks.
+func (tb *tokenBucket) wait() {
+	if tb == nil {
+		return
+	}
+
+	for {
+		d := tb.reserve()
+		if d == 0 {
+			return
+		}
+		time.Sleep(d)
+	}
+}
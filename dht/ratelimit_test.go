@@ -0,0 +1,29 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	if newTokenBucket(0, 10) != nil {
+		t.Fail()
+	}
+
+	tb := newTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if tb.reserve() != 0 {
+			t.Fail()
+		}
+	}
+
+	if d := tb.reserve(); d <= 0 || d > time.Second/10 {
+		t.Errorf("unexpected wait %v", d)
+	}
+
+	start := time.Now()
+	tb.wait()
+	if time.Since(start) <= 0 {
+		t.Fail()
+	}
+}
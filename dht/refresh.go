@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// RandomRefresh refreshes a bucket by looking up random ids in the
+	// bucket.
+	RandomRefresh = iota
+	// GapTargetedRefresh refreshes a bucket by looking up the midpoints of
+	// the least populated regions in the bucket.
+	GapTargetedRefresh
+)
+
+// gapPrefixBits is how many bits longer the prefixes of the regions are
+// than the prefix of the bucket in GapTargetedRefresh.
+const gapPrefixBits = 8
+
+// refreshQuery is a findNode query sent by routingTable.Fresh.
+type refreshQuery struct {
+	node   Node
+	target string
+}
+
+// stagger calls send n times spread over period. The gaps are period/n on
+// average, each one is jittered by up to half of it, so the queries of the
+// nodes refreshing on the same tick don't fall into lockstep. It stops
+// early if the dht is closed.
+func (rt *routingTable) stagger(n int, period time.Duration, send func(i int)) {
+	if n == 0 {
+		return
+	}
+
+	gap := int64(period) / int64(n)
+	for i := 0; i < n; i++ {
+		if i > 0 && gap > 0 {
+			select {
+			case <-rt.dht.Clock.After(time.Duration(gap/2 + rand.Int63n(gap))):
+			case <-rt.dht.done:
+				return
+			}
+		}
+		send(i)
+	}
+}
+
+// refreshTargets returns n lookup targets to refresh bucket according to
+// RefreshStrategy.
+func (rt *routingTable) refreshTargets(bucket *kbucket, n int) []string {
+	if rt.dht.RefreshStrategy == GapTargetedRefresh {
+		return rt.gapTargets(bucket.prefix, n)
+	}
+
+	targets := make([]string, n)
+	for i := range targets {
+		targets[i] = bucket.RandomChildID()
+	}
+	return targets
+}
+
+// gapTargets divides the keyspace under prefix into regions whose prefixes
+// are gapPrefixBits bits longer, and returns the midpoints of the n least
+// populated regions. If there are less than n regions, the regions are
+// reused in turn. The ties are broken randomly.
+func (rt *routingTable) gapTargets(prefix *bitmap, n int) []string {
+	depth := prefix.Size + gapPrefixBits
+	if depth > maxPrefixLength {
+		depth = maxPrefixLength
+	}
+
+	counts := make([]int, 1<<uint(depth-prefix.Size))
+	for _, val := range rt.cachedNodes.Snapshot() {
+		id := val.(Node).ID()
+		if id.Compare(prefix, prefix.Size) != 0 {
+			continue
+		}
+
+		region := 0
+		for i := prefix.Size; i < depth; i++ {
+			region = region<<1 | id.Bit(i)
+		}
+		counts[region]++
+	}
+
+	regions := rand.Perm(len(counts))
+	sort.SliceStable(regions, func(i, j int) bool {
+		return counts[regions[i]] < counts[regions[j]]
+	})
+
+	targets := make([]string, n)
+	for i := range targets {
+		region := regions[i%len(regions)]
+
+		target := newBitmapFrom(prefix, maxPrefixLength)
+		for j := depth - 1; j >= prefix.Size; j-- {
+			target.set(j, region&1)
+			region >>= 1
+		}
+
+		// The midpoint of the region is its prefix followed by 1 and 0s.
+		if depth < maxPrefixLength {
+			target.Set(depth)
+		}
+		targets[i] = target.RawString()
+	}
+	return targets
+}
@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGapTargets(t *testing.T) {
+	config := NewCrawlConfig()
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	// populate every region whose first byte is not 0x42.
+	for i := 0; i < 256; i++ {
+		if i == 0x42 {
+			continue
+		}
+
+		id := []byte(randomString(20))
+		id[0] = byte(i)
+
+		rt.Insert(NewNode(rawInfoHash(string(id)), &net.UDPAddr{
+			IP: net.IPv4(1, 1, byte(i), 1), Port: 6881,
+		}))
+	}
+
+	targets := rt.gapTargets(rt.root.KBucket().prefix, 2)
+	if len(targets) != 2 || targets[0][0] != 0x42 || targets[0][1] != 0x80 {
+		t.Errorf("unexpected targets %x", targets)
+	}
+
+	for _, c := range targets[0][2:] {
+		if c != 0 {
+			t.Fail()
+		}
+	}
+}
+
+func TestFreshStaggered(t *testing.T) {
+	clock := newFakeClock()
+
+	config := NewStandardConfig()
+	config.Clock = clock
+	config.RefreshNodeNum = 4
+	config.CheckKBucketPeriod = time.Second * 4
+
+	dht := newHandlerDHT(config)
+	for i := 0; i < 4; i++ {
+		dht.routingTable.Insert(NewNode(rawInfoHash(randomString(20)),
+			&net.UDPAddr{IP: net.IPv4(1, 1, 1, byte(i)), Port: 6881}))
+	}
+	clock.Advance(config.KBucketExpiredAfter)
+
+	// wait polls until cond holds.
+	queries := dht.transactionManager.queryChan
+	wait := func(cond func() bool) {
+		for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("timeout with %d queries sent", len(queries))
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		dht.routingTable.Fresh()
+		close(done)
+	}()
+
+	// the gap is 1s on average and at most 1.5s.
+	for i := 1; i <= 4; i++ {
+		wait(func() bool { return len(queries) == i })
+		if i < 4 {
+			wait(func() bool { return clock.pending() == 1 })
+			clock.Advance(time.Millisecond * 1500)
+		}
+	}
+	<-done
+
+	// a second call while one is running returns at once.
+	atomic.StoreInt32(&dht.routingTable.refreshing, 1)
+	dht.routingTable.Fresh()
+	if len(queries) != 4 {
+		t.Fail()
+	}
+}
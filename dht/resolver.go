@@ -0,0 +1,189 @@
+package dht
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// primeResolveInterval is how often the prime nodes are re-resolved.
+	primeResolveInterval = time.Minute * 10
+	// primeResolveBackoff is the first backoff after a failed resolution, it
+	// doubles after every consecutive failure.
+	primeResolveBackoff = time.Second * 30
+	// primeResolveMaxBackoff is the max backoff of a failed resolution.
+	primeResolveMaxBackoff = time.Minute * 30
+)
+
+// primeEntry is the resolution state of a prime node.
+type primeEntry struct {
+	addrs    []*net.UDPAddr
+	failures int
+	next     time.Time
+}
+
+// primeResolver resolves the prime nodes and caches all their addresses, so
+// a flaky DNS doesn't stall the bootstrap and the nodes can fail over between
+// the addresses. A failed resolution keeps the addresses resolved last time.
+type primeResolver struct {
+	sync.RWMutex
+	network string
+	hosts   []string
+	entries map[string]*primeEntry
+	lookup  func(host string) ([]net.IP, error)
+	logger  *zap.Logger
+}
+
+// newPrimeResolver returns a primeResolver pointer of hosts in `host:port`
+// format.
+func newPrimeResolver(logger *zap.Logger, network string, hosts []string) *primeResolver {
+	return &primeResolver{
+		network: network,
+		hosts:   hosts,
+		entries: make(map[string]*primeEntry),
+		lookup:  net.LookupIP,
+		logger:  logger,
+	}
+}
+
+// match returns whether ip can be used in the network.
+func (pr *primeResolver) match(ip net.IP) bool {
+	switch pr.network {
+	case "udp4":
+		return ip.To4() != nil
+	case "udp6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// resolveHost resolves all the addresses of host.
+func (pr *primeResolver) resolveHost(host string) ([]*net.UDPAddr, error) {
+	name, portString, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := pr.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]*net.UDPAddr, 0, len(ips))
+	for _, ip := range ips {
+		if pr.match(ip) {
+			addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address", Name: name}
+	}
+	return addrs, nil
+}
+
+// resolve resolves the hosts which are due. If force is true, all hosts are
+// resolved.
+func (pr *primeResolver) resolve(force bool) {
+	for _, host := range pr.hosts {
+		pr.RLock()
+		entry, ok := pr.entries[host]
+		pr.RUnlock()
+
+		if ok && !force && time.Now().Before(entry.next) {
+			continue
+		}
+
+		addrs, err := pr.resolveHost(host)
+
+		pr.Lock()
+		if !ok {
+			entry = &primeEntry{}
+			pr.entries[host] = entry
+		}
+
+		if err != nil {
+			backoff := primeResolveBackoff << uint(entry.failures)
+			if backoff > primeResolveMaxBackoff || backoff <= 0 {
+				backoff = primeResolveMaxBackoff
+			}
+
+			entry.failures++
+			entry.next = time.Now().Add(backoff)
+		} else {
+			entry.addrs = addrs
+			entry.failures = 0
+			entry.next = time.Now().Add(primeResolveInterval)
+		}
+		pr.Unlock()
+
+		if err != nil {
+			pr.logger.Warn("resolve prime node failed",
+				zap.String("host", host), zap.Error(err))
+		} else {
+			pr.logger.Debug("prime node resolved",
+				zap.String("host", host), zap.Int("addrs", len(addrs)))
+		}
+	}
+}
+
+// run re-resolves the hosts periodically until done is closed.
+func (pr *primeResolver) run(done <-chan struct{}) {
+	ticker := time.NewTicker(primeResolveBackoff)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			pr.resolve(false)
+		}
+	}
+}
+
+// addrs returns all the cached addresses.
+func (pr *primeResolver) addrs() []*net.UDPAddr {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	addrs := make([]*net.UDPAddr, 0, len(pr.entries))
+	for _, host := range pr.hosts {
+		if entry, ok := pr.entries[host]; ok {
+			addrs = append(addrs, entry.addrs...)
+		}
+	}
+	return addrs
+}
+
+// resolved returns the cached addresses keyed by host.
+func (pr *primeResolver) resolved() map[string][]string {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	resolved := make(map[string][]string, len(pr.entries))
+	for host, entry := range pr.entries {
+		addrs := make([]string, len(entry.addrs))
+		for i, addr := range entry.addrs {
+			addrs[i] = addr.String()
+		}
+		resolved[host] = addrs
+	}
+	return resolved
+}
+
+// ResolvedPrimeNodes returns the addresses the prime nodes are resolved to,
+// keyed by the prime node. A prime node which never resolves has no address.
+func (dht *DHT) ResolvedPrimeNodes() map[string][]string {
+	return dht.primeResolver.resolved()
+}
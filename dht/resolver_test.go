@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPrimeResolver(t *testing.T) {
+	fail := false
+	pr := newPrimeResolver(zap.NewNop(), "udp4", []string{"router.example:6881"})
+	pr.lookup = func(host string) ([]net.IP, error) {
+		if fail {
+			return nil, errors.New("dns failure")
+		}
+		return []net.IP{
+			net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), net.ParseIP("::1"),
+		}, nil
+	}
+
+	pr.resolve(true)
+	if addrs := pr.addrs(); len(addrs) != 2 || addrs[1].String() != "2.2.2.2:6881" {
+		t.Fatal(addrs)
+	}
+
+	// a failed resolution keeps the cached addresses and backs off.
+	fail = true
+	pr.resolve(true)
+
+	entry := pr.entries["router.example:6881"]
+	if len(pr.addrs()) != 2 || entry.failures != 1 ||
+		time.Until(entry.next) > primeResolveBackoff {
+		t.Fail()
+	}
+
+	if resolved := pr.resolved(); len(resolved["router.example:6881"]) != 2 {
+		t.Fail()
+	}
+}
@@ -2,47 +2,162 @@ package dht
 
 import (
 	"container/heap"
+	"container/list"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // maxPrefixLength is the length of DHT node.
 const maxPrefixLength = 160
 
-// peersManager represents a proxy that manipulates peers.
+const (
+	// RejectWhenFull drops the new nodes when the routing table is full.
+	RejectWhenFull = iota
+	// EvictStale evicts the least recently active node in the routing table
+	// to make room for a new node when the table is full.
+	EvictStale
+)
+
+// peersManager represents a proxy that manipulates peers. The infohashes
+// are kept in the order they're last inserted, so the least recently
+// announced one is evicted first when the peers are more than MaxPeersTotal.
+// A peer has no id, so the peers are keyed by their compact `ip:port`.
 type peersManager struct {
 	sync.RWMutex
-	table *syncedMap
+	table *keyedDeque
+	total int
 	dht   *DHT
 }
 
 // newPeersManager returns a new peersManager.
 func newPeersManager(dht *DHT) *peersManager {
 	return &peersManager{
-		table: newSyncedMap(),
+		table: newKeyedDeque(),
 		dht:   dht,
 	}
 }
 
-// Insert adds a peer into peersManager.
+// maxSize returns how many peers are kept for an infohash. It's enough to
+// fill both the neighbors and the get_peers values.
+func (pm *peersManager) maxSize() int {
+	if pm.dht.GetPeersValueLimit > pm.dht.K {
+		return pm.dht.GetPeersValueLimit
+	}
+	return pm.dht.K
+}
+
+// Insert adds a peer into peersManager. If the peer already exists, it's
+// replaced, so its LastSeen is bumped without a duplicate entry. When the
+// peers are more than maxSize, the least recently seen one is evicted. When
+// the peers of all infohashes are more than MaxPeersTotal, or the share of
+// MemoryBudgetMB, the least recently announced infohashes are evicted with
+// all their peers.
 func (pm *peersManager) Insert(infoHash string, peer Peer) {
 	pm.Lock()
-	if _, ok := pm.table.Get(infoHash); !ok {
-		pm.table.Set(infoHash, newKeyedDeque())
-	}
-	pm.Unlock()
+	defer pm.Unlock()
 
-	v, _ := pm.table.Get(infoHash)
-	queue := v.(*keyedDeque)
+	queue := newKeyedDeque()
+	if e, ok := pm.table.Get(infoHash); ok {
+		queue = e.Value.(*keyedDeque)
+	}
+	pm.table.Push(infoHash, queue)
 
+	size := queue.Len()
 	queue.Push(peer.CompactIPPortInfo(), peer)
-	if queue.Len() > pm.dht.K {
+	if queue.Len() > pm.maxSize() {
 		queue.Remove(queue.Front())
 	}
+	pm.total += queue.Len() - size
+
+	max := pm.dht.maxPeersTotal()
+	for max > 0 && pm.total > max && pm.table.Len() > 1 {
+		pm.total -= pm.table.Remove(pm.table.Front()).(*keyedDeque).Len()
+	}
 }
 
-// GetPeers returns size-length peers who announces having infoHash.
+// Clear removes the peers of all the infohashes.
+func (pm *peersManager) Clear() {
+	pm.Lock()
+	defer pm.Unlock()
+
+	pm.table.Clear()
+	pm.total = 0
+}
+
+// Total returns how many peers are stored for all the infohashes.
+func (pm *peersManager) Total() int {
+	pm.RLock()
+	defer pm.RUnlock()
+
+	return pm.total
+}
+
+// Count returns how many peers are stored for infoHash, including the
+// expired ones not removed yet.
+func (pm *peersManager) Count(infoHash string) int {
+	pm.RLock()
+	defer pm.RUnlock()
+
+	v, ok := pm.table.Get(infoHash)
+	if !ok {
+		return 0
+	}
+	return v.Value.(*keyedDeque).Len()
+}
+
+// expired returns whether the peer is seen more than PeerExpiredAfter ago.
+func (pm *peersManager) expired(peer Peer, now time.Time) bool {
+	return pm.dht.PeerExpiredAfter > 0 &&
+		now.Sub(peer.LastSeen()) > pm.dht.PeerExpiredAfter
+}
+
+// clear removes the expired peers, and the infohashes without peers left,
+// every minute.
+func (pm *peersManager) clear() {
+	for now := range pm.dht.Clock.Tick(time.Minute) {
+		pm.removeExpired(now)
+	}
+}
+
+// removeExpired removes the peers which are expired at now, and the
+// infohashes without peers left.
+func (pm *peersManager) removeExpired(now time.Time) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	infoHashes := make([]*list.Element, 0, 16)
+	for e := range pm.table.Iter() {
+		infoHashes = append(infoHashes, e)
+	}
+
+	for _, e := range infoHashes {
+		queue := e.Value.(*keyedDeque)
+
+		expired := make([]*list.Element, 0, queue.Len())
+		for p := range queue.Iter() {
+			if pm.expired(p.Value.(Peer), now) {
+				expired = append(expired, p)
+			}
+		}
+
+		for _, p := range expired {
+			queue.Remove(p)
+		}
+		pm.total -= len(expired)
+
+		if queue.Len() == 0 {
+			pm.table.Remove(e)
+		}
+	}
+}
+
+// GetPeers returns at most size peers who announces having infoHash. The
+// most recently seen peers are preferred, and the expired ones are skipped.
 func (pm *peersManager) GetPeers(infoHash string, size int) []Peer {
 	peers := make([]Peer, 0, size)
 
@@ -51,31 +166,41 @@ func (pm *peersManager) GetPeers(infoHash string, size int) []Peer {
 		return peers
 	}
 
-	for e := range v.(*keyedDeque).Iter() {
-		peers = append(peers, e.Value.(Peer))
+	now := pm.dht.Clock.Now()
+	for e := range v.Value.(*keyedDeque).Iter() {
+		if p := e.Value.(Peer); !pm.expired(p, now) {
+			peers = append(peers, p)
+		}
 	}
 
 	if len(peers) > size {
-		peers = peers[len(peers)-size:]
+		sort.SliceStable(peers, func(i, j int) bool {
+			return peers[i].LastSeen().After(peers[j].LastSeen())
+		})
+		peers = peers[:size]
 	}
 	return peers
 }
 
-// kbucket represents a k-size bucket.
+// kbucket represents a k-size bucket. Both nodes and candidates are sorted
+// by LastActiveTime, the least recently active node is at the front, so it's
+// the first to be evicted, and the most recently active one is at the back.
+// The candidates are allocated by the first InsertCandidate.
 type kbucket struct {
 	sync.RWMutex
 	nodes, candidates *keyedDeque
 	lastChanged       time.Time
 	prefix            *bitmap
+	clock             Clock
 }
 
 // newKBucket returns a new kbucket pointer.
-func newKBucket(prefix *bitmap) *kbucket {
+func newKBucket(prefix *bitmap, clock Clock) *kbucket {
 	bucket := &kbucket{
 		nodes:       newKeyedDeque(),
-		candidates:  newKeyedDeque(),
-		lastChanged: time.Now(),
+		lastChanged: clock.Now(),
 		prefix:      prefix,
+		clock:       clock,
 	}
 	return bucket
 }
@@ -103,50 +228,67 @@ func (bucket *kbucket) UpdateTimestamp() {
 	bucket.Lock()
 	defer bucket.Unlock()
 
-	bucket.lastChanged = time.Now()
+	bucket.lastChanged = bucket.clock.Now()
 }
 
-// Insert inserts node to the bucket. It returns whether the node is new in
-// the bucket.
+// lessActive reports whether node a is less recently active than node b.
+func lessActive(a, b interface{}) bool {
+	return a.(Node).LastActiveTime().Before(b.(Node).LastActiveTime())
+}
+
+// Insert inserts node to the bucket by its LastActiveTime. It returns whether
+// the node is new in the bucket.
 func (bucket *kbucket) Insert(no Node) bool {
 	isNew := !bucket.nodes.HasKey(no.IDRawString())
 
-	bucket.nodes.Push(no.IDRawString(), no)
+	bucket.nodes.InsertSorted(no.IDRawString(), no, lessActive)
 	bucket.UpdateTimestamp()
 
 	return isNew
 }
 
-// Replace removes node, then put bucket.candidates.Back() to the right
-// place of bucket.nodes.
+// InsertCandidate inserts node to the candidates by its LastActiveTime. When
+// the candidates are more than k, the least recently active one is evicted.
+func (bucket *kbucket) InsertCandidate(no Node, k int) {
+	if bucket.candidates == nil {
+		bucket.candidates = newKeyedDeque()
+	}
+
+	bucket.candidates.InsertSorted(no.IDRawString(), no, lessActive)
+	if bucket.candidates.Len() > k {
+		bucket.candidates.Remove(bucket.candidates.Front())
+	}
+}
+
+// Replace removes node, then moves the most recently active candidate to
+// bucket.nodes by its LastActiveTime.
 func (bucket *kbucket) Replace(no Node) {
 	bucket.nodes.Delete(no.IDRawString())
 	bucket.UpdateTimestamp()
 
-	if bucket.candidates.Len() == 0 {
+	if bucket.CandidateLen() == 0 {
 		return
 	}
 
 	no = bucket.candidates.Remove(bucket.candidates.Back()).(Node)
+	bucket.nodes.InsertSorted(no.IDRawString(), no, lessActive)
+}
 
-	inserted := false
-	for e := range bucket.nodes.Iter() {
-		if e.Value.(Node).LastActiveTime().After(no.LastActiveTime()) && !inserted {
-			bucket.nodes.InsertBefore(no, e)
-			inserted = true
-		}
-	}
-
-	if !inserted {
-		bucket.nodes.PushBack(no)
+// CandidateLen returns how many candidates the bucket holds.
+func (bucket *kbucket) CandidateLen() int {
+	if bucket.candidates == nil {
+		return 0
 	}
+	return bucket.candidates.Len()
 }
 
 // Fresh pings the expired nodes in the bucket.
 func (bucket *kbucket) Fresh(dht *DHT) {
+	now := bucket.clock.Now()
+
 	for e := range bucket.nodes.Iter() {
 		no := e.Value.(Node)
-		if time.Since(no.LastActiveTime()) > dht.NodeExpriedAfter {
+		if now.Sub(no.LastActiveTime()) > dht.NodeExpriedAfter {
 			dht.transactionManager.ping(no)
 		}
 	}
@@ -160,10 +302,10 @@ type routingTableNode struct {
 }
 
 // newRoutingTableNode returns a new routingTableNode pointer.
-func newRoutingTableNode(prefix *bitmap) *routingTableNode {
+func newRoutingTableNode(prefix *bitmap, clock Clock) *routingTableNode {
 	return &routingTableNode{
 		children: make([]*routingTableNode, 2),
-		bucket:   newKBucket(prefix),
+		bucket:   newKBucket(prefix, clock),
 	}
 }
 
@@ -214,21 +356,31 @@ func (tableNode *routingTableNode) Split() {
 
 	for i := 0; i < 2; i++ {
 		tableNode.SetChild(i, newRoutingTableNode(newBitmapFrom(
-			tableNode.KBucket().prefix, prefixLen+1)))
+			tableNode.KBucket().prefix, prefixLen+1), tableNode.KBucket().clock))
 	}
 
 	tableNode.Lock()
 	tableNode.children[1].bucket.prefix.Set(prefixLen)
 	tableNode.Unlock()
 
+	// The nodes are moved in order, so the children keep the order of the
+	// bucket.
 	for e := range tableNode.KBucket().nodes.Iter() {
 		nd := e.Value.(Node)
-		tableNode.Child(nd.ID().Bit(prefixLen)).KBucket().nodes.PushBack(nd)
+		tableNode.Child(nd.ID().Bit(prefixLen)).KBucket().nodes.Push(
+			nd.IDRawString(), nd)
 	}
 
-	for e := range tableNode.KBucket().candidates.Iter() {
-		nd := e.Value.(Node)
-		tableNode.Child(nd.ID().Bit(prefixLen)).KBucket().candidates.PushBack(nd)
+	if candidates := tableNode.KBucket().candidates; candidates != nil {
+		for i := 0; i < 2; i++ {
+			tableNode.Child(i).KBucket().candidates = newKeyedDeque()
+		}
+
+		for e := range candidates.Iter() {
+			nd := e.Value.(Node)
+			tableNode.Child(nd.ID().Bit(prefixLen)).KBucket().candidates.Push(
+				nd.IDRawString(), nd)
+		}
 	}
 
 	for i := 0; i < 2; i++ {
@@ -239,21 +391,23 @@ func (tableNode *routingTableNode) Split() {
 // routingTable implements the routing table in DHT protocol.
 type routingTable struct {
 	*sync.RWMutex
-	k              int
+	bucketSize     int
 	root           *routingTableNode
 	cachedNodes    *syncedMap
 	cachedKBuckets *keyedDeque
 	dht            *DHT
 	clearQueue     *syncedList
+	refreshing     int32
 }
 
-// newRoutingTable returns a new routingTable pointer.
-func newRoutingTable(k int, dht *DHT) *routingTable {
-	root := newRoutingTableNode(newBitmap(0))
+// newRoutingTable returns a new routingTable pointer whose buckets hold at
+// most bucketSize nodes.
+func newRoutingTable(bucketSize int, dht *DHT) *routingTable {
+	root := newRoutingTableNode(newBitmap(0), dht.Clock)
 
 	rt := &routingTable{
 		RWMutex:        &sync.RWMutex{},
-		k:              k,
+		bucketSize:     bucketSize,
 		root:           root,
 		cachedNodes:    newSyncedMap(),
 		cachedKBuckets: newKeyedDeque(),
@@ -272,10 +426,23 @@ func (rt *routingTable) Insert(nd Node) bool {
 	defer rt.Unlock()
 
 	if rt.dht.blackList.in(nd.Address().IP.String(), nd.Address().Port) ||
-		rt.cachedNodes.Len() >= rt.dht.MaxNodes {
+		rt.dht.rejects(nd.Address().IP) {
 		return false
 	}
 
+	if rt.cachedNodes.Len() >= rt.dht.maxNodes() &&
+		!rt.cachedNodes.Has(nd.Address().String()) &&
+		(rt.dht.FullTablePolicy != EvictStale || !rt.evictStale()) {
+		return false
+	}
+
+	// The buckets are keyed by id and cachedNodes by address, which only
+	// agree if an id and an address are each held once. So a node taking
+	// over the address of another node replaces it.
+	if v, ok := rt.cachedNodes.Get(nd.Address().String()); ok && !v.(Node).Equal(nd) {
+		rt.drop(v.(Node))
+	}
+
 	var (
 		next   *routingTableNode
 		bucket *kbucket
@@ -288,20 +455,34 @@ func (rt *routingTable) Insert(nd Node) bool {
 		if next != nil {
 			// If next is not the leaf.
 			root = next
-		} else if root.KBucket().nodes.Len() < rt.k ||
+		} else if root.KBucket().nodes.Len() < rt.bucketSize ||
 			root.KBucket().nodes.HasKey(nd.ID().RawString()) {
 
 			bucket = root.KBucket()
+
+			// the node moves to another address.
+			if e, ok := bucket.nodes.Get(nd.IDRawString()); ok &&
+				!e.Value.(Node).Equal(nd) {
+
+				rt.cachedNodes.Delete(e.Value.(Node).Address().String())
+			}
 			isNew := bucket.Insert(nd)
 
 			rt.cachedNodes.Set(nd.Address().String(), nd)
 			rt.cachedKBuckets.Push(bucket.prefix.String(), bucket)
 
+			if isNew && bucket.nodes.Len() == rt.bucketSize && rt.dht.OnBucketFull != nil {
+				rt.dht.OnBucketFull(bucket.prefix.String())
+			}
+
 			return isNew
 		} else if root.KBucket().prefix.Compare(nd.ID(), prefixLen-1) == 0 {
 			// If node has the same prefix with bucket, split it.
 
 			root.Split()
+			if rt.dht.OnBucketSplit != nil {
+				rt.dht.OnBucketSplit(prefixLen - 1)
+			}
 
 			rt.cachedKBuckets.Delete(root.KBucket().prefix.String())
 			root.SetKBucket(nil)
@@ -312,13 +493,11 @@ func (rt *routingTable) Insert(nd Node) bool {
 			}
 
 			root = root.Child(nd.ID().Bit(prefixLen - 1))
+		} else if rt.dht.DisableCandidates {
+			return false
 		} else {
 			// Finally, store node as a candidate and fresh the bucket.
-			root.KBucket().candidates.PushBack(nd)
-			if root.KBucket().candidates.Len() > rt.k {
-				root.KBucket().candidates.Remove(
-					root.KBucket().candidates.Front())
-			}
+			root.KBucket().InsertCandidate(nd, rt.bucketSize)
 
 			go root.KBucket().Fresh(rt.dht)
 			return false
@@ -327,12 +506,68 @@ func (rt *routingTable) Insert(nd Node) bool {
 	return false
 }
 
+// evictStale removes the least recently active node in the table. Every
+// bucket keeps its least recently active node at the front, so only the
+// fronts are compared. It returns false if there's no node to evict. It's
+// called with the table locked.
+func (rt *routingTable) evictStale() bool {
+	var (
+		stale  Node
+		bucket *kbucket
+	)
+
+	for e := range rt.cachedKBuckets.Iter() {
+		b := e.Value.(*kbucket)
+		if front := b.nodes.Front(); front != nil {
+			if no := front.Value.(Node); stale == nil ||
+				no.LastActiveTime().Before(stale.LastActiveTime()) {
+
+				stale, bucket = no, b
+			}
+		}
+	}
+
+	if stale == nil {
+		return false
+	}
+
+	bucket.nodes.Delete(stale.IDRawString())
+	bucket.UpdateTimestamp()
+	rt.cachedNodes.Delete(stale.Address().String())
+	return true
+}
+
+// drop removes no from its bucket and cachedNodes, no candidate replaces
+// it. It's called with the table locked.
+func (rt *routingTable) drop(no Node) {
+	bucket := rt.kbucketOf(no.ID())
+	bucket.nodes.Delete(no.IDRawString())
+	bucket.UpdateTimestamp()
+	rt.cachedNodes.Delete(no.Address().String())
+}
+
 // GetNeighbors returns the size-length nodes closest to id.
 func (rt *routingTable) GetNeighbors(id *bitmap, size int) []Node {
+	return rt.getNeighbors(id, size, nil)
+}
+
+// GetNeighborsByFamily returns the size-length nodes closest to id whose
+// addresses are IPv6 if ipv6 is true, otherwise IPv4.
+func (rt *routingTable) GetNeighborsByFamily(id *bitmap, size int, ipv6 bool) []Node {
+	return rt.getNeighbors(id, size, func(no Node) bool {
+		return (no.Address().IP.To4() == nil) == ipv6
+	})
+}
+
+// getNeighbors returns the size-length nodes closest to id among the nodes
+// accepted by filter. If filter is nil, all nodes are accepted.
+func (rt *routingTable) getNeighbors(id *bitmap, size int, filter func(Node) bool) []Node {
 	rt.RLock()
 	nodes := make([]interface{}, 0, rt.cachedNodes.Len())
-	for item := range rt.cachedNodes.Iter() {
-		nodes = append(nodes, item.val.(Node))
+	for _, val := range rt.cachedNodes.Snapshot() {
+		if no := val.(Node); filter == nil || filter(no) {
+			nodes = append(nodes, no)
+		}
 	}
 	rt.RUnlock()
 
@@ -360,26 +595,29 @@ func (rt *routingTable) GetNeighborCompactInfos(id *bitmap, size int) []string {
 // GetNodeKBucktById returns node whose id is `id` and the bucket it
 // belongs to.
 func (rt *routingTable) GetNodeKBucktByID(id *bitmap) (nd Node, bucket *kbucket) {
-
 	rt.RLock()
 	defer rt.RUnlock()
 
-	var next *routingTableNode
-	root := rt.root
+	bucket = rt.kbucketOf(id)
+	v, ok := bucket.nodes.Get(id.RawString())
+	if !ok {
+		return nil, nil
+	}
+	return v.Value.(Node), bucket
+}
 
+// kbucketOf returns the bucket whose prefix id has. It's called with the
+// table locked.
+func (rt *routingTable) kbucketOf(id *bitmap) *kbucket {
+	root := rt.root
 	for prefixLen := 1; prefixLen <= maxPrefixLength; prefixLen++ {
-		next = root.Child(id.Bit(prefixLen - 1))
+		next := root.Child(id.Bit(prefixLen - 1))
 		if next == nil {
-			v, ok := root.KBucket().nodes.Get(id.RawString())
-			if !ok {
-				return
-			}
-			nd, bucket = v.Value.(Node), root.KBucket()
-			return
+			break
 		}
 		root = next
 	}
-	return
+	return root.KBucket()
 }
 
 // GetNodeByAddress finds node by address.
@@ -411,10 +649,32 @@ func (rt *routingTable) RemoveByAddr(address string) {
 	}
 }
 
-// Fresh sends findNode to all nodes in the expired nodes.
+// Clear removes all the nodes and buckets, the routing table is back to one
+// empty bucket. The old buckets are detached rather than emptied, so a
+// goroutine still holding one doesn't affect the new table.
+func (rt *routingTable) Clear() {
+	rt.Lock()
+	defer rt.Unlock()
+
+	rt.root = newRoutingTableNode(newBitmap(0), rt.dht.Clock)
+	rt.cachedNodes.Clear()
+	rt.cachedKBuckets.Clear()
+	rt.cachedKBuckets.Push(rt.root.bucket.prefix.String(), rt.root.bucket)
+}
+
+// Fresh sends findNode to all nodes in the expired nodes. The targets are
+// picked by RefreshStrategy. The queries are spread over CheckKBucketPeriod
+// with jitter instead of sent at once, so it blocks for about the period. A
+// call while another one is running returns at once.
 func (rt *routingTable) Fresh() {
-	now := time.Now()
+	if !atomic.CompareAndSwapInt32(&rt.refreshing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&rt.refreshing, 0)
+
+	now := rt.dht.Clock.Now()
 
+	var queries []refreshQuery
 	for e := range rt.cachedKBuckets.Iter() {
 		bucket := e.Value.(*kbucket)
 		if now.Sub(bucket.LastChanged()) < rt.dht.KBucketExpiredAfter ||
@@ -423,16 +683,20 @@ func (rt *routingTable) Fresh() {
 		}
 
 		i := 0
+		targets := rt.refreshTargets(bucket, rt.dht.RefreshNodeNum)
 		for e := range bucket.nodes.Iter() {
 			if i < rt.dht.RefreshNodeNum {
-				no := e.Value.(Node)
-				rt.dht.transactionManager.findNode(no, bucket.RandomChildID())
-				rt.clearQueue.PushBack(no)
+				queries = append(queries, refreshQuery{e.Value.(Node), targets[i]})
 			}
 			i++
 		}
 	}
 
+	rt.stagger(len(queries), rt.dht.CheckKBucketPeriod, func(i int) {
+		rt.dht.transactionManager.findNode(queries[i].node, queries[i].target)
+		rt.clearQueue.PushBack(queries[i].node)
+	})
+
 	if rt.dht.IsCrawlMode() {
 		for e := range rt.clearQueue.Iter() {
 			rt.Remove(e.Value.(Node).ID())
@@ -450,6 +714,84 @@ func (rt *routingTable) Len() int {
 	return rt.cachedNodes.Len()
 }
 
+// CoverageStats represents how the known nodes distribute in the keyspace.
+type CoverageStats struct {
+	// the node count keyed by the prefix length of buckets
+	Nodes map[int]int
+	// the bucket count keyed by the prefix length of buckets
+	Buckets map[int]int
+	// the fraction of buckets which are not empty
+	NonEmptyRatio float64
+}
+
+// CoverageStats returns the node count per bucket prefix length and the
+// fraction of non-empty buckets.
+func (rt *routingTable) CoverageStats() CoverageStats {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	stats := CoverageStats{
+		Nodes:   make(map[int]int),
+		Buckets: make(map[int]int),
+	}
+
+	total, nonEmpty := 0, 0
+	for e := range rt.cachedKBuckets.Iter() {
+		bucket := e.Value.(*kbucket)
+		n := bucket.nodes.Len()
+
+		stats.Nodes[bucket.prefix.Size] += n
+		stats.Buckets[bucket.prefix.Size]++
+
+		total++
+		if n > 0 {
+			nonEmpty++
+		}
+	}
+
+	if total > 0 {
+		stats.NonEmptyRatio = float64(nonEmpty) / float64(total)
+	}
+	return stats
+}
+
+// ToDOT returns the Graphviz DOT graph of the routing table tree. Every leaf
+// is labeled by its bucket prefix and how many nodes and candidates it holds,
+// the edges are labeled by the bit of the child.
+func (rt *routingTable) ToDOT() string {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	var b strings.Builder
+	b.WriteString("digraph routingtable {\n\tnode [shape=box];\n")
+
+	var walk func(tableNode *routingTableNode, prefix string)
+	walk = func(tableNode *routingTableNode, prefix string) {
+		name, label := "p"+prefix, prefix
+		if label == "" {
+			label = "*"
+		}
+
+		if bucket := tableNode.KBucket(); bucket != nil {
+			fmt.Fprintf(&b, "\t%s [label=\"%s\\n%d nodes, %d candidates\"];\n",
+				name, label, bucket.nodes.Len(), bucket.CandidateLen())
+		} else {
+			fmt.Fprintf(&b, "\t%s [label=\"%s\", shape=ellipse];\n", name, label)
+		}
+
+		for i := 0; i < 2; i++ {
+			if child := tableNode.Child(i); child != nil {
+				fmt.Fprintf(&b, "\t%s -> p%s%d [label=\"%d\"];\n", name, prefix, i, i)
+				walk(child, prefix+strconv.Itoa(i))
+			}
+		}
+	}
+	walk(rt.root, "")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
 // Implementation of heap with heap.Interface.
 type heapItem struct {
 	distance *bitmap
@@ -491,8 +833,8 @@ func getTopK(queue []interface{}, id *bitmap, k int) []interface{} {
 		node := value.(Node)
 		distance := id.Xor(node.ID())
 		if topkHeap.Len() == k {
-			var last = topkHeap[topkHeap.Len()-1]
-			if last.distance.Compare(distance, maxPrefixLength) == 1 {
+			// The root of the max-heap is the farthest of the top k.
+			if topkHeap[0].distance.Compare(distance, maxPrefixLength) == 1 {
 				item := &heapItem{
 					distance,
 					value,
@@ -0,0 +1,430 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeersManagerRecency(t *testing.T) {
+	dht := &DHT{Config: NewStandardConfig()}
+	pm := newPeersManager(dht)
+
+	infoHash := randomString(20)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		pm.Insert(infoHash, &peer{
+			ip:       net.IPv4(1, 1, 1, byte(i)),
+			port:     6881,
+			lastSeen: now.Add(time.Duration(i-3) * time.Minute),
+		})
+	}
+
+	// re-announcing bumps the first peer instead of adding a duplicate.
+	pm.Insert(infoHash, NewPeer(net.IPv4(1, 1, 1, 0), 6881, ""))
+
+	peers := pm.GetPeers(infoHash, 2)
+	if len(peers) != 2 || !peers[0].IP().Equal(net.IPv4(1, 1, 1, 0)) ||
+		!peers[1].IP().Equal(net.IPv4(1, 1, 1, 2)) {
+		t.Fail()
+	}
+
+	if len(pm.GetPeers(infoHash, 8)) != 3 {
+		t.Fail()
+	}
+}
+
+func TestRoutingTableBucketEvents(t *testing.T) {
+	config := NewStandardConfig()
+	config.K = 2
+	config.KBucketSize = 2
+
+	splits, fulls := make([]int, 0), make([]string, 0)
+	config.OnBucketSplit = func(prefixLen int) {
+		splits = append(splits, prefixLen)
+	}
+	config.OnBucketFull = func(prefix string) {
+		fulls = append(fulls, prefix)
+	}
+
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	ids := []byte{0x00, 0x40, 0x80}
+	for i, b := range ids {
+		id := make([]byte, 20)
+		id[0] = b
+
+		rt.Insert(NewNode(rawInfoHash(string(id)), &net.UDPAddr{
+			IP: net.IPv4(1, 1, 1, byte(i)), Port: 6881,
+		}))
+	}
+
+	// the root bucket is full after 2 nodes, and splits on the third.
+	if len(fulls) != 1 || fulls[0] != "" || len(splits) != 1 || splits[0] != 0 {
+		t.Error(fulls, splits)
+	}
+}
+
+// testNode returns a node whose id starts with b and which was last active
+// ago before now.
+func testNode(b byte, now time.Time, ago time.Duration) Node {
+	id := make([]byte, 20)
+	id[0] = b
+
+	return &node{
+		id:             newBitmapFromBytes(id),
+		address:        &net.UDPAddr{IP: net.IPv4(1, 1, 1, b), Port: 6881},
+		lastActiveTime: now.Add(-ago),
+	}
+}
+
+// bucketOrder returns the first id bytes of the nodes in deque.
+func bucketOrder(deque *keyedDeque) []byte {
+	order := make([]byte, 0, deque.Len())
+	for e := range deque.Iter() {
+		order = append(order, e.Value.(Node).IDRawString()[0])
+	}
+	return order
+}
+
+func TestKBucketOrder(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name string
+		ops  func(bucket *kbucket)
+		out  []byte
+	}{
+		{"insert out of order", func(bucket *kbucket) {
+			bucket.Insert(testNode(1, now, 2*time.Minute))
+			bucket.Insert(testNode(2, now, 3*time.Minute))
+			bucket.Insert(testNode(3, now, time.Minute))
+		}, []byte{2, 1, 3}},
+		{"reinsert moves node", func(bucket *kbucket) {
+			bucket.Insert(testNode(1, now, 3*time.Minute))
+			bucket.Insert(testNode(2, now, 2*time.Minute))
+			bucket.Insert(testNode(1, now, 0))
+		}, []byte{2, 1}},
+		{"replace promotes the freshest candidate", func(bucket *kbucket) {
+			bucket.Insert(testNode(1, now, 5*time.Minute))
+			bucket.Insert(testNode(2, now, 3*time.Minute))
+			bucket.Insert(testNode(3, now, time.Minute))
+			bucket.InsertCandidate(testNode(4, now, 2*time.Minute), 8)
+			bucket.InsertCandidate(testNode(5, now, 4*time.Minute), 8)
+			bucket.Replace(testNode(1, now, 0))
+		}, []byte{2, 4, 3}},
+		{"replace without candidates", func(bucket *kbucket) {
+			bucket.Insert(testNode(1, now, 2*time.Minute))
+			bucket.Insert(testNode(2, now, time.Minute))
+			bucket.Replace(testNode(2, now, 0))
+		}, []byte{1}},
+	}
+
+	for _, c := range cases {
+		bucket := newKBucket(newBitmap(0), realClock{})
+		c.ops(bucket)
+
+		if order := bucketOrder(bucket.nodes); string(order) != string(c.out) {
+			t.Errorf("%s: order %v, want %v", c.name, order, c.out)
+		}
+	}
+}
+
+func TestKBucketCandidatesEviction(t *testing.T) {
+	now := time.Now()
+	bucket := newKBucket(newBitmap(0), realClock{})
+
+	bucket.InsertCandidate(testNode(1, now, time.Minute), 2)
+	bucket.InsertCandidate(testNode(2, now, 3*time.Minute), 2)
+	bucket.InsertCandidate(testNode(3, now, 2*time.Minute), 2)
+
+	if order := bucketOrder(bucket.candidates); string(order) != string([]byte{3, 1}) {
+		t.Error(order)
+	}
+}
+
+func TestSplitKeepsOrder(t *testing.T) {
+	now := time.Now()
+	tableNode := newRoutingTableNode(newBitmap(0), realClock{})
+
+	// 0x80 goes to the right child, the others go to the left one.
+	for i, b := range []byte{0x01, 0x80, 0x02, 0x03} {
+		tableNode.KBucket().Insert(
+			testNode(b, now, time.Duration(4-i)*time.Minute))
+	}
+	tableNode.KBucket().Insert(testNode(0x01, now, 0))
+
+	tableNode.Split()
+
+	left := tableNode.Child(0).KBucket()
+	if order := bucketOrder(left.nodes); string(order) != string([]byte{2, 3, 1}) {
+		t.Error(order)
+	}
+
+	// the moved nodes are keyed, so they can be replaced.
+	left.Replace(testNode(0x02, now, 0))
+	if left.nodes.Len() != 2 || left.nodes.HasKey(testNode(0x02, now, 0).IDRawString()) {
+		t.Fail()
+	}
+}
+
+func TestFullTablePolicy(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		policy int
+		out    []byte
+	}{
+		{RejectWhenFull, []byte{0x80, 0x40}},
+		{EvictStale, []byte{0xc0, 0x40}},
+	}
+
+	for _, c := range cases {
+		config := NewCrawlConfig()
+		config.MaxNodes = 2
+		config.FullTablePolicy = c.policy
+
+		dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+		rt := newRoutingTable(config.KBucketSize, dht)
+
+		rt.Insert(testNode(0x40, now, time.Minute))
+		rt.Insert(testNode(0x80, now, time.Minute*2))
+		rt.Insert(testNode(0xc0, now, 0))
+
+		// a known node is updated even if the table is full.
+		rt.Insert(testNode(0x40, now, 0))
+
+		if order := bucketOrder(rt.root.KBucket().nodes); rt.Len() != 2 ||
+			string(order) != string(c.out) {
+
+			t.Errorf("policy %d: %v", c.policy, order)
+		}
+	}
+}
+
+func TestRoutingTableNodeMoves(t *testing.T) {
+	config := NewCrawlConfig()
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	now := time.Now()
+	no := testNode(0x40, now, 0)
+	rt.Insert(no)
+
+	// the node moves to another address.
+	moved := NewNode(rawInfoHash(no.IDRawString()), &net.UDPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 6881})
+	rt.Insert(moved)
+
+	if _, ok := rt.GetNodeByAddress(no.Address().String()); ok || rt.Len() != 1 {
+		t.Error("the old address is kept")
+	}
+
+	// another node takes over the address.
+	rt.Insert(NewNode(rawInfoHash(testNode(0x80, now, 0).IDRawString()), moved.Address()))
+
+	if order := bucketOrder(rt.root.KBucket().nodes); rt.Len() != 1 ||
+		string(order) != string([]byte{0x80}) {
+
+		t.Errorf("nodes %v", order)
+	}
+}
+
+func TestDisableCandidates(t *testing.T) {
+	config := NewCrawlConfig()
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	now := time.Now()
+	for _, b := range []byte{0x00, 0x40, 0x80, 0xc0} {
+		rt.Insert(testNode(b, now, 0))
+	}
+
+	// all the nodes go to the unbounded root bucket, which never allocates
+	// the candidates.
+	if rt.Len() != 4 || rt.root.KBucket().candidates != nil {
+		t.Fail()
+	}
+
+	// the candidates of a split bucket are split too.
+	tableNode := newRoutingTableNode(newBitmap(0), realClock{})
+	tableNode.KBucket().InsertCandidate(testNode(0x80, now, 0), 8)
+	tableNode.Split()
+
+	if tableNode.Child(0).KBucket().CandidateLen() != 0 ||
+		tableNode.Child(1).KBucket().CandidateLen() != 1 {
+		t.Fail()
+	}
+}
+
+func TestPeersManagerMaxTotal(t *testing.T) {
+	config := NewStandardConfig()
+	config.MaxPeersTotal = 4
+	pm := newPeersManager(&DHT{Config: config})
+
+	infoHashes := []string{randomString(20), randomString(20), randomString(20)}
+
+	for i, infoHash := range infoHashes[:2] {
+		for j := 0; j < 2; j++ {
+			pm.Insert(infoHash, NewPeer(net.IPv4(1, 1, byte(i), byte(j)), 6881, ""))
+		}
+	}
+
+	// re-announcing the first infohash makes the second the least recent.
+	pm.Insert(infoHashes[0], NewPeer(net.IPv4(1, 1, 0, 0), 6881, ""))
+	pm.Insert(infoHashes[2], NewPeer(net.IPv4(1, 1, 2, 0), 6881, ""))
+
+	cases := []struct {
+		infoHash string
+		out      int
+	}{
+		{infoHashes[0], 2},
+		{infoHashes[1], 0},
+		{infoHashes[2], 1},
+	}
+
+	for i, c := range cases {
+		if n := len(pm.GetPeers(c.infoHash, 8)); n != c.out {
+			t.Errorf("infohash %d: %d peers, want %d", i, n, c.out)
+		}
+	}
+
+	if pm.Total() != 3 {
+		t.Error(pm.Total())
+	}
+}
+
+func TestPeersManagerExpiry(t *testing.T) {
+	clock := newFakeClock()
+	config := NewStandardConfig()
+	config.PeerExpiredAfter = time.Minute * 30
+	config.Clock = clock
+	pm := newPeersManager(&DHT{Config: config})
+
+	infoHashes := []string{randomString(20), randomString(20)}
+	for i, infoHash := range infoHashes {
+		pm.Insert(infoHash, newPeerAt(
+			net.IPv4(1, 1, 1, byte(i)), 6881, "", clock.Now()))
+	}
+
+	clock.Advance(time.Minute * 20)
+	// re-announcing refreshes the peer of the first infohash.
+	pm.Insert(infoHashes[0], newPeerAt(
+		net.IPv4(1, 1, 1, 0), 6881, "", clock.Now()))
+
+	clock.Advance(time.Minute * 20)
+	if len(pm.GetPeers(infoHashes[0], 8)) != 1 ||
+		len(pm.GetPeers(infoHashes[1], 8)) != 0 {
+		t.Error("expired peer served")
+	}
+
+	pm.removeExpired(clock.Now())
+	if pm.Total() != 1 || pm.table.Len() != 1 {
+		t.Errorf("%d peers of %d infohashes left", pm.Total(), pm.table.Len())
+	}
+
+	config.PeerExpiredAfter = 0
+	clock.Advance(time.Hour)
+	if len(pm.GetPeers(infoHashes[0], 8)) != 1 {
+		t.Error("peer expired without PeerExpiredAfter")
+	}
+}
+func TestRoutingTableBucketSize(t *testing.T) {
+	config := NewStandardConfig()
+	config.K = 2
+	config.KBucketSize = 4
+
+	splits := 0
+	config.OnBucketSplit = func(prefixLen int) {
+		splits++
+	}
+
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	now := time.Now()
+	for _, b := range []byte{0x00, 0x40, 0x80, 0xc0} {
+		rt.Insert(testNode(b, now, 0))
+	}
+
+	// the bucket holds KBucketSize nodes, not K.
+	if splits != 0 || rt.Len() != 4 {
+		t.Error(splits, rt.Len())
+	}
+}
+
+func TestRoutingTableToDOT(t *testing.T) {
+	config := NewStandardConfig()
+	config.K = 2
+	config.KBucketSize = 2
+
+	dht := &DHT{Config: config, blackList: newBlackList(256, realClock{})}
+	rt := newRoutingTable(config.KBucketSize, dht)
+
+	now := time.Now()
+	for _, b := range []byte{0x00, 0x40, 0x80} {
+		rt.Insert(testNode(b, now, 0))
+	}
+
+	expected := `digraph routingtable {
+	node [shape=box];
+	p [label="*", shape=ellipse];
+	p -> p0 [label="0"];
+	p0 [label="0\n2 nodes, 0 candidates"];
+	p -> p1 [label="1"];
+	p1 [label="1\n1 nodes, 0 candidates"];
+}
+`
+	if dot := rt.ToDOT(); dot != expected {
+		t.Errorf("unexpected graph:\n%s", dot)
+	}
+}
+
+func TestClosestNodes(t *testing.T) {
+	config := NewStandardConfig()
+	config.KBucketSize = 1 << 10
+
+	dht := newHandlerDHT(config)
+
+	nodes := make([]Node, 64)
+	for i := range nodes {
+		nodes[i] = NewNode(rawInfoHash(randomString(20)),
+			&net.UDPAddr{IP: net.IPv4(1, 1, 1, byte(i)), Port: 6881})
+		dht.routingTable.Insert(nodes[i])
+	}
+
+	target := randomString(20)
+	if _, err := dht.ClosestNodes(target, 8); err != ErrNotReady {
+		t.Error(err)
+	}
+	dht.Ready = true
+
+	closest, err := dht.ClosestNodes(hex.EncodeToString([]byte(target)), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := newBitmapFromString(target)
+	sort.Slice(nodes, func(i, j int) bool {
+		return id.Xor(nodes[i].ID()).Compare(id.Xor(nodes[j].ID()), maxPrefixLength) < 0
+	})
+
+	if len(closest) != 8 {
+		t.Fatal(len(closest))
+	}
+	for i, no := range closest {
+		if !no.Equal(nodes[i]) {
+			t.Errorf("node %d isn't the %dth closest", i, i)
+		}
+	}
+
+	for _, target := range []string{"short", strings.Repeat("z", 40)} {
+		if _, err := dht.ClosestNodes(target, 8); err == nil {
+			t.Errorf("malformed target %q accepted", target)
+		}
+	}
+}
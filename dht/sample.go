@@ -0,0 +1,130 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// sampleRetryAfter is how long a node isn't sampled again if it doesn't
+	// return a valid interval, e.g. it doesn't support BEP 51.
+	sampleRetryAfter = time.Minute * 5
+	// sampleMaxInterval is the max interval a node can ask for in BEP 51.
+	sampleMaxInterval = time.Hour * 6
+)
+
+// sampler sends sample_infohashes queries(see
+// http://www.bittorrent.org/beps/bep_0051.html) to random nodes in the
+// routing table. It remembers when each node can be sampled again, so the
+// interval returned by the node is honored.
+type sampler struct {
+	*syncedMap
+	dht *DHT
+}
+
+// newSampler returns a sampler pointer.
+func newSampler(dht *DHT) *sampler {
+	return &sampler{
+		syncedMap: newSyncedMap(),
+		dht:       dht,
+	}
+}
+
+// allowed returns whether the node at address can be sampled now.
+func (s *sampler) allowed(address string) bool {
+	v, ok := s.Get(address)
+	return !ok || s.dht.Clock.Now().After(v.(time.Time))
+}
+
+// delay forbids sampling the node at address in the next interval.
+func (s *sampler) delay(address string, interval time.Duration) {
+	if interval > sampleMaxInterval {
+		interval = sampleMaxInterval
+	}
+	s.Set(address, s.dht.Clock.Now().Add(interval))
+}
+
+// clear removes the nodes which can be sampled again.
+func (s *sampler) clear() {
+	keys := make([]interface{}, 0, 100)
+	now := s.dht.Clock.Now()
+
+	for key, val := range s.Snapshot() {
+		if now.After(val.(time.Time)) {
+			keys = append(keys, key)
+		}
+	}
+
+	s.DeleteMulti(keys)
+}
+
+// sample sends sample_infohashes to at most SampleNodeNum allowed nodes
+// around a random target.
+func (s *sampler) sample() {
+	target := randomString(20)
+
+	for _, no := range s.dht.routingTable.GetNeighbors(
+		newBitmapFromString(target), s.dht.SampleNodeNum) {
+
+		address := no.Address().String()
+		if !s.allowed(address) {
+			continue
+		}
+
+		s.delay(address, sampleRetryAfter)
+		s.dht.transactionManager.sampleInfohashes(no, target)
+	}
+}
+
+// run samples nodes every SampleInterval until the dht is closed.
+func (s *sampler) run() {
+	ticker := s.dht.Clock.NewTicker(s.dht.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.dht.done:
+			return
+		case <-ticker.C():
+			s.clear()
+			s.sample()
+		}
+	}
+}
+
+// handleSamples handles the sample_infohashes response r from addr. Every
+// sampled infohash is emitted through OnSample, and the nodes in the
+// response are put to the routing table.
+func handleSamples(dht *DHT, addr *net.UDPAddr, r map[string]interface{}) error {
+	if err := ParseKey(r, "samples", "string"); err != nil {
+		return err
+	}
+
+	samples := r["samples"].(string)
+	if len(samples)%20 != 0 {
+		return errors.New("the length of samples should can be divided by 20")
+	}
+
+	if ParseKey(r, "interval", "int") == nil {
+		dht.sampler.delay(
+			addr.String(), time.Duration(r["interval"].(int))*time.Second)
+	}
+
+	if nodes, err := parseNodes(dht, r); err == nil {
+		for _, no := range nodes {
+			dht.routingTable.Insert(no)
+		}
+	}
+
+	if dht.OnSample == nil {
+		return nil
+	}
+
+	for i := 0; i < len(samples)/20; i++ {
+		if infoHash := samples[i*20 : (i+1)*20]; !dht.isBlocked(infoHash) {
+			dht.OnSample(rawInfoHash(infoHash), addr.IP, addr.Port)
+		}
+	}
+	return nil
+}
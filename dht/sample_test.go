@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	clock := newFakeClock()
+	config := NewStandardConfig()
+	config.Clock = clock
+
+	s := newSampler(&DHT{Config: config})
+
+	if !s.allowed("1.1.1.1:6881") {
+		t.Fail()
+	}
+
+	s.delay("1.1.1.1:6881", time.Hour*24)
+	if s.allowed("1.1.1.1:6881") {
+		t.Fail()
+	}
+
+	v, _ := s.Get("1.1.1.1:6881")
+	if v.(time.Time).Sub(clock.Now()) != sampleMaxInterval {
+		t.Fail()
+	}
+
+	s.delay("2.2.2.2:6881", time.Second)
+	clock.Advance(time.Second * 2)
+	s.clear()
+	if s.Len() != 1 || !s.allowed("2.2.2.2:6881") {
+		t.Fail()
+	}
+}
+
+func TestHandleSamples(t *testing.T) {
+	config := NewStandardConfig()
+
+	var sampled []string
+	config.OnSample = func(infoHash InfoHash, ip net.IP, port int) {
+		sampled = append(sampled, infoHash.Raw())
+	}
+	config.OnGetPeersIP = func(infoHash InfoHash, ip net.IP, port int) {
+		t.Error("sample emitted as get_peers")
+	}
+
+	dht := newHandlerDHT(config)
+	dht.sampler = newSampler(dht)
+
+	samples := []string{randomString(20), randomString(20)}
+	err := handleSamples(dht, &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881},
+		map[string]interface{}{"samples": samples[0] + samples[1]})
+
+	if err != nil || len(sampled) != 2 ||
+		sampled[0] != samples[0] || sampled[1] != samples[1] {
+
+		t.Errorf("sampled %d infohashes, %v", len(sampled), err)
+	}
+}
@@ -0,0 +1,108 @@
+package dht
+
+import (
+	"crypto/sha1"
+	"math"
+	"net"
+)
+
+// scrapeFilterBits is the size in bits of a BEP 33 bloom filter, which
+// takes 2 hash functions.
+const scrapeFilterBits = 2048
+
+// scrapeFilter is the bloom filter of the peer ips of a swarm in the scrape
+// response of get_peers. See http://www.bittorrent.org/beps/bep_0033.html.
+type scrapeFilter [scrapeFilterBits / 8]byte
+
+// add puts ip in the filter. An IPv4 address is hashed in its 4-byte form.
+func (sf *scrapeFilter) add(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	hash := sha1.Sum(ip)
+	for _, i := range []int{
+		int(hash[0]) | int(hash[1])<<8,
+		int(hash[2]) | int(hash[3])<<8,
+	} {
+		i %= scrapeFilterBits
+		sf[i/8] |= 1 << uint(i%8)
+	}
+}
+
+// size estimates how many ips are put in the filter.
+func (sf *scrapeFilter) size() int {
+	zeros := 0
+	for _, b := range sf {
+		for i := 0; i < 8; i++ {
+			if b&(1<<uint(i)) == 0 {
+				zeros++
+			}
+		}
+	}
+
+	// a full filter only tells the swarm is large.
+	if zeros == 0 {
+		zeros = 1
+	}
+
+	m := float64(scrapeFilterBits)
+	return int(math.Round(
+		math.Log(float64(zeros)/m) / (2 * math.Log(1-1/m))))
+}
+
+// parseScrapeFilter returns the filter of data, which must be 256 bytes.
+func parseScrapeFilter(data string) (sf scrapeFilter, ok bool) {
+	if len(data) != len(sf) {
+		return
+	}
+	copy(sf[:], data)
+	return sf, true
+}
+
+// ScrapeFilters returns the BEP 33 bloom filters of the seeds and the other
+// peers stored for infoHash. The expired peers are skipped.
+func (pm *peersManager) ScrapeFilters(infoHash string) (seeds, peers scrapeFilter) {
+	v, ok := pm.table.Get(infoHash)
+	if !ok {
+		return
+	}
+
+	now := pm.dht.Clock.Now()
+	for e := range v.Value.(*keyedDeque).Iter() {
+		p := e.Value.(Peer)
+		if pm.expired(p, now) {
+			continue
+		}
+
+		if isSeed(p) {
+			seeds.add(p.IP())
+		} else {
+			peers.add(p.IP())
+		}
+	}
+	return
+}
+
+// onScrape calls OnScrape with the swarm size estimated from the scrape
+// filters in the get_peers response r, if both filters are valid.
+func (dht *DHT) onScrape(infoHash string, r map[string]interface{}) {
+	if dht.OnScrape == nil {
+		return
+	}
+
+	bfsd, _ := r["BFsd"].(string)
+	bfpe, _ := r["BFpe"].(string)
+
+	seeds, ok := parseScrapeFilter(bfsd)
+	if !ok {
+		return
+	}
+
+	peers, ok := parseScrapeFilter(bfpe)
+	if !ok {
+		return
+	}
+
+	dht.OnScrape(rawInfoHash(infoHash), seeds.size(), peers.size())
+}
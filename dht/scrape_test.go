@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScrapeFilter(t *testing.T) {
+	var sf scrapeFilter
+	if sf.size() != 0 {
+		t.Fail()
+	}
+
+	// the test vector of BEP 33: 256 IPv4 and 1000 IPv6 addresses are
+	// estimated as 1224.93.
+	for i := 0; i < 256; i++ {
+		sf.add(net.IPv4(192, 0, 2, byte(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		ip := net.ParseIP("2001:db8::")
+		ip[14], ip[15] = byte(i>>8), byte(i)
+		sf.add(ip)
+	}
+
+	if size := sf.size(); size != 1225 {
+		t.Errorf("estimated %d", size)
+	}
+
+	if _, ok := parseScrapeFilter(string(sf[:255])); ok {
+		t.Error("short filter parsed")
+	}
+	if parsed, ok := parseScrapeFilter(string(sf[:])); !ok || parsed != sf {
+		t.Error("filter not parsed")
+	}
+}
+
+func TestScrapeFilters(t *testing.T) {
+	config := NewStandardConfig()
+	config.ScrapeFilters = true
+
+	dht := newUDPDHT(t, config)
+	defer dht.Close()
+
+	infoHash := randomString(20)
+
+	for i := 0; i < 3; i++ {
+		dht.peersManager.Insert(infoHash, newAnnouncedPeer(net.IPv4(2, 2, 2, byte(i)),
+			6881, "", dht.Clock.Now(), i == 0))
+	}
+
+	seeds, peers := dht.peersManager.ScrapeFilters(infoHash)
+
+	var scraped [2]int
+	config.OnScrape = func(infoHash InfoHash, seeds, peers int) {
+		scraped = [2]int{seeds, peers}
+	}
+
+	dht.onScrape(infoHash, map[string]interface{}{
+		"BFsd": string(seeds[:]),
+		"BFpe": string(peers[:]),
+	})
+	if scraped != [2]int{1, 2} {
+		t.Errorf("scraped %v", scraped)
+	}
+
+	// the filters are attached to the get_peers response asking for them.
+	_, conn := newUDPPeer(t)
+	defer conn.Close()
+
+	query := NewDHTQuery("aa", DHTQueryTypeGetPeers, map[string]interface{}{
+		"id":        randomString(20),
+		"info_hash": infoHash,
+		"scrape":    1,
+	})
+	if _, err := conn.WriteToUDP([]byte(Encode(query.ToPayload())),
+		localAddr(dht)); err != nil {
+		t.Fatal(err)
+	}
+
+	buff := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFromUDP(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Decode(buff[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := v.(map[string]interface{})["r"].(map[string]interface{})
+	if r["BFsd"] != string(seeds[:]) || r["BFpe"] != string(peers[:]) {
+		t.Error("scrape filters not attached")
+	}
+}
@@ -0,0 +1,68 @@
+package dht
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// parseSeedNode parses a seed node in the form of `id@ip:port` or
+// `ip:port`, where id is the hex encoded node id. The node of an entry
+// without id is a temporary node.
+func parseSeedNode(entry, network string) (Node, error) {
+	i := strings.IndexByte(entry, '@')
+	if i == -1 {
+		addr, err := net.ResolveUDPAddr(network, entry)
+		if err != nil {
+			return nil, err
+		}
+		return NewTempNode(addr), nil
+	}
+
+	var id InfoHash
+	if err := id.FromHex(entry[:i]); err != nil {
+		return nil, errors.New("node id should be 40 hex characters")
+	}
+	return NewNodeNetworkAddress(id, network, entry[i+1:])
+}
+
+// seed puts the seed nodes with id to the routing table, and pings the ones
+// without id, so they're put to the routing table once they respond.
+func (dht *DHT) seed() {
+	for _, entry := range dht.SeedNodes {
+		no, err := parseSeedNode(entry, dht.Network)
+		if err != nil {
+			dht.logger.Warn("invalid seed node",
+				zap.String("entry", entry), zap.Error(err))
+			continue
+		}
+		no = activeAt(no, dht.Clock.Now())
+
+		if no.ID() == nil {
+			dht.transactionManager.ping(no)
+		} else {
+			dht.routingTable.Insert(no)
+		}
+	}
+}
+
+// LoadSeedNodes reads the seed nodes from r, one entry per line. Blank
+// lines and the lines starting with # are skipped. The result can be used as
+// Config.SeedNodes.
+func LoadSeedNodes(r io.Reader) ([]string, error) {
+	nodes := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nodes = append(nodes, line)
+	}
+	return nodes, scanner.Err()
+}
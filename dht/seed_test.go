@@ -0,0 +1,50 @@
+package dht
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseSeedNode(t *testing.T) {
+	id := strings.Repeat("a", 20)
+
+	cases := []struct {
+		in    string
+		hasID bool
+		ok    bool
+	}{
+		{hex.EncodeToString([]byte(id)) + "@1.1.1.1:6881", true, true},
+		{"1.1.1.1:6881", false, true},
+		{"abcd@1.1.1.1:6881", false, false},
+		{"zz@1.1.1.1:6881", false, false},
+		{"1.1.1.1", false, false},
+	}
+
+	for _, c := range cases {
+		no, err := parseSeedNode(c.in, "udp4")
+		if (err == nil) != c.ok {
+			t.Errorf("parseSeedNode(%q): unexpected error %v", c.in, err)
+			continue
+		}
+
+		if c.ok && ((no.ID() != nil) != c.hasID ||
+			no.Address().String() != "1.1.1.1:6881") {
+			t.Errorf("parseSeedNode(%q) = %v", c.in, no)
+		}
+	}
+
+	no, _ := parseSeedNode(hex.EncodeToString([]byte(id))+"@1.1.1.1:6881", "udp4")
+	if no.IDRawString() != id {
+		t.Fail()
+	}
+}
+
+func TestLoadSeedNodes(t *testing.T) {
+	nodes, err := LoadSeedNodes(strings.NewReader(
+		"# harvested nodes\n1.1.1.1:6881\n\n  2.2.2.2:6881  \n"))
+
+	if err != nil || len(nodes) != 2 || nodes[1] != "2.2.2.2:6881" {
+		t.Error(nodes, err)
+	}
+}
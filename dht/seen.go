@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// seenEntry is a key remembered by seenFilter and when it's first seen.
+type seenEntry struct {
+	key       string
+	firstSeen time.Time
+}
+
+// seenFilter remembers the most recently added maxSize keys and when they
+// are first seen. When it's full the oldest key is forgotten.
+type seenFilter struct {
+	sync.Mutex
+	maxSize int
+	keys    *list.List
+	index   map[string]*list.Element
+}
+
+// newSeenFilter returns a seenFilter pointer.
+func newSeenFilter(maxSize int) *seenFilter {
+	return &seenFilter{
+		maxSize: maxSize,
+		keys:    list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// has returns whether key has been seen.
+func (sf *seenFilter) has(key string) bool {
+	sf.Lock()
+	defer sf.Unlock()
+
+	_, ok := sf.index[key]
+	return ok
+}
+
+// add marks key as seen at now. It returns whether the key is new, the
+// first-seen time of a known key is kept.
+func (sf *seenFilter) add(key string, now time.Time) bool {
+	sf.Lock()
+	defer sf.Unlock()
+
+	if _, ok := sf.index[key]; ok {
+		return false
+	}
+
+	sf.index[key] = sf.keys.PushBack(&seenEntry{key, now})
+	if sf.keys.Len() > sf.maxSize {
+		delete(sf.index, sf.keys.Remove(sf.keys.Front()).(*seenEntry).key)
+	}
+	return true
+}
+
+// firstSeen returns when key is first seen. It returns false if the key
+// isn't remembered.
+func (sf *seenFilter) firstSeen(key string) (time.Time, bool) {
+	sf.Lock()
+	defer sf.Unlock()
+
+	e, ok := sf.index[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.Value.(*seenEntry).firstSeen, true
+}
+
+// len returns how many keys are remembered.
+func (sf *seenFilter) len() int {
+	sf.Lock()
+	defer sf.Unlock()
+
+	return sf.keys.Len()
+}
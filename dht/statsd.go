@@ -0,0 +1,85 @@
+package dht
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// statsDMaxPacket is the max size of a statsd packet, which fits in the
+// ethernet MTU.
+const statsDMaxPacket = 1432
+
+// StatsDReporter flushes the core counters of d to the statsd server at
+// addr over udp every interval, until d is closed. The gauges are sent as
+// they are, and the counters are sent as their increments since the last
+// flush, all prefixed by "dht.". The metrics of a flush are batched in as
+// few packets as possible. It reports from its own goroutine, so it never
+// blocks the dht, and a failed write is only logged. It returns an error if
+// addr can't be resolved.
+func StatsDReporter(addr string, d *DHT, interval time.Duration) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer conn.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := make(map[string]uint64, len(metrics))
+		for {
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+			}
+
+			for _, packet := range statsDPackets(d, last) {
+				if _, err := conn.Write(packet); err != nil {
+					d.logger.Debug("write statsd failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// statsDPackets returns the packets of the metrics of d in the statsd
+// format. last holds the counter values of the last flush, and it's updated.
+func statsDPackets(d *DHT, last map[string]uint64) [][]byte {
+	var packets [][]byte
+	buff := &bytes.Buffer{}
+
+	for _, m := range metrics {
+		value, kind := m.value(d), "|g"
+		if m.counter {
+			current := value
+			if current >= last[m.name] {
+				value -= last[m.name]
+			}
+			last[m.name], kind = current, "|c"
+		}
+
+		line := "dht." + m.name + ":" + strconv.FormatUint(value, 10) + kind
+		if buff.Len() > 0 && buff.Len()+1+len(line) > statsDMaxPacket {
+			packets = append(packets, buff.Bytes())
+			buff = &bytes.Buffer{}
+		}
+
+		if buff.Len() > 0 {
+			buff.WriteByte('\n')
+		}
+		buff.WriteString(line)
+	}
+
+	if buff.Len() > 0 {
+		packets = append(packets, buff.Bytes())
+	}
+	return packets
+}
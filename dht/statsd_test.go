@@ -0,0 +1,46 @@
+package dht
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDReporter(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	d := New(nil, nil)
+	d.droppedPackets = 3
+
+	if err := StatsDReporter(server.LocalAddr().String(), d, time.Millisecond*10); err != nil {
+		t.Fatal(err)
+	}
+	defer close(d.done)
+
+	buff := make([]byte, statsDMaxPacket)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+
+	// the counters are sent as the increments.
+	for _, dropped := range []string{"3", "0"} {
+		n, _, err := server.ReadFromUDP(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(string(buff[:n]), "\n")
+		if len(lines) != len(metrics) {
+			t.Errorf("%d metrics sent", len(lines))
+		}
+
+		packet := string(buff[:n])
+		if !strings.Contains(packet, "dht.packets_dropped:"+dropped+"|c") ||
+			!strings.Contains(packet, "dht.routing_table_size:0|g") {
+			t.Errorf("unexpected packet %q", packet)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package dht
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// swarmPrunePeriod is the max period of removing the peers out of the
+// window.
+const swarmPrunePeriod = time.Minute
+
+// swarm holds the observed peers of an infohash.
+type swarm struct {
+	infoHash string
+	peers    *keyedDeque
+}
+
+// SwarmTracker records the peers of the infohashes observed in a time
+// window, from both the get_peers responses and the announce_peer queries,
+// e.g. to study the swarm sizes. An infohash holds at most maxPeers peers,
+// the least recently observed one is evicted beyond it, and at most
+// maxInfoHashes infohashes are kept, the least recently observed one is
+// evicted with all its peers beyond it.
+type SwarmTracker struct {
+	sync.RWMutex
+	table         *keyedDeque
+	window        time.Duration
+	maxInfoHashes int
+	maxPeers      int
+	clock         Clock
+}
+
+// NewSwarmTracker returns a SwarmTracker pointer which keeps the peers
+// observed in the last window.
+func NewSwarmTracker(window time.Duration, maxInfoHashes, maxPeers int,
+	clock Clock) *SwarmTracker {
+
+	return &SwarmTracker{
+		table:         newKeyedDeque(),
+		window:        window,
+		maxInfoHashes: maxInfoHashes,
+		maxPeers:      maxPeers,
+		clock:         clock,
+	}
+}
+
+// Observe records the peer of infoHash, which is 20 bytes, as seen now. A
+// peer already recorded is bumped.
+func (st *SwarmTracker) Observe(infoHash string, p Peer) {
+	st.Lock()
+	defer st.Unlock()
+
+	s := &swarm{infoHash: infoHash, peers: newKeyedDeque()}
+	if e, ok := st.table.Get(infoHash); ok {
+		s = e.Value.(*swarm)
+	}
+	st.table.Push(infoHash, s)
+
+	s.peers.Push(p.CompactIPPortInfo(), newPeerAt(p.IP(), p.Port(), "", st.clock.Now()))
+	if s.peers.Len() > st.maxPeers {
+		s.peers.Remove(s.peers.Front())
+	}
+
+	if st.table.Len() > st.maxInfoHashes {
+		st.table.Remove(st.table.Front())
+	}
+}
+
+// Snapshot returns the peers of infoHash, which is 20 bytes or 40 hex
+// characters, observed in the window, the least recently observed first.
+func (st *SwarmTracker) Snapshot(infoHash string) []Peer {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return nil
+	}
+
+	st.RLock()
+	defer st.RUnlock()
+
+	e, ok := st.table.Get(ih.Raw())
+	if !ok {
+		return nil
+	}
+	return st.snapshot(e.Value.(*swarm), st.clock.Now())
+}
+
+// Range calls f with every infohash, which is 20 bytes, and its snapshot,
+// the least recently observed infohash first. It stops if f returns false.
+func (st *SwarmTracker) Range(f func(infoHash string, peers []Peer) bool) {
+	st.RLock()
+	swarms := make([]*swarm, 0, st.table.Len())
+	for e := range st.table.Iter() {
+		swarms = append(swarms, e.Value.(*swarm))
+	}
+	st.RUnlock()
+
+	now := st.clock.Now()
+	for _, s := range swarms {
+		st.RLock()
+		peers := st.snapshot(s, now)
+		st.RUnlock()
+
+		if len(peers) > 0 && !f(s.infoHash, peers) {
+			return
+		}
+	}
+}
+
+// Len returns how many infohashes are recorded, including the ones whose
+// peers are out of the window but not pruned yet.
+func (st *SwarmTracker) Len() int {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.table.Len()
+}
+
+// snapshot returns the peers of s observed in the window before now.
+func (st *SwarmTracker) snapshot(s *swarm, now time.Time) []Peer {
+	peers := make([]Peer, 0, s.peers.Len())
+	for e := range s.peers.Iter() {
+		if p := e.Value.(Peer); now.Sub(p.LastSeen()) <= st.window {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// prune removes the peers out of the window at now, and the infohashes
+// without peers left.
+func (st *SwarmTracker) prune(now time.Time) {
+	st.Lock()
+	defer st.Unlock()
+
+	infoHashes := make([]*list.Element, 0, 16)
+	for e := range st.table.Iter() {
+		infoHashes = append(infoHashes, e)
+	}
+
+	for _, e := range infoHashes {
+		queue := e.Value.(*swarm).peers
+
+		// The peers are in the order they're observed, so the ones out of
+		// the window are at the front.
+		for queue.Len() > 0 &&
+			now.Sub(queue.Front().Value.(Peer).LastSeen()) > st.window {
+
+			queue.Remove(queue.Front())
+		}
+
+		if queue.Len() == 0 {
+			st.table.Remove(e)
+		}
+	}
+}
+
+// run prunes the tracker periodically until done is closed.
+func (st *SwarmTracker) run(done chan struct{}) {
+	period := st.window
+	if period > swarmPrunePeriod {
+		period = swarmPrunePeriod
+	}
+
+	tick := st.clock.Tick(period)
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick:
+			st.prune(now)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSwarmTracker(t *testing.T) {
+	clock := newFakeClock()
+	st := NewSwarmTracker(time.Minute*10, 2, 2, clock)
+
+	infoHashes := []string{randomString(20), randomString(20), randomString(20)}
+	peer := func(i byte) Peer {
+		return NewPeer(net.IPv4(1, 1, 1, i), 6881, "")
+	}
+
+	st.Observe(infoHashes[0], peer(1))
+	clock.Advance(time.Minute * 6)
+	st.Observe(infoHashes[0], peer(2))
+	st.Observe(infoHashes[1], peer(1))
+
+	// the first peer is evicted by the third one.
+	st.Observe(infoHashes[0], peer(3))
+	if s := st.Snapshot(infoHashes[0]); len(s) != 2 ||
+		!s[0].IP().Equal(net.IPv4(1, 1, 1, 2)) || !s[1].IP().Equal(net.IPv4(1, 1, 1, 3)) {
+		t.Error(s)
+	}
+
+	// the second infohash is the least recently observed.
+	st.Observe(infoHashes[2], peer(1))
+	if st.Len() != 2 || st.Snapshot(infoHashes[1]) != nil {
+		t.Error(st.Len())
+	}
+
+	// a hex infohash is accepted too.
+	ih, _ := ParseInfoHash(infoHashes[2])
+	if len(st.Snapshot(ih.Hex())) != 1 {
+		t.Fail()
+	}
+
+	// only the peers out of the window are dropped.
+	clock.Advance(time.Minute * 5)
+	st.Observe(infoHashes[0], peer(2))
+	clock.Advance(time.Minute * 6)
+
+	seen := make(map[string]int)
+	st.Range(func(infoHash string, peers []Peer) bool {
+		seen[infoHash] = len(peers)
+		return true
+	})
+	if len(seen) != 1 || seen[infoHashes[0]] != 1 {
+		t.Error(seen)
+	}
+
+	st.prune(clock.Now())
+	if st.Len() != 1 {
+		t.Error(st.Len())
+	}
+}
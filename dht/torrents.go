@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MildC/dht-crawler/torrent"
+	"go.uber.org/zap"
+)
+
+// torrentPipeline fetches the metadata of announced infohashes with a Wire
+// and emits the parsed torrents. The metadata is parsed by DecodeWorkers
+// goroutines, so a slow parse doesn't stall the wire.
+type torrentPipeline struct {
+	once       sync.Once
+	enabled    int32
+	dropped    uint64
+	rejected   uint64
+	discovered uint64
+	maxSize    int
+	workers    int
+	filter     func(infoHash InfoHash) bool
+	wire       *Wire
+	processor  *torrent.Processor
+	seen       *seenFilter
+	clock      Clock
+	torrents   chan torrent.BitTorrent
+}
+
+// newTorrentPipeline returns a torrentPipeline pointer. It does nothing until
+// start is called.
+func newTorrentPipeline(logger *zap.Logger, config *Config) *torrentPipeline {
+	wireConfig := NewWireConfig()
+	wireConfig.BlackListSize = config.BlackListMaxSize
+	wireConfig.RequestQueueSize = config.WireRequestQueueSize
+	wireConfig.WorkerQueueSize = config.WireWorkerLimit
+	wireConfig.RetryQueueSize = config.WireRetryQueueSize
+	wireConfig.MaxMetadataSize = config.MaxInfoSize
+
+	return &torrentPipeline{
+		maxSize:  config.MaxInfoSize,
+		workers:  config.DecodeWorkers,
+		filter:   config.InfohashFilter,
+		wire:     NewWireWithConfig(wireConfig).WithLogger(logger),
+		seen:     newSeenFilter(config.SeenMaxSize),
+		clock:    config.Clock,
+		torrents: make(chan torrent.BitTorrent, config.TorrentsBufferSize),
+	}
+}
+
+// start runs the pipeline once until done is closed.
+func (tp *torrentPipeline) start(done <-chan struct{}) {
+	tp.once.Do(func() {
+		atomic.StoreInt32(&tp.enabled, 1)
+		tp.processor = torrent.NewProcessor(
+			tp.workers, pipelineSink{tp}, tp.decode)
+		go tp.wire.Run()
+		go tp.run(done)
+	})
+}
+
+// run submits the wire responses to the processor until done is closed.
+// Then the wire is closed, and the torrents chan is closed after the
+// processor finishes.
+func (tp *torrentPipeline) run(done <-chan struct{}) {
+	defer close(tp.torrents)
+	defer tp.processor.Close()
+	defer tp.wire.Close()
+
+	for {
+		select {
+		case <-done:
+			return
+		case resp := <-tp.wire.Response():
+			if tp.seen.has(resp.InfoHash.Raw()) {
+				continue
+			}
+
+			tp.processor.Submit(torrent.Metadata{
+				InfoHash: resp.InfoHash[:],
+				Info:     resp.MetadataInfo,
+			})
+		}
+	}
+}
+
+// decode parses the metadata, it's called by the processor workers.
+func (tp *torrentPipeline) decode(m torrent.Metadata) (torrent.BitTorrent, error) {
+	bt, err := ParseMetadata(m.InfoHash, m.Info, tp.maxSize)
+	if err == ErrSizeLimitExceeded {
+		atomic.AddUint64(&tp.rejected, 1)
+	}
+	return bt, err
+}
+
+// pipelineSink is the torrent.Sink of the processor of a torrentPipeline.
+// It pushes the torrents not seen yet to the torrents chan. When the chan is
+// full, the torrent is dropped.
+type pipelineSink struct {
+	tp *torrentPipeline
+}
+
+func (ps pipelineSink) Write(bt torrent.BitTorrent) error {
+	tp := ps.tp
+
+	infoHash, err := hex.DecodeString(bt.InfoHash)
+	if err != nil || !tp.seen.add(string(infoHash), tp.clock.Now()) {
+		return err
+	}
+
+	select {
+	case tp.torrents <- bt:
+		atomic.AddUint64(&tp.discovered, 1)
+	default:
+		atomic.AddUint64(&tp.dropped, 1)
+	}
+	return nil
+}
+
+func (ps pipelineSink) Close() error {
+	return nil
+}
+
+// request asks the wire to fetch the metadata of infoHash from the peer
+// unless the pipeline isn't started, the infohash is already seen or it's
+// rejected by the infohash filter.
+func (tp *torrentPipeline) request(infoHash, ip string, port int) {
+	if atomic.LoadInt32(&tp.enabled) == 0 || tp.seen.has(infoHash) {
+		return
+	}
+
+	ih := rawInfoHash(infoHash)
+	if tp.filter != nil && !tp.filter(ih) {
+		return
+	}
+
+	tp.wire.Request(ih, ip, port)
+}
+
+// Torrents returns a chan of torrents whose metadata is fetched from the
+// peers announcing them. The first call starts the metadata fetching, each
+// infohash is emitted at most once as long as it's remembered by the seen
+// filter(see Config.SeenMaxSize). If the consumer is too slow and the chan is
+// full, torrents are dropped and counted by DroppedTorrents instead of
+// stalling the crawl. The chan is closed after Close is called.
+func (dht *DHT) Torrents() <-chan torrent.BitTorrent {
+	dht.torrentPipeline.start(dht.done)
+	return dht.torrentPipeline.torrents
+}
+
+var _ torrent.TorrentSource = (*DHT)(nil)
+
+// DroppedTorrents returns how many torrents are dropped because the chan
+// returned by Torrents is full.
+func (dht *DHT) DroppedTorrents() uint64 {
+	return atomic.LoadUint64(&dht.torrentPipeline.dropped)
+}
+
+// DiscoveredTorrents returns how many torrents are emitted through the chan
+// returned by Torrents.
+func (dht *DHT) DiscoveredTorrents() uint64 {
+	return atomic.LoadUint64(&dht.torrentPipeline.discovered)
+}
+
+// RejectedTorrents returns how many torrents are rejected because their info
+// dict exceeds Config.MaxInfoSize when decoded.
+func (dht *DHT) RejectedTorrents() uint64 {
+	return atomic.LoadUint64(&dht.torrentPipeline.rejected)
+}
+
+// ExternalIP returns our external ip voted by the peers in the extension
+// handshakes of the metadata fetching, and how many peers vote for it. It
+// returns nil until Torrents is called and a peer tells it.
+func (dht *DHT) ExternalIP() (ip net.IP, votes int) {
+	return dht.torrentPipeline.wire.ExternalIP()
+}
+
+// FirstSeen returns when infoHash, which is 20 bytes or 40 hex characters,
+// is first seen in a get_peers or announce_peer query, whether its metadata
+// is fetched or not. At most Config.SeenMaxSize infohashes are remembered,
+// so it returns false once the infohash is evicted or if it's never seen.
+func (dht *DHT) FirstSeen(infoHash string) (time.Time, bool) {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return dht.firstSeen.firstSeen(ih.Raw())
+}
@@ -0,0 +1,148 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTorrentPipelineFilter(t *testing.T) {
+	config := NewStandardConfig()
+	config.WireRequestQueueSize = 2
+	config.InfohashFilter = func(infoHash InfoHash) bool {
+		return infoHash[0] == 'a'
+	}
+
+	tp := newTorrentPipeline(zap.NewNop(), config)
+	tp.enabled = 1
+
+	tp.request("aaaaaaaaaaaaaaaaaaaa", "1.1.1.1", 6881)
+	tp.request("bbbbbbbbbbbbbbbbbbbb", "1.1.1.1", 6881)
+
+	if len(tp.wire.requests) != 1 {
+		t.Fail()
+	}
+
+	r := <-tp.wire.requests
+	if r.InfoHash.Raw() != "aaaaaaaaaaaaaaaaaaaa" {
+		t.Fail()
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	clock := newFakeClock()
+
+	config := NewStandardConfig()
+	config.SeenMaxSize = 2
+	config.Clock = clock
+
+	// Torrents isn't called, so no metadata is fetched.
+	dht := newHandlerDHT(config)
+	dht.transport = &mockTransport{}
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	id := randomString(20)
+
+	query := func(q DHTQueryType, infoHash string) {
+		payload := NewDHTQuery("aa", q, map[string]interface{}{
+			"id":        id,
+			"info_hash": infoHash,
+			"port":      6881,
+			"token":     dht.tokenManager.token(addr),
+		}).ToPayload()
+
+		if !handleRequest(dht, addr, payload) {
+			t.Fatalf("%s not handled", q)
+		}
+	}
+
+	start := clock.Now()
+	a, b, c := strings.Repeat("a", 20), strings.Repeat("b", 20), strings.Repeat("c", 20)
+
+	query(DHTQueryTypeGetPeers, a)
+	clock.Advance(time.Minute)
+	// b is only announced.
+	query(DHTQueryTypeAnnouncePeer, b)
+	query(DHTQueryTypeAnnouncePeer, a)
+
+	if at, ok := dht.FirstSeen(hex.EncodeToString([]byte(a))); !ok || !at.Equal(start) {
+		t.Errorf("first seen of a: %v %v", at, ok)
+	}
+
+	if at, ok := dht.FirstSeen(b); !ok || at.Sub(start) != time.Minute {
+		t.Errorf("first seen of b: %v %v", at, ok)
+	}
+
+	query(DHTQueryTypeGetPeers, c)
+	if _, ok := dht.FirstSeen(a); ok {
+		t.Error("evicted infohash is remembered")
+	}
+
+	if _, ok := dht.FirstSeen(strings.Repeat("z", 40)); ok {
+		t.Fail()
+	}
+}
+
+func TestTorrentPipelineDecode(t *testing.T) {
+	config := NewStandardConfig()
+	config.DecodeWorkers = 2
+
+	done := make(chan struct{})
+	tp := newTorrentPipeline(zap.NewNop(), config)
+	tp.start(done)
+
+	info := []byte("d4:name3:fooe")
+	for _, infoHash := range []string{"a", "a", "b"} {
+		tp.wire.responses <- Response{
+			Request:      Request{InfoHash: rawInfoHash(strings.Repeat(infoHash, 20))},
+			MetadataInfo: info,
+		}
+	}
+
+	names := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case bt := <-tp.torrents:
+			names[bt.InfoHash]++
+		case <-time.After(time.Second):
+			t.Fatal("torrent not emitted")
+		}
+	}
+
+	close(done)
+	for range tp.torrents {
+		t.Error("duplicate torrent emitted")
+	}
+
+	if len(names) != 2 || tp.discovered != 2 {
+		t.Errorf("unexpected torrents %v", names)
+	}
+}
+
+func TestTorrentPipelineClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := NewStandardConfig()
+	config.WireRetryQueueSize = 1
+
+	done := make(chan struct{})
+	tp := newTorrentPipeline(zap.NewNop(), config)
+	tp.start(done)
+
+	close(done)
+	for range tp.torrents {
+	}
+
+	// the wire, its cleaning goroutines and the decoders are gone.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
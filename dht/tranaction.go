@@ -2,19 +2,35 @@ package dht
 
 import (
 	"sync"
+	"time"
 )
 
 // Query represents the query data included queried node and query-formed data.
 type Query struct {
 	Node Node
 	Data *DHTQuery
+	done chan struct{}
+	// the token in the get_peers response
+	token string
+	// the error in the error response
+	err *KRPCError
+	// whether the target is in the nodes of the find_node response
+	found bool
+	// when the query is sent the first time
+	sentAt time.Time
+	// the time from the first send to the response, which includes the
+	// timed out tries. It's valid if responded is true
+	rtt       time.Duration
+	responded bool
 }
 
 // Transaction implements transaction.
 type Transaction struct {
 	*Query
-	ID       string
-	Response chan struct{}
+	ID         string
+	Response   chan struct{}
+	cancel     chan struct{}
+	cancelOnce sync.Once
 }
 
 // newTransaction creates a new transaction.
@@ -23,23 +39,36 @@ func (tm *transactionManager) newTransaction(id string, q *Query) *Transaction {
 		ID:       id,
 		Query:    q,
 		Response: make(chan struct{}, tm.dht.Try+1),
+		cancel:   make(chan struct{}),
 	}
 }
 
+// Cancel stops waiting for the response. The query is neither retried nor
+// treated as a failure. It's safe to call Cancel more than once.
+func (t *Transaction) Cancel() {
+	t.cancelOnce.Do(func() {
+		close(t.cancel)
+	})
+}
+
+// TransactionMap represents a goroutine-safe map of transactions.
 type TransactionMap struct {
 	*sync.Map
 }
 
+// NewTransactionMap returns a TransactionMap pointer.
 func NewTransactionMap() *TransactionMap {
 	return &TransactionMap{Map: &sync.Map{}}
 }
 
-func (m TransactionMap) Has(id string) bool {
+// Has returns whether the transaction exists.
+func (m *TransactionMap) Has(id string) bool {
 	_, ok := m.Load(id)
 	return ok
 }
 
-func (m TransactionMap) GetTransaction(id string) (t *Transaction, ok bool) {
+// GetTransaction returns the transaction keyed by id.
+func (m *TransactionMap) GetTransaction(id string) (t *Transaction, ok bool) {
 	v, ok := m.Load(id)
 	if v != nil {
 		transaction := v.(*Transaction)
@@ -48,10 +77,24 @@ func (m TransactionMap) GetTransaction(id string) (t *Transaction, ok bool) {
 	return nil, ok
 }
 
-func (m TransactionMap) Len() (count int) {
+// Len returns the number of transactions.
+func (m *TransactionMap) Len() (count int) {
 	m.Range(func(_, v interface{}) bool {
 		count += 1
 		return true
 	})
 	return
 }
+
+// Drain removes all transactions and returns them.
+func (m *TransactionMap) Drain() []*Transaction {
+	transactions := make([]*Transaction, 0)
+
+	m.Range(func(k, v interface{}) bool {
+		if _, loaded := m.LoadAndDelete(k); loaded {
+			transactions = append(transactions, v.(*Transaction))
+		}
+		return true
+	})
+	return transactions
+}
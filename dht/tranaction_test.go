@@ -0,0 +1,146 @@
+package dht
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactionMapDrain(t *testing.T) {
+	m := NewTransactionMap()
+	for _, id := range []string{"a", "b", "c"} {
+		m.Store(id, &Transaction{ID: id, cancel: make(chan struct{})})
+	}
+
+	transactions := m.Drain()
+	if len(transactions) != 3 || m.Len() != 0 {
+		t.Fail()
+	}
+
+	for _, trans := range transactions {
+		trans.Cancel()
+		trans.Cancel()
+
+		select {
+		case <-trans.cancel:
+		default:
+			t.Fail()
+		}
+	}
+}
+
+func TestTransactionIDPrefix(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+
+	for _, prefix := range []string{"", "x"} {
+		config := NewStandardConfig()
+		config.TransactionIDPrefix = prefix
+
+		tm := newHandlerDHT(config).transactionManager
+
+		id := tm.genTransID()
+		if !strings.HasPrefix(id, prefix) ||
+			len(id) != len(prefix)+config.TransactionIDLength {
+			t.Errorf("prefix %q: transaction id %q", prefix, id)
+		}
+
+		trans := tm.newTransaction(id, &Query{Node: NewTempNode(addr),
+			Data: NewDHTQuery(id, DHTQueryTypePing, nil)})
+		tm.insert(trans)
+
+		if tm.filterOne(id, addr) != trans {
+			t.Errorf("prefix %q: transaction not found", prefix)
+		}
+	}
+
+	// A transaction of another instance is never matched, even if the id
+	// without the prefix collides.
+	config := NewStandardConfig()
+	config.TransactionIDPrefix = "x"
+
+	tm := newHandlerDHT(config).transactionManager
+	trans := tm.newTransaction("y\x01", &Query{Node: NewTempNode(addr),
+		Data: NewDHTQuery("y\x01", DHTQueryTypePing, nil)})
+	tm.insert(trans)
+
+	if tm.filterOne("y\x01", addr) != nil {
+		t.Error("foreign transaction id matched")
+	}
+
+	config.TransactionIDPrefix = "xy"
+	if config.Validate() == nil {
+		t.Error("2-byte prefix accepted")
+	}
+}
+
+// fixedTransactionIDs returns the ids in turn.
+type fixedTransactionIDs struct {
+	ids []string
+	i   int
+}
+
+func (gen *fixedTransactionIDs) Next() string {
+	id := gen.ids[gen.i%len(gen.ids)]
+	gen.i++
+	return id
+}
+
+func TestTransactionIDGenerator(t *testing.T) {
+	cursor := NewCursorTransactionIDGenerator(3)
+	for _, id := range []string{"\x01", "\x02", "\x00", "\x01"} {
+		if next := cursor.Next(); next != id {
+			t.Errorf("cursor id %q, want %q", next, id)
+		}
+	}
+
+	random := NewRandomTransactionIDGenerator(8)
+	if a, b := random.Next(), random.Next(); len(a) != 8 || a == b {
+		t.Errorf("random ids %q, %q", a, b)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	newQuery := func(tm *transactionManager, node int) *Query {
+		return &Query{
+			Node: NewTempNode(&net.UDPAddr{IP: addr.IP, Port: node}),
+			Data: NewDHTQuery(tm.genTransID(), DHTQueryTypePing, nil),
+			done: make(chan struct{}),
+		}
+	}
+
+	clock := newFakeClock()
+	config := NewStandardConfig()
+	config.PassiveOnly = true
+	config.Clock = clock
+	config.TransactionIDGenerator = &fixedTransactionIDs{
+		ids: []string{"a", "a", "b"}}
+
+	tm := newHandlerDHT(config).transactionManager
+	first := newQuery(tm, 1)
+	go tm.query(first, 1)
+	for tm.len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the id "a" is in flight, so the second query draws "b" instead.
+	second := newQuery(tm, 2)
+	go tm.query(second, 1)
+	for tm.len() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if second.Data.TransactionID != "b" || tm.getByTransID("b") == nil {
+		t.Errorf("colliding id %q", second.Data.TransactionID)
+	}
+
+	// a query whose ids always collide is given up.
+	config.TransactionIDGenerator.(*fixedTransactionIDs).ids = []string{"a"}
+	third := newQuery(tm, 3)
+	tm.query(third, 1)
+	if tm.len() != 2 {
+		t.Error("colliding transaction inserted")
+	}
+
+	tm.drain()
+	<-first.done
+	<-second.done
+}
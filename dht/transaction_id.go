@@ -0,0 +1,52 @@
+package dht
+
+import (
+	"sync"
+)
+
+// TransactionIDGenerator generates the transaction ids of the queries. The
+// ids should be hard to guess, so a response can't be forged without seeing
+// the query. An id colliding with an in-flight transaction is drawn again.
+type TransactionIDGenerator interface {
+	// Next returns a new transaction id. It's called concurrently.
+	Next() string
+}
+
+// randomTransactionIDGenerator generates random transaction ids.
+type randomTransactionIDGenerator struct {
+	length int
+}
+
+// NewRandomTransactionIDGenerator returns a TransactionIDGenerator whose ids
+// are length bytes from crypto/rand. It's the default one.
+func NewRandomTransactionIDGenerator(length int) TransactionIDGenerator {
+	return randomTransactionIDGenerator{length: length}
+}
+
+// Next returns a random transaction id.
+func (gen randomTransactionIDGenerator) Next() string {
+	return randomString(gen.length)
+}
+
+// cursorTransactionIDGenerator generates sequential transaction ids.
+type cursorTransactionIDGenerator struct {
+	sync.Mutex
+	cursor    uint64
+	maxCursor uint64
+}
+
+// NewCursorTransactionIDGenerator returns a TransactionIDGenerator whose ids
+// are a cursor counting from 1 up to maxCursor - 1 and back to 0, in the
+// shortest big-endian bytes. The ids are short but predictable.
+func NewCursorTransactionIDGenerator(maxCursor uint64) TransactionIDGenerator {
+	return &cursorTransactionIDGenerator{maxCursor: maxCursor}
+}
+
+// Next returns the next cursor as the transaction id.
+func (gen *cursorTransactionIDGenerator) Next() string {
+	gen.Lock()
+	defer gen.Unlock()
+
+	gen.cursor = (gen.cursor + 1) % gen.maxCursor
+	return string(int2bytes(gen.cursor))
+}
@@ -2,6 +2,7 @@ package dht
 
 import (
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,31 +12,40 @@ type transactionManager struct {
 	*sync.RWMutex
 	transactions *TransactionMap
 	index        *TransactionMap
-	cursor       uint64
-	maxCursor    uint64
+	ids          TransactionIDGenerator
 	queryChan    chan *Query
+	limiter      *tokenBucket
+	failures     *failureTracker
+	latency      *latencyHistogram
 	dht          *DHT
 }
 
-// newTransactionManager returns new transactionManager pointer.
-func newTransactionManager(maxCursor uint64, dht *DHT) *transactionManager {
+// newTransactionManager returns new transactionManager pointer whose
+// transaction ids are generated by ids.
+func newTransactionManager(
+	ids TransactionIDGenerator, dht *DHT) *transactionManager {
+
 	return &transactionManager{
 		RWMutex:      &sync.RWMutex{},
 		transactions: NewTransactionMap(),
 		index:        NewTransactionMap(),
-		maxCursor:    maxCursor,
+		ids:          ids,
 		queryChan:    make(chan *Query, 1024),
+		limiter:      newTokenBucket(dht.MaxQueriesPerSecond, dht.QueryBurst),
+		failures:     newFailureTracker(dht.NodeFailureBackoff, dht.Clock),
+		latency:      newLatencyHistogram(),
 		dht:          dht,
 	}
 }
 
-// genTransID generates a transaction id and returns it.
-func (tm *transactionManager) genTransID() string {
-	tm.Lock()
-	defer tm.Unlock()
+// maxTransIDDraws is how many times a transaction id is drawn before the
+// query is given up, when the ids keep colliding with the in-flight ones.
+const maxTransIDDraws = 8
 
-	tm.cursor = (tm.cursor + 1) % tm.maxCursor
-	return string(int2bytes(tm.cursor))
+// genTransID generates a transaction id prefixed by TransactionIDPrefix and
+// returns it.
+func (tm *transactionManager) genTransID() string {
+	return tm.dht.TransactionIDPrefix + tm.ids.Next()
 }
 
 // genIndexKey generates an indexed key which consists of queryType and
@@ -49,13 +59,19 @@ func (tm *transactionManager) genIndexKeyByTrans(trans *Transaction) string {
 	return tm.genIndexKey(trans.Data.QueryType, trans.Node.Address().String())
 }
 
-// insert adds a transaction to transactionManager.
-func (tm *transactionManager) insert(trans *Transaction) {
+// insert adds a transaction to transactionManager. It returns false without
+// adding it if the id is taken by an in-flight transaction.
+func (tm *transactionManager) insert(trans *Transaction) bool {
 	tm.Lock()
 	defer tm.Unlock()
 
+	if _, ok := tm.transactions.GetTransaction(trans.ID); ok {
+		return false
+	}
+
 	tm.transactions.Store(trans.ID, trans)
 	tm.index.Store(tm.genIndexKeyByTrans(trans), trans)
+	return true
 }
 
 // delete removes a transaction from transactionManager.
@@ -72,6 +88,17 @@ func (tm *transactionManager) delete(transID string) {
 	tm.index.Delete(tm.genIndexKeyByTrans(trans))
 }
 
+// drain cancels and removes all transactions.
+func (tm *transactionManager) drain() {
+	tm.Lock()
+	defer tm.Unlock()
+
+	for _, trans := range tm.transactions.Drain() {
+		trans.Cancel()
+	}
+	tm.index.Drain()
+}
+
 // len returns how many transactions are requesting now.
 func (tm *transactionManager) len() int {
 	return tm.transactions.Len()
@@ -101,11 +128,16 @@ func (tm *transactionManager) getByIndex(index string) *Transaction {
 	return tm.transaction(index, 1)
 }
 
-// transaction gets the proper transaction with whose id is transId and
-// address is addr.
+// filterOne gets the proper transaction with whose id is transId and
+// address is addr. The id without TransactionIDPrefix is rejected without
+// the lookup.
 func (tm *transactionManager) filterOne(
 	transID string, addr *net.UDPAddr) *Transaction {
 
+	if !strings.HasPrefix(transID, tm.dht.TransactionIDPrefix) {
+		return nil
+	}
+
 	trans := tm.getByTransID(transID)
 	if trans == nil || trans.Node.Address().String() != addr.String() {
 		return nil
@@ -116,83 +148,174 @@ func (tm *transactionManager) filterOne(
 
 // query sends the query-formed data to udp and wait for the response.
 // When timeout, it will retry `try - 1` times, which means it will query
-// `try` times totally.
+// `try` times totally. Every sending waits for the rate limiter. If the node
+// fails MaxNodeFailures times in a row, it's blacklisted and removed from
+// the routing table, before that it backs off exponentially.
 func (tm *transactionManager) query(q *Query, try int) {
 	// tm.dht.logger.Sugar().Debugf("query %v:%v", q.Node.Address().IP, q.Node.Address().Port)
-	transID := q.Data.TransactionID
-	trans := tm.newTransaction(transID, q)
+	defer close(q.done)
 
-	tm.insert(trans)
+	trans := tm.newTransaction(q.Data.TransactionID, q)
+	for draws := 1; !tm.insert(trans); draws++ {
+		if draws == maxTransIDDraws {
+			return
+		}
+		trans.ID = tm.genTransID()
+		q.Data.TransactionID = trans.ID
+	}
 	defer tm.delete(trans.ID)
 
 	success := false
+Loop:
 	for i := 0; i < try; i++ {
+		tm.limiter.wait()
+
+		// the query queued before a pause isn't sent.
+		if tm.dht.Paused() {
+			return
+		}
+
+		sentAt := tm.dht.Clock.Now()
+		if i == 0 {
+			q.sentAt = sentAt
+		}
 		if err := send(tm.dht, q.Node.Address(), q.Data); err != nil {
 			break
 		}
 
 		select {
 		case <-trans.Response:
+			now := tm.dht.Clock.Now()
+			q.rtt, q.responded = now.Sub(q.sentAt), true
+			tm.latency.record(now.Sub(sentAt))
 			success = true
-			break
-		case <-time.After(time.Second * 15):
+			break Loop
+		case <-trans.cancel:
+			return
+		case <-tm.dht.Clock.After(time.Second * 15):
 		}
 	}
 
-	if !success && q.Node.ID() != nil {
+	address := q.Node.Address().String()
+	if success {
+		tm.failures.reset(address)
+	} else if q.Node.ID() != nil &&
+		tm.failures.fail(address) >= tm.dht.MaxNodeFailures {
+
+		tm.failures.reset(address)
 		tm.dht.blackList.insert(q.Node.Address().IP.String(), q.Node.Address().Port)
-		tm.dht.routingTable.RemoveByAddr(q.Node.Address().String())
+		tm.dht.routingTable.RemoveByAddr(address)
 	}
 }
 
+// LatencyPercentiles returns the 50th, 90th and 99th percentiles of the
+// round-trip times of the answered queries, measured from the last send to
+// the response. They're accurate to within 12.5%.
+func (tm *transactionManager) LatencyPercentiles() (p50, p90, p99 time.Duration) {
+	return tm.latency.percentile(50), tm.latency.percentile(90),
+		tm.latency.percentile(99)
+}
+
+// ResetLatency clears the recorded round-trip times.
+func (tm *transactionManager) ResetLatency() {
+	tm.latency.reset()
+}
+
 // run starts to listen and consume the query chan.
 func (tm *transactionManager) run() {
+	go tm.failures.clear(tm.dht.done)
+
 	for q := range tm.queryChan {
 		go tm.query(q, tm.dht.Try)
 	}
 }
 
-// sendQuery send query-formed data to the chan.
-func (tm *transactionManager) sendQuery(no Node, queryType DHTQueryType, a map[string]interface{}) {
+// sendQuery send query-formed data to the chan. It returns the query whose
+// done chan is closed when the transaction finishes, or nil if the query
+// isn't sent, e.g. PassiveOnly is set or the dht is paused.
+func (tm *transactionManager) sendQuery(no Node, queryType DHTQueryType, a map[string]interface{}) *Query {
 	// If the target is self, then stop.
-	if no.ID() != nil && no.IDRawString() == tm.dht.node.IDRawString() ||
+	if tm.dht.PassiveOnly || tm.dht.Paused() ||
+		no.ID() != nil && no.IDRawString() == tm.dht.node.IDRawString() ||
 		tm.getByIndex(tm.genIndexKey(queryType, no.Address().String())) != nil ||
-		tm.dht.blackList.in(no.Address().IP.String(), no.Address().Port) {
-		return
+		tm.dht.blackList.in(no.Address().IP.String(), no.Address().Port) ||
+		tm.failures.backingOff(no.Address().String()) {
+		return nil
 	}
 
-	tm.queryChan <- &Query{
+	q := &Query{
 		Node: no,
 		Data: NewDHTQuery(tm.genTransID(), queryType, a),
+		done: make(chan struct{}),
 	}
+	tm.queryChan <- q
+
+	return q
 }
 
-// ping sends ping query to the chan.
-func (tm *transactionManager) ping(no Node) {
-	tm.sendQuery(no, DHTQueryTypePing, map[string]interface{}{
-		"id": tm.dht.id(no.IDRawString()),
+// ping sends ping query to the chan. It returns the query, or nil if the
+// query isn't sent.
+func (tm *transactionManager) ping(no Node) *Query {
+	// NOTE: Temporary node has NOT node id.
+	var target string
+	if no.ID() != nil {
+		target = no.IDRawString()
+	}
+
+	return tm.sendQuery(no, DHTQueryTypePing, map[string]interface{}{
+		"id": tm.dht.id(target),
 	})
 }
 
 // findNode sends find_node query to the chan.
-func (tm *transactionManager) findNode(no Node, target string) {
-	tm.sendQuery(no, DHTQueryTypeFindNode, map[string]interface{}{
+// It returns a chan which is closed when the transaction finishes, or nil if
+// the query isn't sent.
+func (tm *transactionManager) findNode(no Node, target string) <-chan struct{} {
+	q := tm.findNodeQuery(no, target)
+	if q == nil {
+		return nil
+	}
+	return q.done
+}
+
+// findNodeQuery sends find_node query to the chan. It returns the query, or
+// nil if the query isn't sent.
+func (tm *transactionManager) findNodeQuery(no Node, target string) *Query {
+	return tm.sendQuery(no, DHTQueryTypeFindNode, map[string]interface{}{
 		"id":     tm.dht.id(target),
 		"target": target,
+		"want":   tm.dht.want(),
 	})
 }
 
-// getPeers sends get_peers query to the chan.
-func (tm *transactionManager) getPeers(no Node, infoHash string) {
-	tm.sendQuery(no, DHTQueryTypeGetPeers, map[string]interface{}{
+// getPeers sends get_peers query to the chan. It returns the query, or nil
+// if the query isn't sent. The scrape filters are requested if OnScrape is
+// set.
+func (tm *transactionManager) getPeers(no Node, infoHash string) *Query {
+	a := map[string]interface{}{
 		"id":        tm.dht.id(infoHash),
 		"info_hash": infoHash,
+		"want":      tm.dht.want(),
+	}
+	if tm.dht.OnScrape != nil {
+		a["scrape"] = 1
+	}
+
+	return tm.sendQuery(no, DHTQueryTypeGetPeers, a)
+}
+
+// sampleInfohashes sends sample_infohashes query to the chan.
+func (tm *transactionManager) sampleInfohashes(no Node, target string) {
+	tm.sendQuery(no, DHTQueryTypeSampleInfohashes, map[string]interface{}{
+		"id":     tm.dht.id(target),
+		"target": target,
 	})
 }
 
-// announcePeer sends announce_peer query to the chan.
-func (tm *transactionManager) AnnouncePeer(no Node, infoHash string, impliedPort, port int, token string) {
-	tm.sendQuery(no, DHTQueryTypeAnnouncePeer, map[string]interface{}{
+// announcePeer sends announce_peer query to the chan. It returns the query,
+// or nil if the query isn't sent.
+func (tm *transactionManager) AnnouncePeer(no Node, infoHash string, impliedPort, port int, token string) *Query {
+	return tm.sendQuery(no, DHTQueryTypeAnnouncePeer, map[string]interface{}{
 		"id":           tm.dht.id(no.IDRawString()),
 		"info_hash":    infoHash,
 		"implied_port": impliedPort,
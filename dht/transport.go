@@ -0,0 +1,57 @@
+package dht
+
+import (
+	"net"
+	"time"
+)
+
+// Transport carries the KRPC packets of the DHT. The default one is a udp
+// socket listening on Config.Network and Config.Address. Another one can
+// layer e.g. encryption or a proxy over udp, or run in memory in tests, see
+// the dhttest package.
+type Transport interface {
+	// WriteTo writes a packet to addr.
+	WriteTo(b []byte, addr *net.UDPAddr) error
+	// ReadFrom reads a packet into b, it blocks until a packet arrives. A
+	// packet longer than b is truncated.
+	ReadFrom(b []byte) (n int, addr *net.UDPAddr, err error)
+	// Close closes the transport, the blocked ReadFrom returns an error.
+	Close() error
+}
+
+// udpTransport is the default Transport. The packets are written by
+// batchWriter if it's set, see Config.WriteBatchWindow.
+type udpTransport struct {
+	conn        *net.UDPConn
+	batchWriter *batchWriter
+}
+
+// NewUDPTransport returns a Transport over conn.
+func NewUDPTransport(conn *net.UDPConn) Transport {
+	return &udpTransport{conn: conn}
+}
+
+// WriteTo writes a packet to addr, it fails if the socket doesn't become
+// writable in 15 seconds. A batched packet is queued, and its failure is
+// reported by the batchWriter.
+func (t *udpTransport) WriteTo(b []byte, addr *net.UDPAddr) error {
+	if t.batchWriter != nil {
+		t.batchWriter.write(b, addr)
+		return nil
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+
+	_, err := t.conn.WriteToUDP(b, addr)
+	return err
+}
+
+// ReadFrom reads a packet from the socket.
+func (t *udpTransport) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	return t.conn.ReadFromUDP(b)
+}
+
+// Close closes the socket.
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,92 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// mockTransport records the written packets, and fails the writes if err
+// is set.
+type mockTransport struct {
+	written []string
+	err     error
+}
+
+func (t *mockTransport) WriteTo(b []byte, addr *net.UDPAddr) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.written = append(t.written, string(b))
+	return nil
+}
+
+func (t *mockTransport) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	return 0, nil, errors.New("not readable")
+}
+
+func (t *mockTransport) Close() error {
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 6881}
+	ping := NewDHTQuery("aa", DHTQueryTypePing, map[string]interface{}{
+		"id": randomString(20),
+	})
+
+	cases := []struct {
+		err         error
+		blacklisted bool
+	}{
+		{nil, false},
+		{errors.New("unreachable"), true},
+	}
+
+	for _, c := range cases {
+		dht := newHandlerDHT(NewStandardConfig())
+		transport := &mockTransport{err: c.err}
+		dht.transport = transport
+
+		if err := send(dht, addr, ping); err != c.err {
+			t.Error(err)
+		}
+		if c.err == nil && (len(transport.written) != 1 ||
+			transport.written[0] != Encode(ping.ToPayload())) {
+
+			t.Error(transport.written)
+		}
+		if dht.blackList.in(addr.IP.String(), addr.Port) != c.blacklisted {
+			t.Errorf("blacklisted with %v", c.err)
+		}
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	a, peer := newUDPPeer(t)
+	defer peer.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := NewUDPTransport(conn)
+	defer transport.Close()
+
+	if err := transport.WriteTo([]byte("ping"), a.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	buff := make([]byte, 8)
+	n, from, err := peer.ReadFromUDP(buff)
+	if err != nil || string(buff[:n]) != "ping" {
+		t.Fatal(string(buff[:n]), err)
+	}
+
+	peer.WriteToUDP([]byte("pong"), from)
+	if n, raddr, err := transport.ReadFrom(buff); err != nil ||
+		string(buff[:n]) != "pong" || raddr.Port != a.Address().Port {
+
+		t.Error(string(buff[:n]), raddr, err)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -50,20 +49,27 @@ func int2bytes(val uint64) []byte {
 }
 
 // decodeCompactIPPortInfo decodes compactIP-address/port info in BitTorrent
-// DHT Protocol. It returns the ip and port number.
+// DHT Protocol. The info is 6 bytes for IPv4 and 18 bytes for IPv6. It
+// returns the ip and port number.
 func decodeCompactIPPortInfo(info string) (ip net.IP, port int, err error) {
-	if len(info) != 6 {
-		err = errors.New("compact info should be 6-length long")
+	switch len(info) {
+	case 6:
+		ip = net.IPv4(info[0], info[1], info[2], info[3])
+	case 18:
+		ip = net.IP(info[:16])
+	default:
+		err = errors.New("compact info should be 6-length or 18-length long")
 		return
 	}
 
-	ip = net.IPv4(info[0], info[1], info[2], info[3])
-	port = int((uint16(info[4]) << 8) | uint16(info[5]))
+	n := len(info)
+	port = int((uint16(info[n-2]) << 8) | uint16(info[n-1]))
 	return
 }
 
 // encodeCompactIPPortInfo encodes an ip and a port number to
-// compactIP-address/port info.
+// compactIP-address/port info. An IPv4 address takes 4 bytes even if it's in
+// the 16-byte form, and an IPv6 address takes 16 bytes.
 func encodeCompactIPPortInfo(ip net.IP, port int) (info string, err error) {
 	if port > 65535 || port < 0 {
 		err = errors.New(
@@ -71,6 +77,13 @@ func encodeCompactIPPortInfo(ip net.IP, port int) (info string, err error) {
 		return
 	}
 
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if ip = ip.To16(); ip == nil {
+		err = errors.New("invalid ip")
+		return
+	}
+
 	p := int2bytes(uint64(port))
 	if len(p) < 2 {
 		p = append(p, p[0])
@@ -130,5 +143,5 @@ func getRemoteIP() (ip string, err error) {
 
 // genAddress returns a ip:port address.
 func genAddress(ip string, port int) string {
-	return strings.Join([]string{ip, strconv.Itoa(port)}, ":")
+	return net.JoinHostPort(ip, strconv.Itoa(port))
 }
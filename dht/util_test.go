@@ -1,6 +1,8 @@
 package dht
 
 import (
+	"net"
+	"strings"
 	"testing"
 )
 
@@ -89,6 +91,14 @@ func TestEncodeCompactIPPortInfo(t *testing.T) {
 			ip   []byte
 			port int
 		}{[]byte{97, 98, 99, 100}, 25958}, "abcdef"},
+		{struct {
+			ip   []byte
+			port int
+		}{net.IPv4(49, 50, 51, 52), 13622}, "123456"},
+		{struct {
+			ip   []byte
+			port int
+		}{net.ParseIP("3132::3334"), 13622}, "12" + strings.Repeat("\x00", 12) + "3456"},
 	}
 
 	for _, item := range cases {
@@ -98,3 +108,35 @@ func TestEncodeCompactIPPortInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeCompactIPPortInfoIPv6(t *testing.T) {
+	ip := net.ParseIP("2001:db8::1")
+
+	cases := []struct {
+		in   string
+		ip   net.IP
+		port int
+		ok   bool
+	}{
+		{string(ip) + "\x1a\xe1", ip, 6881, true},
+		{string(ip) + "\x1a", nil, 0, false},
+		{"12345", nil, 0, false},
+	}
+
+	for _, c := range cases {
+		ip, port, err := decodeCompactIPPortInfo(c.in)
+		if (err == nil) != c.ok || c.ok && (!ip.Equal(c.ip) || port != c.port) {
+			t.Errorf("decodeCompactIPPortInfo(%q) = %v, %d, %v", c.in, ip, port, err)
+		}
+	}
+
+	p, err := NewPeerFromCompactIPPortInfo(string(ip)+"\x1a\xe1", "token")
+	if err != nil || !p.IP().Equal(ip) || p.Port() != 6881 {
+		t.Error(p, err)
+	}
+
+	info, err := encodeCompactIPPortInfo(ip, 6881)
+	if err != nil || info != string(ip)+"\x1a\xe1" {
+		t.Error(info, err)
+	}
+}
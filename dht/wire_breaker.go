@@ -0,0 +1,81 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker sheds the requests of a Wire while most fetches fail. The
+// finished fetches are counted in windows of window fetches, at the end of
+// every window the breaker opens for cooldown if the failure rate reaches
+// failureRate. The fetches finishing while it's open aren't counted.
+type circuitBreaker struct {
+	sync.Mutex
+	failureRate float64
+	window      int
+	cooldown    time.Duration
+	successes   int
+	failures    int
+	openUntil   time.Time
+}
+
+// newCircuitBreaker returns a circuitBreaker pointer. It never opens if
+// failureRate is not positive or window is not positive.
+func newCircuitBreaker(failureRate float64, window int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureRate: failureRate,
+		window:      window,
+		cooldown:    cooldown,
+	}
+}
+
+// enabled returns whether the breaker can open.
+func (cb *circuitBreaker) enabled() bool {
+	return cb.failureRate > 0 && cb.window > 0
+}
+
+// allow returns whether the breaker is closed.
+func (cb *circuitBreaker) allow() bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	return !time.Now().Before(cb.openUntil)
+}
+
+// record counts a finished fetch. It returns whether the breaker opens.
+func (cb *circuitBreaker) record(ok bool) bool {
+	if !cb.enabled() {
+		return false
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	now := time.Now()
+	if now.Before(cb.openUntil) {
+		return false
+	}
+
+	if ok {
+		cb.successes++
+	} else {
+		cb.failures++
+	}
+
+	total := cb.successes + cb.failures
+	if total < cb.window {
+		return false
+	}
+
+	tripped := float64(cb.failures)/float64(total) >= cb.failureRate
+	if tripped {
+		cb.openUntil = now.Add(cb.cooldown)
+	}
+
+	cb.successes, cb.failures = 0, 0
+	return tripped
+}
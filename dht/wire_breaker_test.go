@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker(0.5, 4, time.Minute)
+
+	// 1 failure out of 4 keeps it closed.
+	for _, ok := range []bool{true, false, true, true} {
+		if cb.record(ok) {
+			t.Error("opened below the failure rate")
+		}
+	}
+
+	// 2 failures out of 4 opens it at the end of the window.
+	for i, ok := range []bool{false, true, false, true} {
+		if cb.record(ok) != (i == 3) {
+			t.Errorf("unexpected state after %d fetches", i+1)
+		}
+	}
+
+	if cb.allow() {
+		t.Error("open breaker allows requests")
+	}
+
+	cb.openUntil = time.Now()
+	if !cb.allow() {
+		t.Error("breaker not closed after the cooldown")
+	}
+
+	disabled := newCircuitBreaker(0, 4, time.Minute)
+	for i := 0; i < 8; i++ {
+		if disabled.record(false) {
+			t.Fail()
+		}
+	}
+}
+
+func TestWireRequestShedding(t *testing.T) {
+	config := NewWireConfig()
+	config.RequestQueueSize = 1
+	config.BreakerFailureRate = 0.5
+	config.BreakerWindow = 1
+
+	wire := NewWireWithConfig(config)
+
+	if wire.Request(rawInfoHash(randomString(20)), "1.1.1.1", 6881) != nil {
+		t.Fatal("request not queued")
+	}
+
+	if err := wire.Request(rawInfoHash(randomString(20)), "1.1.1.1", 6881); err != ErrWireQueueFull {
+		t.Errorf("full queue: %v", err)
+	}
+
+	wire.recordFetch(false)
+	<-wire.requests
+
+	if err := wire.Request(rawInfoHash(randomString(20)), "1.1.1.1", 6881); err != ErrWireOverloaded {
+		t.Errorf("open breaker: %v", err)
+	}
+
+	stats := wire.Stats()
+	if stats.Requests != 1 || stats.Shed != 2 || stats.BreakerTrips != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,62 @@
+package dht
+
+import (
+	"net"
+	"sync"
+)
+
+// maxIPCandidates is how many distinct ips an ipVoter counts votes for.
+const maxIPCandidates = 64
+
+// ipVoter elects our external ip from the yourip fields of the extension
+// handshakes(BEP 10). Every handshake votes for the ip the peer sees us at,
+// and the ip with the most votes wins, so a few lying peers can't change it.
+type ipVoter struct {
+	sync.Mutex
+	votes map[string]int
+}
+
+// newIPVoter returns an ipVoter pointer.
+func newIPVoter() *ipVoter {
+	return &ipVoter{votes: make(map[string]int)}
+}
+
+// vote adds a vote for ip. If there are already maxIPCandidates candidates,
+// the one with the fewest votes is dropped to make room for ip.
+func (iv *ipVoter) vote(ip net.IP) {
+	iv.Lock()
+	defer iv.Unlock()
+
+	key := ip.String()
+	if _, ok := iv.votes[key]; !ok && len(iv.votes) >= maxIPCandidates {
+		fewest, min := "", 0
+		for k, n := range iv.votes {
+			if fewest == "" || n < min {
+				fewest, min = k, n
+			}
+		}
+		delete(iv.votes, fewest)
+	}
+	iv.votes[key]++
+}
+
+// result returns the ip with the most votes and its votes. It returns nil
+// if there is no vote.
+func (iv *ipVoter) result() (ip net.IP, votes int) {
+	iv.Lock()
+	defer iv.Unlock()
+
+	for k, n := range iv.votes {
+		if n > votes || (n == votes && k < ip.String()) {
+			ip, votes = net.ParseIP(k), n
+		}
+	}
+	return
+}
+
+// ExternalIP returns our external ip voted by the peers in the extension
+// handshakes, and how many peers vote for it. It returns nil if no peer has
+// told it yet.
+func (wire *Wire) ExternalIP() (ip net.IP, votes int) {
+	return wire.ipVoter.result()
+}
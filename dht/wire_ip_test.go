@@ -0,0 +1,47 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPVoter(t *testing.T) {
+	iv := newIPVoter()
+	if ip, votes := iv.result(); ip != nil || votes != 0 {
+		t.Fail()
+	}
+
+	for _, ip := range []net.IP{
+		net.IPv4(1, 1, 1, 1),
+		net.IPv4(2, 2, 2, 2),
+		net.IPv4(1, 1, 1, 1),
+		net.ParseIP("2001:db8::1"),
+	} {
+		iv.vote(ip)
+	}
+
+	if ip, votes := iv.result(); !ip.Equal(net.IPv4(1, 1, 1, 1)) || votes != 2 {
+		t.Errorf("%v wins with %d votes", ip, votes)
+	}
+
+	// the ips with one vote are dropped first.
+	for i := 0; i < maxIPCandidates*2; i++ {
+		iv.vote(net.IPv4(10, 0, byte(i>>8), byte(i)))
+	}
+
+	if len(iv.votes) != maxIPCandidates || iv.votes["1.1.1.1"] != 2 {
+		t.Errorf("%d candidates left", len(iv.votes))
+	}
+}
+
+func TestDHTExternalIP(t *testing.T) {
+	d := New(nil, nil)
+	if ip, votes := d.ExternalIP(); ip != nil || votes != 0 {
+		t.Fail()
+	}
+
+	d.torrentPipeline.wire.ipVoter.vote(net.IPv4(1, 1, 1, 1))
+	if ip, votes := d.ExternalIP(); !ip.Equal(net.IPv4(1, 1, 1, 1)) || votes != 1 {
+		t.Errorf("%v with %d votes", ip, votes)
+	}
+}
@@ -0,0 +1,87 @@
+package dht
+
+import (
+	"sync"
+)
+
+// fetchJob represents the fetching of an infohash. It holds the peers
+// announcing the infohash which aren't tried yet, at most maxSources peers
+// in total, as the later ones would never be tried.
+type fetchJob struct {
+	infoHash InfoHash
+	sources  []Request
+	known    map[string]bool
+	tried    int
+}
+
+// fetchJobs coalesces the requests of the same infohash into one fetchJob,
+// so the peers of a running job become its fallback sources.
+type fetchJobs struct {
+	sync.Mutex
+	jobs       map[string]*fetchJob
+	maxSources int
+}
+
+// newFetchJobs returns a fetchJobs pointer whose jobs take at most
+// maxSources peers each.
+func newFetchJobs(maxSources int) *fetchJobs {
+	if maxSources < 1 {
+		maxSources = 1
+	}
+	return &fetchJobs{jobs: make(map[string]*fetchJob), maxSources: maxSources}
+}
+
+// add adds the peer of r to the job of its infohash. It returns the job and
+// whether the job is new. A peer known by the job is ignored, and so is any
+// peer once the job has taken maxSources peers.
+func (fj *fetchJobs) add(r Request) (job *fetchJob, isNew bool) {
+	fj.Lock()
+	defer fj.Unlock()
+
+	key := r.InfoHash.Raw()
+	address := genAddress(r.IP, r.Port)
+
+	job, ok := fj.jobs[key]
+	if !ok {
+		job = &fetchJob{infoHash: r.InfoHash, known: make(map[string]bool)}
+		fj.jobs[key] = job
+	}
+
+	if !job.known[address] && len(job.known) < fj.maxSources {
+		job.known[address] = true
+		job.sources = append(job.sources, r)
+	}
+	return job, !ok
+}
+
+// next pops the next source of the job. It returns false if there is no
+// source left.
+func (fj *fetchJobs) next(job *fetchJob) (r Request, ok bool) {
+	fj.Lock()
+	defer fj.Unlock()
+
+	if len(job.sources) == 0 {
+		return
+	}
+
+	r, job.sources = job.sources[0], job.sources[1:]
+	job.tried++
+	return r, true
+}
+
+// done removes the job, then a later request of the infohash starts a new
+// job.
+func (fj *fetchJobs) done(job *fetchJob) {
+	fj.Lock()
+	defer fj.Unlock()
+
+	delete(fj.jobs, job.infoHash.Raw())
+}
+
+// len returns how many jobs are running.
+func (fj *fetchJobs) len() int {
+	fj.Lock()
+	defer fj.Unlock()
+
+	return len(fj.jobs)
+}
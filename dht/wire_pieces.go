@@ -0,0 +1,83 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+)
+
+// metadataPieces assembles the metadata pieces(see
+// http://www.bittorrent.org/beps/bep_0009.html) of an info dict whose size
+// is advertised in the extension handshake. Every piece is BLOCK bytes
+// except the last one, and the received pieces are tracked in a bitfield.
+type metadataPieces struct {
+	size     int
+	received *bitmap
+	count    int
+	pieces   [][]byte
+}
+
+// newMetadataPieces returns a metadataPieces pointer of the metadata of
+// size bytes.
+func newMetadataPieces(size int) *metadataPieces {
+	num := (size + BLOCK - 1) / BLOCK
+
+	return &metadataPieces{
+		size:     size,
+		received: newBitmap(num),
+		pieces:   make([][]byte, num),
+	}
+}
+
+// num returns how many pieces the metadata has.
+func (mp *metadataPieces) num() int {
+	return len(mp.pieces)
+}
+
+// pieceLen returns the length piece i should have.
+func (mp *metadataPieces) pieceLen(i int) int {
+	if i == mp.num()-1 {
+		return mp.size - i*BLOCK
+	}
+	return BLOCK
+}
+
+// put stores piece i. It returns an error if i is beyond the metadata size
+// or data has a wrong length. A piece received before is ignored.
+func (mp *metadataPieces) put(i int, data []byte) error {
+	if i < 0 || i >= mp.num() {
+		return errors.New("piece beyond metadata_size")
+	}
+
+	if len(data) != mp.pieceLen(i) {
+		return errors.New("invalid piece length")
+	}
+
+	if mp.received.Bit(i) == 1 {
+		return nil
+	}
+
+	mp.received.Set(i)
+	mp.pieces[i] = data
+	mp.count++
+	return nil
+}
+
+// next returns the first piece not received yet, or -1 if all are received.
+func (mp *metadataPieces) next() int {
+	for i := 0; i < mp.num(); i++ {
+		if mp.received.Bit(i) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// done returns whether all the pieces are received.
+func (mp *metadataPieces) done() bool {
+	return mp.count == mp.num()
+}
+
+// join returns the metadata. It should be called only if done returns true.
+func (mp *metadataPieces) join() []byte {
+	return bytes.Join(mp.pieces, nil)
+}
@@ -0,0 +1,135 @@
+package dht
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// retryMaxPending is the max new sources held for an infohash during its
+// backoff.
+const retryMaxPending = 8
+
+// retryEntry is an infohash whose fetch failed. The new sources of it which
+// arrive during the backoff are held until the backoff ends.
+type retryEntry struct {
+	firstFailed time.Time
+	nextTry     time.Time
+	failures    int
+	pending     []Request
+}
+
+// retryQueue holds the infohashes whose fetches failed, so the peers
+// announcing them later retry the fetches with backoff. An infohash is
+// given up lifetime after its first failure, and the oldest one is evicted
+// when there are more than maxSize. The backoff doubles after every
+// failure.
+type retryQueue struct {
+	sync.Mutex
+	entries  *keyedDeque
+	maxSize  int
+	backoff  time.Duration
+	lifetime time.Duration
+}
+
+// newRetryQueue returns a retryQueue pointer.
+func newRetryQueue(maxSize int, backoff, lifetime time.Duration) *retryQueue {
+	return &retryQueue{
+		entries:  newKeyedDeque(),
+		maxSize:  maxSize,
+		backoff:  backoff,
+		lifetime: lifetime,
+	}
+}
+
+// fail records a failed fetch of infoHash at now.
+func (rq *retryQueue) fail(infoHash InfoHash, now time.Time) {
+	rq.Lock()
+	defer rq.Unlock()
+
+	key := infoHash.Raw()
+	entry := &retryEntry{firstFailed: now}
+	if e, ok := rq.entries.Get(key); ok {
+		entry = e.Value.(*retryEntry)
+	} else {
+		rq.entries.Push(key, entry)
+	}
+
+	if now.Sub(entry.firstFailed) > rq.lifetime {
+		rq.entries.Delete(key)
+		return
+	}
+
+	entry.nextTry = now.Add(rq.backoff << uint(entry.failures))
+	entry.failures++
+
+	for rq.entries.Len() > rq.maxSize {
+		rq.entries.Remove(rq.entries.Front())
+	}
+}
+
+// succeed forgets infoHash once its metadata is fetched.
+func (rq *retryQueue) succeed(infoHash InfoHash) {
+	rq.Lock()
+	defer rq.Unlock()
+
+	rq.entries.Delete(infoHash.Raw())
+}
+
+// hold holds r until the backoff of its infohash ends. It returns false if
+// the fetch of r can start now, i.e. the infohash isn't backing off.
+func (rq *retryQueue) hold(r Request, now time.Time) bool {
+	rq.Lock()
+	defer rq.Unlock()
+
+	e, ok := rq.entries.Get(r.InfoHash.Raw())
+	if !ok {
+		return false
+	}
+
+	entry := e.Value.(*retryEntry)
+	if !now.Before(entry.nextTry) {
+		return false
+	}
+
+	if len(entry.pending) < retryMaxPending {
+		entry.pending = append(entry.pending, r)
+	}
+	return true
+}
+
+// due returns the held requests whose backoff has ended at now, and gives
+// up the infohashes beyond the lifetime.
+func (rq *retryQueue) due(now time.Time) []Request {
+	rq.Lock()
+	defer rq.Unlock()
+
+	var (
+		requests []Request
+		expired  []*list.Element
+	)
+
+	for e := range rq.entries.Iter() {
+		entry := e.Value.(*retryEntry)
+
+		if now.Sub(entry.firstFailed) > rq.lifetime {
+			expired = append(expired, e)
+		} else if len(entry.pending) > 0 && !now.Before(entry.nextTry) {
+			requests = append(requests, entry.pending...)
+			entry.pending = nil
+		}
+	}
+
+	for _, e := range expired {
+		rq.entries.Remove(e)
+	}
+	return requests
+}
+
+// len returns how many infohashes are held.
+func (rq *retryQueue) len() int {
+	rq.Lock()
+	defer rq.Unlock()
+
+	return rq.entries.Len()
+}
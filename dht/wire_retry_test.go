@@ -0,0 +1,56 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryQueue(t *testing.T) {
+	rq := newRetryQueue(2, time.Second, time.Minute)
+	now := time.Now()
+
+	r := Request{InfoHash: rawInfoHash(randomString(20)), IP: "1.1.1.1", Port: 6881}
+	if rq.hold(r, now) {
+		t.Error("request held without failure")
+	}
+
+	rq.fail(r.InfoHash, now)
+
+	// the new peers are held during the backoff, and tried after it.
+	if !rq.hold(r, now.Add(time.Millisecond*500)) || rq.due(now) != nil {
+		t.Error("request not held")
+	}
+	if due := rq.due(now.Add(time.Second)); len(due) != 1 || due[0].IP != r.IP ||
+		rq.due(now.Add(time.Second)) != nil {
+		t.Errorf("due requests %v", due)
+	}
+	if rq.hold(r, now.Add(time.Second)) {
+		t.Error("request held after backoff")
+	}
+
+	// the backoff doubles.
+	rq.fail(r.InfoHash, now.Add(time.Second))
+	if !rq.hold(r, now.Add(time.Second*2)) || rq.hold(r, now.Add(time.Second*3)) {
+		t.Error("backoff not doubled")
+	}
+
+	// the infohash is given up beyond the lifetime.
+	if rq.due(now.Add(time.Minute*2)) != nil || rq.len() != 0 {
+		t.Error("infohash not given up")
+	}
+
+	rq.fail(r.InfoHash, now)
+	rq.succeed(r.InfoHash)
+	if rq.len() != 0 {
+		t.Error("fetched infohash held")
+	}
+
+	// the oldest infohash is evicted.
+	first := rawInfoHash(randomString(20))
+	rq.fail(first, now)
+	rq.fail(rawInfoHash(randomString(20)), now)
+	rq.fail(rawInfoHash(randomString(20)), now)
+	if rq.len() != 2 || rq.hold(Request{InfoHash: first}, now) {
+		t.Error("oldest infohash not evicted")
+	}
+}
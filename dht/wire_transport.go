@@ -0,0 +1,49 @@
+package dht
+
+import (
+	"net"
+	"time"
+)
+
+// WireTransport dials the peers which Wire fetches metadata from. The BEP 9
+// and BEP 10 messages are exchanged over the returned connection, so any
+// reliable stream transport works, e.g. TCP or uTP. Only TCP is built in,
+// the module doesn't depend on a uTP implementation, so a uTP dialer, e.g.
+// the DialContext of a github.com/anacrolix/utp socket, is plugged in by
+// WireTransportFunc.
+type WireTransport interface {
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// WireTransportFunc is an adapter to allow the use of ordinary functions as
+// WireTransport.
+type WireTransportFunc func(address string, timeout time.Duration) (net.Conn, error)
+
+// Dial calls f(address, timeout).
+func (f WireTransportFunc) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return f(address, timeout)
+}
+
+// tcpTransport is the default WireTransport.
+type tcpTransport struct{}
+
+// NewTCPTransport returns a WireTransport dialing peers over TCP.
+func NewTCPTransport() WireTransport {
+	return tcpTransport{}
+}
+
+// Dial connects to address over TCP.
+func (tcpTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.(*net.TCPConn).SetLinger(0)
+	return conn, nil
+}
+
+var (
+	_ WireTransport = tcpTransport{}
+	_ WireTransport = WireTransportFunc(nil)
+)
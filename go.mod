@@ -4,11 +4,19 @@ go 1.17
 
 require (
 	github.com/elastic/go-elasticsearch/v8 v8.0.0
+	go.etcd.io/bbolt v1.3.7
 	go.uber.org/zap v1.21.0
+	golang.org/x/net v0.11.0
+	google.golang.org/grpc v1.54.0
+	google.golang.org/protobuf v1.30.0
 )
 
 require (
 	github.com/elastic/elastic-transport-go/v8 v8.0.0-alpha // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/sys v0.9.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 )
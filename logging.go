@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/buffer"
@@ -47,15 +48,88 @@ func init() {
 	}
 }
 
-func NewConsoleLogger() *zap.Logger {
+// levelCore passes only the entries of the levels enabled by LevelEnabler to
+// the Core.
+type levelCore struct {
+	zapcore.Core
+	zapcore.LevelEnabler
+}
+
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	return c.LevelEnabler.Enabled(level) && c.Core.Enabled(level)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{c.Core.With(fields), c.LevelEnabler}
+}
+
+func (c levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.LevelEnabler.Enabled(entry.Level) {
+		return ce
+	}
+	return c.Core.Check(entry, ce)
+}
+
+// sampleOption returns the option sampling the entries below the warn
+// level, such as the debug logs of every query or dropped packet: every
+// second, the first sampling.Initial entries of a message are logged, then
+// every sampling.Thereafter-th one. The warnings and errors are always
+// logged, and so are the rare messages since they never exceed Initial. No
+// sampling if sampling is nil.
+func sampleOption(sampling *zap.SamplingConfig) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if sampling == nil {
+			return core
+		}
+
+		sampled := zapcore.NewSamplerWithOptions(
+			core, time.Second, sampling.Initial, sampling.Thereafter)
+
+		return zapcore.NewTee(
+			levelCore{sampled, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return l < zapcore.WarnLevel
+			})},
+			levelCore{core, zapcore.WarnLevel},
+		)
+	})
+}
+
+// NewConsoleLogger returns a logger writing colored messages to stderr at the
+// debug level, sampled by sampling, see sampleOption.
+func NewConsoleLogger(sampling *zap.SamplingConfig) *zap.Logger {
 	config := zap.NewDevelopmentConfig()
 	config.Encoding = colorMsgEncoding
 	config.EncoderConfig.LevelKey = zapcore.OmitKey
 	config.EncoderConfig.CallerKey = zapcore.OmitKey
-	logger, _ := config.Build()
+	logger, _ := config.Build(sampleOption(sampling))
+	return logger
+}
+
+// NewProductionLogger returns a logger writing JSON lines to stderr with the
+// ISO8601 timestamp under "ts" and the level under "level", so the logs can
+// be shipped to a log aggregator. It's sampled by sampling instead of the
+// zap default, see sampleOption.
+func NewProductionLogger(sampling *zap.SamplingConfig) *zap.Logger {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	config.Sampling = nil
+	logger, _ := config.Build(sampleOption(sampling))
 	return logger
 }
 
+// NewLogger returns the logger of format, which is "json" for
+// NewProductionLogger or "console" for NewConsoleLogger.
+func NewLogger(format string, sampling *zap.SamplingConfig) (*zap.Logger, error) {
+	switch format {
+	case "json":
+		return NewProductionLogger(sampling), nil
+	case "console", "":
+		return NewConsoleLogger(sampling), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
 var (
 	_ zapcore.Encoder = &colorMsgEncoder{}
 )
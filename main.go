@@ -1,69 +1,131 @@
 package main
 
 import (
-	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/MildC/dht-crawler/dht"
 	"github.com/MildC/dht-crawler/torrent"
+	"go.uber.org/zap"
 )
 
+var (
+	blocklistPath = flag.String("blocklist", "",
+		"the file of the blocked hex infohashes, one per line, reloaded on SIGHUP")
+	logFormat = flag.String("log-format", os.Getenv("DHT_LOG_FORMAT"),
+		"console or json, defaults to $DHT_LOG_FORMAT or console")
+	logSampleFirst = flag.Int("log-sample-first", 100,
+		"how many debug and info logs of a message are kept every second, no sampling if it's 0")
+	logSampleThereafter = flag.Int("log-sample-thereafter", 100,
+		"keep 1 of this many debug and info logs of a message beyond -log-sample-first")
+	statsdAddr = flag.String("statsd", "",
+		"the host:port of a statsd server the metrics are flushed to every 10 seconds")
+)
+
+// watchBlocklist loads the blocklist from path, and reloads it whenever the
+// process receives SIGHUP.
+func watchBlocklist(logger *zap.Logger, bl *dht.InfohashBlocklist, path string) {
+	load := func() {
+		if err := bl.LoadFile(path); err != nil {
+			logger.Warn("load blocklist failed", zap.Error(err))
+			return
+		}
+		logger.Info("blocklist loaded", zap.Int("infohashes", bl.Len()))
+	}
+	load()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			load()
+		}
+	}()
+}
+
 func main() {
+	flag.Parse()
+
+	store := torrent.NewMemoryStore(10000)
+
+	// /debug/torrents?q=ubuntu searches the recently discovered torrents by
+	// name.
+	http.HandleFunc("/debug/torrents", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.Search(r.FormValue("q")))
+	})
+
 	go func() {
 		http.ListenAndServe(":6060", nil)
 	}()
 
-	logger := NewConsoleLogger()
+	var sampling *zap.SamplingConfig
+	if *logSampleFirst > 0 {
+		sampling = &zap.SamplingConfig{
+			Initial:    *logSampleFirst,
+			Thereafter: *logSampleThereafter,
+		}
+	}
 
-	w := dht.NewWire(65536, 1024, 256)
-	go func() {
-		for resp := range w.Response() {
-			metadata, err := dht.Decode(resp.MetadataInfo)
-			if err != nil {
-				continue
-			}
-			info := metadata.(map[string]interface{})
+	logger, err := NewLogger(*logFormat, sampling)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-			if _, ok := info["name"]; !ok {
-				continue
-			}
+	config := dht.NewCrawlConfig()
+	if *blocklistPath != "" {
+		config.InfohashBlocklist, _ = dht.NewInfohashBlocklist()
+		watchBlocklist(logger, config.InfohashBlocklist, *blocklistPath)
+	}
 
-			bt := torrent.BitTorrent{
-				InfoHash: hex.EncodeToString(resp.InfoHash),
-				Name:     info["name"].(string),
-			}
+	d := dht.New(logger, config)
+	dht.PublishExpvars(d)
+	if *statsdAddr != "" {
+		if err := dht.StatsDReporter(*statsdAddr, d, time.Second*10); err != nil {
+			logger.Warn("statsd reporter failed", zap.Error(err))
+		}
+	}
 
-			if v, ok := info["files"]; ok {
-				files := v.([]interface{})
-				bt.Files = make([]torrent.File, len(files))
-
-				for i, item := range files {
-					f := item.(map[string]interface{})
-					bt.Files[i] = torrent.File{
-						Path:   f["path"].([]interface{}),
-						Length: f["length"].(int),
-					}
-				}
-			} else if _, ok := info["length"]; ok {
-				bt.Length = info["length"].(int)
-			}
+	// render /debug/routing.dot with e.g. `dot -Tsvg`.
+	http.HandleFunc("/debug/routing.dot", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		io.WriteString(w, d.RoutingTableDOT())
+	})
 
-			data, err := json.Marshal(bt)
-			if err == nil {
-				fmt.Printf("%s\n\n", data)
+	// /debug/externalip shows our ip voted by the peers, and its votes.
+	http.HandleFunc("/debug/externalip", func(w http.ResponseWriter, r *http.Request) {
+		ip, votes := d.ExternalIP()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ip":    ip,
+			"votes": votes,
+		})
+	})
+
+	go func() {
+		w := torrent.NewJSONLinesWriter(os.Stdout)
+		defer w.Close()
+
+		for bt := range d.Torrents() {
+			// the raw info dicts are big, keep them out of the index.
+			bt.RawInfo = nil
+			store.Put(bt)
+
+			if w.Write(bt) == nil {
+				w.Flush()
 			}
 		}
 	}()
-	go w.Run()
-
-	config := dht.NewCrawlConfig()
-	config.OnAnnouncePeer = func(infoHash, ip string, port int) {
-		w.Request([]byte(infoHash), ip, port)
-	}
-	d := dht.New(logger, config)
 
 	d.Run()
 }
@@ -12,7 +12,7 @@ import (
 func main() {
 	logger, _ := zap.NewDevelopment()
 	d := dht.New(logger, nil)
-	d.OnGetPeersResponse = func(infoHash string, peer dht.Peer) {
+	d.OnGetPeersResponse = func(infoHash dht.InfoHash, peer dht.Peer) {
 		fmt.Printf("GOT PEER: <%s:%d>\n", peer.IP(), peer.Port())
 	}
 
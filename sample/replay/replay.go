@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MildC/dht-crawler/dht"
+	"go.uber.org/zap"
+)
+
+// replay feeds the packets of a capture file through the handlers of a
+// passive dht. Each line of the file is a packet, which is the source
+// address and the hex encoded datagram separated by a space, e.g.
+//
+//	1.2.3.4:6881 64313a6164323a696432303a...
+func main() {
+	capture := flag.String("capture", "", "the capture file to replay")
+	flag.Parse()
+
+	f, err := os.Open(*capture)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	logger, _ := zap.NewDevelopment()
+
+	config := dht.NewCrawlConfig()
+	config.Address = "127.0.0.1:0"
+	config.PrimeNodes = nil
+	config.PassiveOnly = true
+	config.OnAnnouncePeer = func(infoHash, ip string, port int) {
+		logger.Info("announce_peer", zap.String("infohash", hex.EncodeToString(
+			[]byte(infoHash))), zap.String("ip", ip), zap.Int("port", port))
+	}
+
+	d := dht.New(logger, config)
+	go d.Run()
+	defer d.Close()
+
+	for !d.Ready {
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		from, err := net.ResolveUDPAddr("udp", fields[0])
+		if err != nil {
+			logger.Warn("bad address", zap.Int("line", line), zap.Error(err))
+			continue
+		}
+
+		data, err := hex.DecodeString(fields[1])
+		if err != nil {
+			logger.Warn("bad packet", zap.Int("line", line), zap.Error(err))
+			continue
+		}
+
+		err = d.InjectPacket(data, from)
+		if err == dht.ErrPacketTooLarge {
+			logger.Warn("packet too large", zap.Int("line", line))
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	// let the handlers of the last packets finish.
+	time.Sleep(time.Second)
+}
@@ -0,0 +1,216 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltFlushInterval is how often the pending torrents are committed.
+	boltFlushInterval = time.Second
+	// boltBatchMax is how many pending torrents are committed right away
+	// without waiting for the interval.
+	boltBatchMax = 1024
+)
+
+var (
+	// boltBucket is the bucket holding the torrents keyed by infohash.
+	boltBucket = []byte("torrents")
+	// errNotStored is returned in Get when the torrent isn't stored.
+	errNotStored = errors.New("not stored")
+)
+
+// BoltSink is a goroutine-safe Sink storing the torrents in a bbolt file,
+// keyed by infohash. The torrents of the infohashes already stored are
+// skipped. The writes are held in memory and committed together in a
+// single transaction every second, or once 1024 are pending, so a crash
+// loses at most the pending ones. The torrents are gob encoded, so every
+// field of them is kept as is.
+type BoltSink struct {
+	sync.Mutex
+	db      *bolt.DB
+	pending map[string]BitTorrent
+	// err is the error of the last background commit, it's returned by the
+	// next Write or Close
+	err       error
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewBoltSink returns a BoltSink pointer storing the torrents in the bbolt
+// file at path, which is created if it doesn't exist.
+func NewBoltSink(path string) (*BoltSink, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bs := &BoltSink{
+		db:      db,
+		pending: make(map[string]BitTorrent),
+		done:    make(chan struct{}),
+	}
+
+	bs.wg.Add(1)
+	go bs.flushLoop()
+
+	return bs, nil
+}
+
+// Write queues bt to be committed. A torrent whose infohash is already
+// stored or pending is skipped. It returns an error if the infohash of bt
+// is empty, or the error of the last failed commit.
+func (bs *BoltSink) Write(bt BitTorrent) error {
+	if bt.InfoHash == "" {
+		return errors.New("empty infohash")
+	}
+
+	bs.Lock()
+	defer bs.Unlock()
+
+	if err := bs.err; err != nil {
+		bs.err = nil
+		return err
+	}
+
+	if _, ok := bs.pending[bt.InfoHash]; ok {
+		return nil
+	}
+
+	stored := false
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		stored = tx.Bucket(boltBucket).Get([]byte(bt.InfoHash)) != nil
+		return nil
+	})
+	if err != nil || stored {
+		return err
+	}
+
+	bs.pending[bt.InfoHash] = bt
+	if len(bs.pending) >= boltBatchMax {
+		return bs.flush()
+	}
+	return nil
+}
+
+// Get returns the torrent of infoHash, either stored or pending.
+func (bs *BoltSink) Get(infoHash string) (BitTorrent, bool) {
+	bs.Lock()
+	bt, ok := bs.pending[infoHash]
+	bs.Unlock()
+
+	if ok {
+		return bt, true
+	}
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get([]byte(infoHash))
+		if data == nil {
+			return errNotStored
+		}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&bt)
+	})
+	return bt, err == nil
+}
+
+// Count returns how many torrents are stored, including the pending ones.
+func (bs *BoltSink) Count() int {
+	bs.Lock()
+	defer bs.Unlock()
+
+	n := len(bs.pending)
+	bs.db.View(func(tx *bolt.Tx) error {
+		n += tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// flushLoop commits the pending torrents every boltFlushInterval until the
+// sink is closed.
+func (bs *BoltSink) flushLoop() {
+	defer bs.wg.Done()
+
+	ticker := time.NewTicker(boltFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bs.Lock()
+			if err := bs.flush(); err != nil {
+				bs.err = err
+			}
+			bs.Unlock()
+		case <-bs.done:
+			return
+		}
+	}
+}
+
+// flush commits the pending torrents in a single transaction. The caller
+// must hold the lock. The pending torrents are kept if it fails, so
+// they're retried by the next flush.
+func (bs *BoltSink) flush() error {
+	if len(bs.pending) == 0 {
+		return nil
+	}
+
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for infoHash, bt := range bs.pending {
+			buff := bytes.NewBuffer(nil)
+			if err := gob.NewEncoder(buff).Encode(bt); err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(infoHash), buff.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.pending = make(map[string]BitTorrent)
+	return nil
+}
+
+// Close commits the pending torrents and closes the file. Calling it more
+// than once is a no-op.
+func (bs *BoltSink) Close() (err error) {
+	bs.closeOnce.Do(func() {
+		close(bs.done)
+		bs.wg.Wait()
+
+		bs.Lock()
+		err = bs.flush()
+		if err == nil {
+			err = bs.err
+		}
+		bs.Unlock()
+
+		if cerr := bs.db.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return
+}
+
+var _ Sink = (*BoltSink)(nil)
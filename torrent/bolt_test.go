@@ -0,0 +1,94 @@
+package torrent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// filePath returns the path of the first file of bt joined by "/".
+func filePath(bt BitTorrent) string {
+	if len(bt.Files) == 0 {
+		return ""
+	}
+
+	elems := make([]string, len(bt.Files[0].Path))
+	for i, elem := range bt.Files[0].Path {
+		elems[i] = fmt.Sprint(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+func TestBoltSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "torrents.db")
+
+	sink, err := NewBoltSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bt := range []BitTorrent{
+		{InfoHash: "a", Name: "foo", Length: 1},
+		{InfoHash: "b", Name: "bar\xff", Files: []File{
+			{Path: []interface{}{"dir", "bar"}, Length: 2},
+		}},
+		// a duplicate of a pending torrent is skipped.
+		{InfoHash: "a", Name: "baz"},
+	} {
+		if err := sink.Write(bt); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if sink.Write(BitTorrent{}) == nil {
+		t.Error("empty infohash written")
+	}
+
+	if bt, ok := sink.Get("a"); !ok || bt.Name != "foo" || sink.Count() != 2 {
+		t.Errorf("pending: %v, %v, %d", bt, ok, sink.Count())
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Error(err)
+	}
+
+	sink, err = NewBoltSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// a duplicate of a stored torrent is skipped.
+	if err := sink.Write(BitTorrent{InfoHash: "b", Name: "qux"}); err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		infoHash string
+		name     string
+		size     int
+		path     string
+		ok       bool
+	}{
+		{"a", "foo", 1, "", true},
+		{"b", "bar\xff", 2, "dir/bar", true},
+		{"c", "", 0, "", false},
+	}
+
+	for _, c := range cases {
+		bt, ok := sink.Get(c.infoHash)
+		if ok != c.ok || bt.Name != c.name || bt.TotalSize() != c.size ||
+			filePath(bt) != c.path {
+
+			t.Errorf("Get(%q) = %v, %v", c.infoHash, bt, ok)
+		}
+	}
+
+	if n := sink.Count(); n != 2 {
+		t.Errorf("Count() = %d, want 2", n)
+	}
+}
@@ -0,0 +1,163 @@
+package torrent
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MildC/dht-crawler/torrent/torrentpb"
+	"google.golang.org/grpc"
+)
+
+// grpcClientBuffer is how many torrents are buffered for a client before
+// the following ones are dropped for it.
+const grpcClientBuffer = 256
+
+// TorrentSource emits the discovered torrents, e.g. dht.DHT.
+type TorrentSource interface {
+	Torrents() <-chan BitTorrent
+}
+
+// GRPCServer implements torrentpb.TorrentServiceServer. It reads the
+// torrents of a TorrentSource and forwards them to every client streaming
+// them. Each client has its own buffer, the torrents are dropped for a
+// client whose buffer is full, so a slow or stuck client never blocks the
+// source nor the other clients.
+type GRPCServer struct {
+	torrentpb.UnimplementedTorrentServiceServer
+
+	// dropped is how many torrents are dropped for the slow clients, it's
+	// kept first for alignment.
+	dropped uint64
+	mu      sync.Mutex
+	clients map[chan BitTorrent]struct{}
+	// closed is set when the source is drained, the streams end then
+	closed bool
+}
+
+// NewGRPCServer returns a GRPCServer pointer forwarding the torrents of
+// source. It consumes the chan of source, so nothing else should read it.
+func NewGRPCServer(source TorrentSource) *GRPCServer {
+	s := &GRPCServer{clients: make(map[chan BitTorrent]struct{})}
+	go s.broadcast(source.Torrents())
+	return s
+}
+
+// RegisterTorrentServer registers a GRPCServer forwarding the torrents of
+// source, e.g. a *dht.DHT, to server and returns it.
+func RegisterTorrentServer(server *grpc.Server, source TorrentSource) *GRPCServer {
+	s := NewGRPCServer(source)
+	torrentpb.RegisterTorrentServiceServer(server, s)
+	return s
+}
+
+// broadcast sends the torrents to the clients until torrents is closed.
+// Then the clients are closed.
+func (s *GRPCServer) broadcast(torrents <-chan BitTorrent) {
+	for bt := range torrents {
+		s.mu.Lock()
+		for client := range s.clients {
+			select {
+			case client <- bt:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for client := range s.clients {
+		close(client)
+		delete(s.clients, client)
+	}
+}
+
+// subscribe adds a client. It returns nil if the source is drained.
+func (s *GRPCServer) subscribe() chan BitTorrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	client := make(chan BitTorrent, grpcClientBuffer)
+	s.clients[client] = struct{}{}
+	return client
+}
+
+// unsubscribe removes a client.
+func (s *GRPCServer) unsubscribe(client chan BitTorrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, client)
+}
+
+// StreamTorrents streams the torrents discovered after the call, which match
+// req, until the client disconnects or the source is drained.
+func (s *GRPCServer) StreamTorrents(req *torrentpb.StreamTorrentsRequest,
+	stream torrentpb.TorrentService_StreamTorrentsServer) error {
+
+	client := s.subscribe()
+	if client == nil {
+		return nil
+	}
+	defer s.unsubscribe(client)
+
+	nameContains := strings.ToLower(req.GetNameContains())
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case bt, ok := <-client:
+			if !ok {
+				return nil
+			}
+
+			if int64(bt.TotalSize()) < req.GetMinSize() ||
+				!strings.Contains(strings.ToLower(bt.Name), nameContains) {
+				continue
+			}
+
+			if err := stream.Send(toProto(bt)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Dropped returns how many torrents are dropped for the slow clients, which
+// is counted once per client.
+func (s *GRPCServer) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// toProto converts bt to the message, without the raw info dict.
+func toProto(bt BitTorrent) *torrentpb.BitTorrent {
+	files := make([]*torrentpb.File, len(bt.Files))
+	for i, f := range bt.Files {
+		path := make([]string, len(f.Path))
+		for j, elem := range f.Path {
+			path[j] = fmt.Sprint(elem)
+		}
+		files[i] = &torrentpb.File{Path: path, Length: int64(f.Length)}
+	}
+
+	return &torrentpb.BitTorrent{
+		Infohash:    bt.InfoHash,
+		Name:        []byte(bt.Name),
+		Files:       files,
+		Length:      int64(bt.Length),
+		Private:     bt.Private,
+		PieceLength: bt.PieceLength,
+		PieceCount:  int64(bt.PieceCount),
+		Source:      bt.Source,
+	}
+}
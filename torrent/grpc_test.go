@@ -0,0 +1,161 @@
+package torrent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MildC/dht-crawler/torrent/torrentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// chanSource is a TorrentSource emitting the torrents sent to it.
+type chanSource chan BitTorrent
+
+func (cs chanSource) Torrents() <-chan BitTorrent {
+	return cs
+}
+
+// clientCount returns how many clients are streaming.
+func (s *GRPCServer) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+// newGRPCClient serves a GRPCServer of source in memory and returns it and
+// a client of it.
+func newGRPCClient(t *testing.T, source TorrentSource) (
+	*GRPCServer, torrentpb.TorrentServiceClient) {
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	s := RegisterTorrentServer(server, source)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return s, torrentpb.NewTorrentServiceClient(conn)
+}
+
+// waitClients waits for n clients to stream.
+func waitClients(t *testing.T, s *GRPCServer, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for s.clientCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients, want %d", s.clientCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	source := make(chanSource)
+	s, client := newGRPCClient(t, source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all, err := client.StreamTorrents(ctx, &torrentpb.StreamTorrentsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := client.StreamTorrents(ctx, &torrentpb.StreamTorrentsRequest{
+		NameContains: "UBUNTU",
+		MinSize:      2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitClients(t, s, 2)
+
+	for _, bt := range []BitTorrent{
+		{InfoHash: "a", Name: "ubuntu", Length: 1},
+		{InfoHash: "b", Name: "debian", Length: 2},
+		{InfoHash: "c", Name: "Ubuntu 22.04", Files: []File{
+			{Path: []interface{}{"dir", "iso"}, Length: 2},
+		}},
+	} {
+		source <- bt
+	}
+	close(source)
+
+	cases := []struct {
+		stream     torrentpb.TorrentService_StreamTorrentsClient
+		infoHashes []string
+	}{
+		{all, []string{"a", "b", "c"}},
+		{filtered, []string{"c"}},
+	}
+
+	for _, c := range cases {
+		var infoHashes []string
+		for {
+			bt, err := c.stream.Recv()
+			if err != nil {
+				break
+			}
+			infoHashes = append(infoHashes, bt.Infohash)
+
+			if bt.Infohash == "c" && (len(bt.Files) != 1 ||
+				bt.Files[0].Path[1] != "iso" || bt.Files[0].Length != 2) {
+
+				t.Errorf("unexpected files %v", bt.Files)
+			}
+		}
+
+		if len(infoHashes) != len(c.infoHashes) {
+			t.Errorf("streamed %v, want %v", infoHashes, c.infoHashes)
+			continue
+		}
+		for i := range infoHashes {
+			if infoHashes[i] != c.infoHashes[i] {
+				t.Errorf("streamed %v, want %v", infoHashes, c.infoHashes)
+			}
+		}
+	}
+}
+
+func TestGRPCServerSlowClient(t *testing.T) {
+	source := make(chanSource)
+	s, client := newGRPCClient(t, source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the stream is never read.
+	if _, err := client.StreamTorrents(ctx, &torrentpb.StreamTorrentsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	waitClients(t, s, 1)
+
+	name := string(make([]byte, 1024))
+	for i := 0; i < grpcClientBuffer*16; i++ {
+		select {
+		case source <- BitTorrent{InfoHash: "a", Name: name}:
+		case <-time.After(time.Second * 5):
+			t.Fatal("the slow client blocks the source")
+		}
+	}
+
+	if s.Dropped() == 0 {
+		t.Error("nothing dropped")
+	}
+
+	// the client is removed when it disconnects.
+	cancel()
+	waitClients(t, s, 0)
+}
@@ -0,0 +1,88 @@
+package torrent
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Metadata is a fetched info dict waiting to be decoded.
+type Metadata struct {
+	InfoHash []byte
+	Info     []byte
+}
+
+// DecodeFunc verifies and decodes the metadata into a BitTorrent, e.g. a
+// wrapper of dht.ParseMetadata.
+type DecodeFunc func(m Metadata) (BitTorrent, error)
+
+// Processor decodes the submitted metadata on a pool of workers and writes
+// the torrents to a sink, so a slow decode doesn't hold up the fetching. The
+// torrents reach the sink in the order they're decoded, not submitted. The
+// sink writes are serialized, so the sink needn't be goroutine-safe.
+type Processor struct {
+	// the 64-bit atomic field is kept first for alignment.
+	failed uint64
+	decode DecodeFunc
+	sink   Sink
+	jobs   chan Metadata
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+// NewProcessor returns a Processor pointer running workers decoding
+// goroutines, at least 1, which write the torrents decoded by decode to sink.
+func NewProcessor(workers int, sink Sink, decode DecodeFunc) *Processor {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Processor{
+		decode: decode,
+		sink:   sink,
+		jobs:   make(chan Metadata, workers),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work decodes the metadata until the processor is closed.
+func (p *Processor) work() {
+	defer p.wg.Done()
+
+	for m := range p.jobs {
+		bt, err := p.decode(m)
+		if err == nil {
+			p.mu.Lock()
+			err = p.sink.Write(bt)
+			p.mu.Unlock()
+		}
+
+		if err != nil {
+			atomic.AddUint64(&p.failed, 1)
+		}
+	}
+}
+
+// Submit queues m for decoding. It blocks while all the workers are busy and
+// the queue is full. It must not be called after Close.
+func (p *Processor) Submit(m Metadata) {
+	p.jobs <- m
+}
+
+// Failed returns how many metadata fail to decode or to be written.
+func (p *Processor) Failed() uint64 {
+	return atomic.LoadUint64(&p.failed)
+}
+
+// Close waits for the queued metadata to be processed, then closes the
+// sink.
+func (p *Processor) Close() error {
+	close(p.jobs)
+	p.wg.Wait()
+
+	return p.sink.Close()
+}
@@ -0,0 +1,51 @@
+package torrent
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+// memorySink collects the written torrents.
+type memorySink struct {
+	torrents []BitTorrent
+	closed   bool
+}
+
+func (ms *memorySink) Write(bt BitTorrent) error {
+	ms.torrents = append(ms.torrents, bt)
+	return nil
+}
+
+func (ms *memorySink) Close() error {
+	ms.closed = true
+	return nil
+}
+
+func TestProcessor(t *testing.T) {
+	sink := &memorySink{}
+	p := NewProcessor(4, sink, func(m Metadata) (BitTorrent, error) {
+		if len(m.Info) == 0 {
+			return BitTorrent{}, errors.New("empty info")
+		}
+		return BitTorrent{InfoHash: string(m.InfoHash), Name: string(m.Info)}, nil
+	})
+
+	for _, s := range []string{"a", "b", "", "c", "d"} {
+		p.Submit(Metadata{InfoHash: []byte(s), Info: []byte(s)})
+	}
+
+	if err := p.Close(); err != nil || !sink.closed {
+		t.Fatal("sink not closed")
+	}
+
+	names := make([]string, len(sink.torrents))
+	for i, bt := range sink.torrents {
+		names[i] = bt.Name
+	}
+	sort.Strings(names)
+
+	if len(names) != 4 || names[0] != "a" || names[3] != "d" || p.Failed() != 1 {
+		t.Errorf("unexpected torrents %v, failed %d", names, p.Failed())
+	}
+}
@@ -0,0 +1,108 @@
+package torrent
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// Sink consumes discovered torrents. Implementations are not required to be
+// goroutine-safe, callers should write from a single goroutine.
+type Sink interface {
+	Write(bt BitTorrent) error
+	Close() error
+}
+
+// JSONLinesWriter writes torrents as newline-delimited JSON.
+type JSONLinesWriter struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONLinesWriter returns a JSONLinesWriter writing to w. Output is
+// buffered, call Flush or Close to make sure everything reaches w.
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	buffer := bufio.NewWriter(w)
+	return &JSONLinesWriter{
+		buffer:  buffer,
+		encoder: json.NewEncoder(buffer),
+	}
+}
+
+// Write encodes bt as a single JSON line.
+func (jw *JSONLinesWriter) Write(bt BitTorrent) error {
+	return jw.encoder.Encode(bt)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (jw *JSONLinesWriter) Flush() error {
+	return jw.buffer.Flush()
+}
+
+// Close flushes the buffered data. It doesn't close the underlying writer.
+func (jw *JSONLinesWriter) Close() error {
+	return jw.Flush()
+}
+
+// csvHeader is the header row written by CSVWriter.
+var csvHeader = []string{"infohash", "name", "size", "files"}
+
+// CSVWriter writes torrents as CSV rows with the columns infohash, name,
+// total size and file count. Fields containing commas, quotes or newlines
+// are quoted.
+type CSVWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVWriter returns a CSVWriter writing to w. The header row is written
+// before the first record, or on Close if nothing was written.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w)}
+}
+
+// writeHeader writes the header row once.
+func (cw *CSVWriter) writeHeader() error {
+	if cw.headerWritten {
+		return nil
+	}
+	cw.headerWritten = true
+
+	return cw.writer.Write(csvHeader)
+}
+
+// Write appends bt as a CSV row.
+func (cw *CSVWriter) Write(bt BitTorrent) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	return cw.writer.Write([]string{
+		bt.InfoHash,
+		bt.Name,
+		strconv.Itoa(bt.TotalSize()),
+		strconv.Itoa(bt.FileCount()),
+	})
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (cw *CSVWriter) Flush() error {
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// Close writes the header if needed and flushes the buffered data. It
+// doesn't close the underlying writer.
+func (cw *CSVWriter) Close() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	return cw.Flush()
+}
+
+var (
+	_ Sink = &JSONLinesWriter{}
+	_ Sink = &CSVWriter{}
+)
@@ -0,0 +1,64 @@
+package torrent
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONLinesWriter(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	w := NewJSONLinesWriter(buff)
+
+	for _, bt := range []BitTorrent{
+		{InfoHash: "a", Name: "foo", Length: 1},
+		{InfoHash: "b", Name: "bar", Length: 2},
+	} {
+		if err := w.Write(bt); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	out := `{"infohash":"a","name":"foo","length":1}
+{"infohash":"b","name":"bar","length":2}
+`
+	if buff.String() != out {
+		t.Errorf("unexpected output %q", buff.String())
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	cases := []struct {
+		in  []BitTorrent
+		out string
+	}{
+		{nil, "infohash,name,size,files\n"},
+		{[]BitTorrent{{InfoHash: "a", Name: "foo", Length: 1}},
+			"infohash,name,size,files\na,foo,1,1\n"},
+		{[]BitTorrent{{InfoHash: "a", Name: "foo, bar\nbaz", Files: []File{
+			{Length: 1}, {Length: 2},
+		}}}, "infohash,name,size,files\na,\"foo, bar\nbaz\",3,2\n"},
+	}
+
+	for _, c := range cases {
+		buff := bytes.NewBuffer(nil)
+		w := NewCSVWriter(buff)
+
+		for _, bt := range c.in {
+			if err := w.Write(bt); err != nil {
+				t.Error(err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if buff.String() != c.out {
+			t.Errorf("unexpected output %q", buff.String())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Store stores torrents and queries them.
+type Store interface {
+	// Put stores bt, replacing the torrent with the same infohash.
+	Put(bt BitTorrent) error
+	// Get returns the torrent of infoHash.
+	Get(infoHash string) (BitTorrent, bool)
+	// Search returns the torrents whose names contain substr.
+	Search(substr string) []BitTorrent
+}
+
+// MemoryStore is a goroutine-safe in-memory Store holding at most maxSize
+// torrents. When it's full, the least recently used torrent, i.e. the one
+// least recently put or got, is evicted. The torrents are kept as they're
+// put including their RawInfo, so the memory it takes grows with maxSize
+// times the average size of the torrents, which is dominated by the file
+// lists and the raw info dicts. Search scans all the torrents, so it's
+// O(n).
+type MemoryStore struct {
+	sync.Mutex
+	maxSize  int
+	torrents *list.List
+	index    map[string]*list.Element
+}
+
+// NewMemoryStore returns a MemoryStore pointer holding at most maxSize
+// torrents.
+func NewMemoryStore(maxSize int) *MemoryStore {
+	return &MemoryStore{
+		maxSize:  maxSize,
+		torrents: list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// Put stores bt as the most recently used torrent. It returns an error if
+// the infohash of bt is empty.
+func (ms *MemoryStore) Put(bt BitTorrent) error {
+	if bt.InfoHash == "" {
+		return errors.New("empty infohash")
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	if e, ok := ms.index[bt.InfoHash]; ok {
+		e.Value = bt
+		ms.torrents.MoveToFront(e)
+		return nil
+	}
+
+	ms.index[bt.InfoHash] = ms.torrents.PushFront(bt)
+
+	for ms.torrents.Len() > ms.maxSize {
+		e := ms.torrents.Back()
+		delete(ms.index, e.Value.(BitTorrent).InfoHash)
+		ms.torrents.Remove(e)
+	}
+	return nil
+}
+
+// Get returns the torrent of infoHash and marks it the most recently used.
+func (ms *MemoryStore) Get(infoHash string) (BitTorrent, bool) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	e, ok := ms.index[infoHash]
+	if !ok {
+		return BitTorrent{}, false
+	}
+
+	ms.torrents.MoveToFront(e)
+	return e.Value.(BitTorrent), true
+}
+
+// Search returns the torrents whose names contain substr case-insensitively,
+// the most recently used first. It doesn't change the recency.
+func (ms *MemoryStore) Search(substr string) []BitTorrent {
+	substr = strings.ToLower(substr)
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	result := make([]BitTorrent, 0)
+	for e := ms.torrents.Front(); e != nil; e = e.Next() {
+		bt := e.Value.(BitTorrent)
+		if strings.Contains(strings.ToLower(bt.Name), substr) {
+			result = append(result, bt)
+		}
+	}
+	return result
+}
+
+// Len returns how many torrents are stored.
+func (ms *MemoryStore) Len() int {
+	ms.Lock()
+	defer ms.Unlock()
+
+	return ms.torrents.Len()
+}
+
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(2)
+
+	store.Put(BitTorrent{InfoHash: "a", Name: "Ubuntu 20.04"})
+	store.Put(BitTorrent{InfoHash: "b", Name: "debian"})
+
+	// getting a makes b the least recently used.
+	if _, ok := store.Get("a"); !ok {
+		t.Fatal("a missing")
+	}
+	store.Put(BitTorrent{InfoHash: "c", Name: "ubuntu 22.04"})
+
+	cases := []struct {
+		infoHash string
+		ok       bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+
+	for _, c := range cases {
+		if _, ok := store.Get(c.infoHash); ok != c.ok {
+			t.Errorf("Get(%q) = %v, want %v", c.infoHash, ok, c.ok)
+		}
+	}
+
+	result := store.Search("UBUNTU")
+	if len(result) != 2 || result[0].InfoHash != "c" || result[1].InfoHash != "a" {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	if store.Put(BitTorrent{}) == nil || store.Len() != 2 {
+		t.Fail()
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	store := NewMemoryStore(16)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				infoHash := string(rune('a' + (i+j)%26))
+				store.Put(BitTorrent{InfoHash: infoHash, Name: infoHash})
+				store.Get(infoHash)
+				store.Search("a")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if store.Len() != 16 {
+		t.Error(store.Len())
+	}
+}
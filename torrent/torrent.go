@@ -1,13 +1,88 @@
 package torrent
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+)
+
 type File struct {
 	Path   []interface{} `json:"path"`
 	Length int           `json:"length"`
 }
 
 type BitTorrent struct {
-	InfoHash string `json:"infohash"`
-	Name     string `json:"name"`
-	Files    []File `json:"files,omitempty"`
-	Length   int    `json:"length,omitempty"`
+	InfoHash    string `json:"infohash"`
+	Name        string `json:"name"`
+	Files       []File `json:"files,omitempty"`
+	Length      int    `json:"length,omitempty"`
+	Private     bool   `json:"private,omitempty"`
+	PieceLength int64  `json:"piece_length,omitempty"`
+	// the number of pieces, i.e. the length of the pieces hashes divided by
+	// 20. The hashes themselves aren't kept since they're large
+	PieceCount int `json:"piece_count,omitempty"`
+	// the source tag, which private trackers set to make the infohash unique
+	// to the tracker
+	Source string `json:"source,omitempty"`
+	// creation date, comment and created by belong to the outer dict of a
+	// .torrent file, but BEP 9 only transfers the info dict, so they're set
+	// only if a client puts them in the info dict, which is rare. Expect
+	// them to be empty for the torrents fetched from peers
+	CreationDate int64  `json:"creation_date,omitempty"`
+	Comment      string `json:"comment,omitempty"`
+	CreatedBy    string `json:"created_by,omitempty"`
+	// the raw bencoded info dict if the torrent is parsed from fetched
+	// metadata. It isn't encoded to json
+	RawInfo []byte `json:"-"`
+}
+
+// MarshalJSON encodes bt like the default encoding, but if the name isn't
+// valid UTF-8, which json replaces with U+FFFD, the raw name is added as
+// name_base64 so it can be recovered.
+func (bt BitTorrent) MarshalJSON() ([]byte, error) {
+	type plain BitTorrent
+
+	v := struct {
+		plain
+		NameBase64 string `json:"name_base64,omitempty"`
+	}{plain: plain(bt)}
+
+	if !utf8.ValidString(bt.Name) {
+		v.NameBase64 = base64.StdEncoding.EncodeToString([]byte(bt.Name))
+	}
+	return json.Marshal(v)
+}
+
+// TotalSize returns the sum of all file lengths. For a single-file torrent
+// it's the length of that file.
+func (bt *BitTorrent) TotalSize() int {
+	if len(bt.Files) == 0 {
+		return bt.Length
+	}
+
+	size := 0
+	for _, f := range bt.Files {
+		size += f.Length
+	}
+	return size
+}
+
+// PiecesMatchSize returns whether PieceCount is the number of PieceLength
+// pieces TotalSize takes, so a false result means corrupt metadata. It
+// returns true if either PieceCount or PieceLength is unknown.
+func (bt *BitTorrent) PiecesMatchSize() bool {
+	if bt.PieceCount <= 0 || bt.PieceLength <= 0 {
+		return true
+	}
+
+	size := int64(bt.TotalSize())
+	return int64(bt.PieceCount) == (size+bt.PieceLength-1)/bt.PieceLength
+}
+
+// FileCount returns the number of files the torrent contains.
+func (bt *BitTorrent) FileCount() int {
+	if len(bt.Files) == 0 {
+		return 1
+	}
+	return len(bt.Files)
 }
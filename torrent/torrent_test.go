@@ -0,0 +1,45 @@
+package torrent
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBitTorrentMarshalJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		out  string
+	}{
+		{"foo", `{"infohash":"a","name":"foo"}`},
+		{"f\xffo", `{"infohash":"a","name":"f�o","name_base64":"Zv9v"}`},
+	}
+
+	for _, c := range cases {
+		out, err := json.Marshal(BitTorrent{InfoHash: "a", Name: c.name})
+		if err != nil || string(out) != c.out {
+			t.Errorf("unexpected output %s, %v", out, err)
+		}
+	}
+}
+
+func TestPiecesMatchSize(t *testing.T) {
+	cases := []struct {
+		bt  BitTorrent
+		out bool
+	}{
+		{BitTorrent{Length: 100}, true},
+		{BitTorrent{Length: 100, PieceLength: 16, PieceCount: 7}, true},
+		{BitTorrent{Length: 96, PieceLength: 16, PieceCount: 6}, true},
+		{BitTorrent{Length: 100, PieceLength: 16, PieceCount: 6}, false},
+		{BitTorrent{Files: []File{{Length: 10}, {Length: 30}}, PieceLength: 16,
+			PieceCount: 3}, true},
+		{BitTorrent{Files: []File{{Length: 10}, {Length: 30}}, PieceLength: 16,
+			PieceCount: 4}, false},
+	}
+
+	for i, c := range cases {
+		if c.bt.PiecesMatchSize() != c.out {
+			t.Errorf("case %d: want %v", i, c.out)
+		}
+	}
+}
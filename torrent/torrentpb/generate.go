@@ -0,0 +1,8 @@
+package torrentpb
+
+// The code is generated by compiling torrent.proto with
+// github.com/bufbuild/protocompile v0.5.1 and running protoc-gen-go v1.30.0
+// and protoc-gen-go-grpc v1.3.0 on it. The versions are pinned by
+// internal/protoc/go.mod, a separate module so they don't leak into the
+// crawler's dependencies.
+//go:generate go run -C internal/protoc . ../../torrent.proto
@@ -0,0 +1,11 @@
+module github.com/MildC/dht-crawler/torrent/torrentpb/internal/protoc
+
+go 1.18
+
+require (
+	github.com/bufbuild/protocompile v0.5.1
+	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.3.0
+	google.golang.org/protobuf v1.30.0
+)
+
+require golang.org/x/sync v0.2.0 // indirect
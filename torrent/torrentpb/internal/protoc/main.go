@@ -0,0 +1,108 @@
+// Command protoc generates the go code of a proto file. It compiles the file
+// by github.com/bufbuild/protocompile and runs protoc-gen-go and
+// protoc-gen-go-grpc on it, all at the versions pinned by go.mod, so the
+// code is reproducible without a protoc binary.
+//
+// Usage:
+//
+//	go run . path/to/file.proto
+//
+// The code is written next to the proto file.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// plugins are the packages of the plugins to run.
+var plugins = []string{
+	"google.golang.org/protobuf/cmd/protoc-gen-go",
+	"google.golang.org/grpc/cmd/protoc-gen-go-grpc",
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: protoc file.proto")
+		os.Exit(2)
+	}
+
+	if err := generate(os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// generate compiles path and writes the code of every plugin next to it.
+func generate(path string) error {
+	dir, name := filepath.Split(path)
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: []string{dir},
+		}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+
+	files, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		return err
+	}
+
+	req, err := proto.Marshal(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{name},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(files[0]),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, plugin := range plugins {
+		if err := run(plugin, req, dir); err != nil {
+			return fmt.Errorf("%s: %v", plugin, err)
+		}
+	}
+	return nil
+}
+
+// run runs the plugin on req and writes the files it generates to dir.
+func run(plugin string, req []byte, dir string) error {
+	out := bytes.NewBuffer(nil)
+
+	cmd := exec.Command("go", "run", plugin)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(out.Bytes(), resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.GetError())
+	}
+
+	for _, f := range resp.File {
+		err := os.WriteFile(filepath.Join(dir, f.GetName()), []byte(f.GetContent()), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
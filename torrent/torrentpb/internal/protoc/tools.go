@@ -0,0 +1,10 @@
+//go:build tools
+// +build tools
+
+package main
+
+// The plugins run by protoc, imported to pin their versions in go.mod.
+import (
+	_ "google.golang.org/grpc/cmd/protoc-gen-go-grpc"
+	_ "google.golang.org/protobuf/cmd/protoc-gen-go"
+)
@@ -0,0 +1,144 @@
+// The gRPC API streaming the discovered torrents, served by
+// torrent.GRPCServer. The go code is generated by go generate, see
+// generate.go for the pinned versions of the generators.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: torrent.proto
+
+package torrentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	TorrentService_StreamTorrents_FullMethodName = "/torrent.TorrentService/StreamTorrents"
+)
+
+// TorrentServiceClient is the client API for TorrentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TorrentServiceClient interface {
+	// StreamTorrents streams the torrents discovered after the call. A client
+	// reading too slowly misses the torrents instead of blocking the crawl.
+	StreamTorrents(ctx context.Context, in *StreamTorrentsRequest, opts ...grpc.CallOption) (TorrentService_StreamTorrentsClient, error)
+}
+
+type torrentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTorrentServiceClient(cc grpc.ClientConnInterface) TorrentServiceClient {
+	return &torrentServiceClient{cc}
+}
+
+func (c *torrentServiceClient) StreamTorrents(ctx context.Context, in *StreamTorrentsRequest, opts ...grpc.CallOption) (TorrentService_StreamTorrentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TorrentService_ServiceDesc.Streams[0], TorrentService_StreamTorrents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &torrentServiceStreamTorrentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TorrentService_StreamTorrentsClient interface {
+	Recv() (*BitTorrent, error)
+	grpc.ClientStream
+}
+
+type torrentServiceStreamTorrentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *torrentServiceStreamTorrentsClient) Recv() (*BitTorrent, error) {
+	m := new(BitTorrent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TorrentServiceServer is the server API for TorrentService service.
+// All implementations must embed UnimplementedTorrentServiceServer
+// for forward compatibility
+type TorrentServiceServer interface {
+	// StreamTorrents streams the torrents discovered after the call. A client
+	// reading too slowly misses the torrents instead of blocking the crawl.
+	StreamTorrents(*StreamTorrentsRequest, TorrentService_StreamTorrentsServer) error
+	mustEmbedUnimplementedTorrentServiceServer()
+}
+
+// UnimplementedTorrentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTorrentServiceServer struct {
+}
+
+func (UnimplementedTorrentServiceServer) StreamTorrents(*StreamTorrentsRequest, TorrentService_StreamTorrentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTorrents not implemented")
+}
+func (UnimplementedTorrentServiceServer) mustEmbedUnimplementedTorrentServiceServer() {}
+
+// UnsafeTorrentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TorrentServiceServer will
+// result in compilation errors.
+type UnsafeTorrentServiceServer interface {
+	mustEmbedUnimplementedTorrentServiceServer()
+}
+
+func RegisterTorrentServiceServer(s grpc.ServiceRegistrar, srv TorrentServiceServer) {
+	s.RegisterService(&TorrentService_ServiceDesc, srv)
+}
+
+func _TorrentService_StreamTorrents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamTorrentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TorrentServiceServer).StreamTorrents(m, &torrentServiceStreamTorrentsServer{stream})
+}
+
+type TorrentService_StreamTorrentsServer interface {
+	Send(*BitTorrent) error
+	grpc.ServerStream
+}
+
+type torrentServiceStreamTorrentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *torrentServiceStreamTorrentsServer) Send(m *BitTorrent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TorrentService_ServiceDesc is the grpc.ServiceDesc for TorrentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TorrentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "torrent.TorrentService",
+	HandlerType: (*TorrentServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTorrents",
+			Handler:       _TorrentService_StreamTorrents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "torrent.proto",
+}